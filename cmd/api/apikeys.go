@@ -0,0 +1,106 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/obrikash/swimming_pool/internal/data"
+	"github.com/obrikash/swimming_pool/internal/validator"
+)
+
+func (app *application) createAPIKeyHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Name       string     `json:"name"`
+		Scopes     []string   `json:"scopes"`
+		AllowedIPs []string   `json:"allowed_ips"`
+		ExpiresAt  *time.Time `json:"expires_at"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	key := &data.APIKey{
+		Name:       input.Name,
+		Scopes:     input.Scopes,
+		AllowedIPs: input.AllowedIPs,
+		ExpiresAt:  input.ExpiresAt,
+	}
+
+	if user := app.contextGetUser(r); !user.IsAnonymous() {
+		key.CreatedBy = &user.ID
+	}
+
+	// a key can't be granted more than its creator is allowed to do
+	permissions, err := app.contextPermissions(r)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+
+	if data.ValidateAPIKey(v, key, permissions); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = app.models.APIKeys.Insert(key, app.newAuditEntry(r, data.AuditAPIKeyCreate, "api_key"))
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	headers := make(http.Header)
+	headers.Set("Location", fmt.Sprintf("/v1/admin/api-keys/%d", key.ID))
+
+	err = app.writeJSON(w, http.StatusCreated, envelope{"api_key": key}, headers)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) listAPIKeysHandler(w http.ResponseWriter, r *http.Request) {
+	keys, err := app.models.APIKeys.GetAll()
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"api_keys": keys}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) revokeAPIKeyHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	audit := app.newAuditEntry(r, data.AuditAPIKeyRevoke, "api_key")
+	audit.TargetID = strconv.FormatInt(id, 10)
+
+	err = app.models.APIKeys.Revoke(id, audit)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"message": "api key successfully revoked"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"net/http"
+
+	"github.com/obrikash/swimming_pool/internal/data"
+	"github.com/obrikash/swimming_pool/internal/validator"
+)
+
+// newAuditEntry describes an action taken by whoever made the request. The
+// target ID and changes are filled in by the caller or by the model once the
+// record is known.
+func (app *application) newAuditEntry(r *http.Request, action, targetType string) *data.AuditEntry {
+	entry := &data.AuditEntry{
+		Action:     action,
+		TargetType: targetType,
+		IP:         app.clientIP(r),
+		RequestID:  app.contextGetRequestID(r),
+	}
+
+	if key := app.contextGetAPIKey(r); key != nil {
+		entry.APIKeyID = &key.ID
+	} else if user := app.contextGetUser(r); !user.IsAnonymous() {
+		entry.ActorID = &user.ID
+	}
+
+	return entry
+}
+
+func (app *application) listAuditLogHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		data.AuditFilters
+		data.Filters
+	}
+
+	v := validator.New()
+
+	qs := r.URL.Query()
+
+	input.ActorID = int64(app.readInt(qs, "actor_id", 0, v))
+	input.Action = app.readString(qs, "action", "")
+	input.TargetType = app.readString(qs, "target_type", "")
+	input.TargetID = app.readString(qs, "target_id", "")
+	input.From = app.readTime(qs, "from", v)
+	input.To = app.readTime(qs, "to", v)
+
+	input.Filters.Page = app.readInt(qs, "page", 1, v)
+	input.Filters.PageSize = app.readInt(qs, "page_size", 20, v)
+	input.Filters.Sort = "-created_at"
+	input.Filters.SortSafelist = []string{"-created_at"}
+
+	if input.From != nil && input.To != nil {
+		v.Check(input.From.Before(*input.To), "to", "must be after from")
+	}
+
+	if data.ValidateFilters(v, input.Filters); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	entries, metadata, err := app.models.Audit.GetAll(input.AuditFilters, input.Filters)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"audit": entries, "metadata": metadata}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
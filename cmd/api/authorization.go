@@ -0,0 +1,86 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/obrikash/swimming_pool/internal/data"
+)
+
+var errNotOwner = errors.New("resource is not owned by the user")
+
+// authorizeGroup checks that the user may manage the group. Users with the
+// ownership:bypass permission may manage any group, trainers only the groups
+// they run at a pool they are assigned to today.
+func (app *application) authorizeGroup(r *http.Request, group *data.Group) error {
+	permissions, err := app.contextPermissions(r)
+	if err != nil {
+		return err
+	}
+
+	if permissions.Include("ownership:bypass") {
+		return nil
+	}
+
+	user := app.contextGetUser(r)
+	if user.IsAnonymous() {
+		return errNotOwner
+	}
+
+	trainer, err := app.models.Users.GetTrainer(user.ID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			return errNotOwner
+		default:
+			return err
+		}
+	}
+
+	if trainer.ID != group.Trainer.ID || !trainer.WorksAt(group.Pool) {
+		return errNotOwner
+	}
+
+	return nil
+}
+
+// authorizeSchedule checks that the user may manage the group the schedule belongs to.
+func (app *application) authorizeSchedule(r *http.Request, schedule *data.Schedule) error {
+	group, err := app.models.Groups.Get(schedule.GroupID)
+	if err != nil {
+		return err
+	}
+
+	return app.authorizeGroup(r, group)
+}
+
+// authorizeTrainingSession checks that the user may manage the group the
+// session belongs to, e.g. to mark its attendance. The substitute assigned
+// to the session may manage it as well.
+func (app *application) authorizeTrainingSession(r *http.Request, session *data.TrainingSession) error {
+	if session.SubstituteTrainerID != nil {
+		trainer, err := app.currentTrainer(r)
+		switch {
+		case err == nil && trainer.ID == *session.SubstituteTrainerID:
+			return nil
+		case err != nil && !errors.Is(err, errNotOwner):
+			return err
+		}
+	}
+
+	group, err := app.models.Groups.Get(session.GroupID)
+	if err != nil {
+		return err
+	}
+
+	return app.authorizeGroup(r, group)
+}
+
+func (app *application) authorizationErrorResponse(w http.ResponseWriter, r *http.Request, err error) {
+	switch {
+	case errors.Is(err, errNotOwner):
+		app.notPermittedResponse(w, r)
+	default:
+		app.serverErrorResponse(w, r, err)
+	}
+}
@@ -0,0 +1,221 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/obrikash/swimming_pool/internal/data"
+	"github.com/obrikash/swimming_pool/internal/validator"
+)
+
+func (app *application) showOpeningHoursHandler(w http.ResponseWriter, r *http.Request) {
+	pool, ok := app.readPoolParam(w, r)
+	if !ok {
+		return
+	}
+
+	hours, err := app.models.Calendar.GetOpeningHours(pool.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"opening_hours": hours}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// updateOpeningHoursHandler replaces the weekly opening hours of a pool. Days
+// left out are closed, an empty list keeps the pool open around the clock.
+// Upcoming sessions that no longer fit are cancelled.
+func (app *application) updateOpeningHoursHandler(w http.ResponseWriter, r *http.Request) {
+	pool, ok := app.readPoolParam(w, r)
+	if !ok {
+		return
+	}
+
+	var input struct {
+		Hours []*data.OpeningHours `json:"opening_hours"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	if input.Hours == nil {
+		input.Hours = []*data.OpeningHours{}
+	}
+
+	v := validator.New()
+
+	if data.ValidateOpeningHours(v, input.Hours); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	cancelled, err := app.models.Calendar.ReplaceOpeningHours(pool.ID, input.Hours, app.newAuditEntry(r, data.AuditPoolHours, "pool"))
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"opening_hours": input.Hours, "cancelled_sessions": cancelled}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// listPoolCalendarHandler returns the hours of a pool for each day between
+// from and to, the next 30 days by default.
+func (app *application) listPoolCalendarHandler(w http.ResponseWriter, r *http.Request) {
+	pool, ok := app.readPoolParam(w, r)
+	if !ok {
+		return
+	}
+
+	v := validator.New()
+
+	qs := r.URL.Query()
+
+	from := app.readDate(qs, "from", data.Today(pool.Location()), v)
+	to := app.readDate(qs, "to", data.Date{Time: from.AddDate(0, 0, 30)}, v)
+
+	if data.ValidateSessionRange(v, from, to); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	days, err := app.models.Calendar.GetHours(pool.ID, from, to)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"days": days}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// createCalendarDayHandler closes a pool on a date or sets special hours for
+// it, cancelling the sessions of that day that fall outside.
+func (app *application) createCalendarDayHandler(w http.ResponseWriter, r *http.Request) {
+	pool, ok := app.readPoolParam(w, r)
+	if !ok {
+		return
+	}
+
+	var input struct {
+		Date     data.Date `json:"date"`
+		Kind     string    `json:"kind"`
+		OpensAt  *string   `json:"opens_at"`
+		ClosesAt *string   `json:"closes_at"`
+		Note     string    `json:"note"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	day := &data.CalendarDay{
+		PoolID:   &pool.ID,
+		Date:     input.Date,
+		Kind:     input.Kind,
+		OpensAt:  input.OpensAt,
+		ClosesAt: input.ClosesAt,
+		Note:     input.Note,
+	}
+
+	v := validator.New()
+
+	if data.ValidateCalendarDay(v, day); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	cancelled, err := app.models.Calendar.InsertDay(day, app.newAuditEntry(r, data.AuditCalendarCreate, "calendar_day"))
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrDuplicateCalendarDay):
+			app.errorResponse(w, r, http.StatusConflict, err.Error())
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusCreated, envelope{"calendar_day": day, "cancelled_sessions": cancelled}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// deleteCalendarDayHandler removes an exception set for a pool. The public
+// calendar can only be changed by importing it again.
+func (app *application) deleteCalendarDayHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	day, err := app.models.Calendar.GetDay(id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	if day.PoolID == nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	err = app.models.Calendar.DeleteDay(day, app.newAuditEntry(r, data.AuditCalendarDelete, "calendar_day"))
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"message": "calendar day successfully deleted"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// readPoolParam returns the pool in the :id parameter, writing a not found
+// response when there is none.
+func (app *application) readPoolParam(w http.ResponseWriter, r *http.Request) (*data.Pool, bool) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return nil, false
+	}
+
+	pool, err := app.models.Pools.Get(id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return nil, false
+	}
+
+	return pool, true
+}
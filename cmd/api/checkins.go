@@ -0,0 +1,74 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/obrikash/swimming_pool/internal/data"
+	"github.com/obrikash/swimming_pool/internal/validator"
+)
+
+// checkInHandler lets a member in at the entrance of a pool. It is meant for
+// turnstiles and front desks using an API key with the checkin:write scope.
+// The member needs an active subscription and a session of one of their
+// groups at the pool that opens for check-in by the pool's clock.
+func (app *application) checkInHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		UserID int64 `json:"user_id"`
+		PoolID int64 `json:"pool_id"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+
+	v.Check(input.UserID > 0, "user_id", "must be provided")
+	v.Check(input.PoolID > 0, "pool_id", "must be provided")
+
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	pool, err := app.models.Pools.Get(input.PoolID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			v.AddError("pool_id", "must be an existing pool")
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	// the sessions of today may not have been generated yet
+	day := data.Today(pool.Location())
+
+	err = app.models.TrainingSessions.Generate(day, day)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	session, mark, err := app.models.TrainingSessions.CheckIn(input.UserID, pool.ID, time.Now())
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrNoSessionToCheckIn), errors.Is(err, data.ErrNoActiveSubscription), errors.Is(err, data.ErrPoolClosed):
+			app.errorResponse(w, r, http.StatusConflict, err.Error())
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"session": session, "attendance": mark}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
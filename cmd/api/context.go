@@ -9,7 +9,12 @@ import (
 
 type contextKey string
 
-const userContextKey = contextKey("user")
+const (
+	userContextKey    = contextKey("user")
+	apiKeyContextKey  = contextKey("apiKey")
+	sessionContextKey = contextKey("session")
+	requestIDKey      = contextKey("requestID")
+)
 
 func (app *application) contextSetUser(r *http.Request, user *data.User) *http.Request {
 	ctx := context.WithValue(r.Context(), userContextKey, user)
@@ -24,3 +29,37 @@ func (app *application) contextGetUser(r *http.Request) *data.User {
 
 	return user
 }
+
+func (app *application) contextSetAPIKey(r *http.Request, key *data.APIKey) *http.Request {
+	ctx := context.WithValue(r.Context(), apiKeyContextKey, key)
+	return r.WithContext(ctx)
+}
+
+// contextGetAPIKey returns the API key the request was authenticated with,
+// or nil for requests made by users.
+func (app *application) contextGetAPIKey(r *http.Request) *data.APIKey {
+	key, _ := r.Context().Value(apiKeyContextKey).(*data.APIKey)
+	return key
+}
+
+func (app *application) contextSetSessionID(r *http.Request, id int64) *http.Request {
+	ctx := context.WithValue(r.Context(), sessionContextKey, id)
+	return r.WithContext(ctx)
+}
+
+// contextGetSessionID returns the ID of the session the request's JWT
+// belongs to, or 0 for requests without one.
+func (app *application) contextGetSessionID(r *http.Request) int64 {
+	id, _ := r.Context().Value(sessionContextKey).(int64)
+	return id
+}
+
+func (app *application) contextSetRequestID(r *http.Request, id string) *http.Request {
+	ctx := context.WithValue(r.Context(), requestIDKey, id)
+	return r.WithContext(ctx)
+}
+
+func (app *application) contextGetRequestID(r *http.Request) string {
+	id, _ := r.Context().Value(requestIDKey).(string)
+	return id
+}
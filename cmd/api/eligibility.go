@@ -0,0 +1,87 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/obrikash/swimming_pool/internal/data"
+	"github.com/obrikash/swimming_pool/internal/validator"
+)
+
+func (app *application) listEligibilityHandler(w http.ResponseWriter, r *http.Request) {
+	rules, err := app.models.Eligibility.GetAll()
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"eligibility": rules}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// updateEligibilityHandler replaces the rules of a category. Members who
+// enrolled before are not affected, the rules apply to new enrollments.
+func (app *application) updateEligibilityHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	eligibility, err := app.models.Eligibility.Get(id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	var input struct {
+		MinAge        *int `json:"min_age"`
+		MaxAge        *int `json:"max_age"`
+		MinSkillLevel int  `json:"min_skill_level"`
+	}
+
+	err = app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	before := *eligibility
+
+	eligibility.MinAge = input.MinAge
+	eligibility.MaxAge = input.MaxAge
+	eligibility.MinSkillLevel = input.MinSkillLevel
+
+	v := validator.New()
+
+	if data.ValidateEligibility(v, eligibility); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	audit := app.newAuditEntry(r, data.AuditEligibility, "category")
+
+	err = audit.SetChanges(before, eligibility)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.models.Eligibility.Upsert(eligibility, audit)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"eligibility": eligibility}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
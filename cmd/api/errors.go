@@ -3,13 +3,17 @@ package main
 import (
 	"fmt"
 	"log/slog"
+	"math"
 	"net/http"
+	"strconv"
+	"time"
 )
 
 func (app *application) logError(r *http.Request, err error) {
 	app.logger.Error(err.Error(),
 		slog.String("request_method", r.Method),
-		slog.String("request_url", r.URL.String()))
+		slog.String("request_url", r.URL.String()),
+		slog.String("request_id", app.contextGetRequestID(r)))
 }
 
 func (app *application) errorResponse(w http.ResponseWriter, r *http.Request, status int, message any) {
@@ -66,3 +70,26 @@ func (app *application) authenticationRequiredResponse(w http.ResponseWriter, r
 	message := "you must be authenticated to access this resource"
 	app.errorResponse(w, r, http.StatusUnauthorized, message)
 }
+
+func (app *application) inactiveAccountResponse(w http.ResponseWriter, r *http.Request) {
+	message := "your user account has been deactivated"
+	app.errorResponse(w, r, http.StatusForbidden, message)
+}
+
+func (app *application) rateLimitExceededResponse(w http.ResponseWriter, r *http.Request) {
+	message := "rate limit exceeded"
+	app.errorResponse(w, r, http.StatusTooManyRequests, message)
+}
+
+func (app *application) accountLockedResponse(w http.ResponseWriter, r *http.Request, lockedUntil time.Time) {
+	retryAfter := int(math.Ceil(time.Until(lockedUntil).Seconds()))
+	w.Header().Set("Retry-After", strconv.Itoa(max(retryAfter, 1)))
+
+	message := fmt.Sprintf("too many failed login attempts, the account is locked until %s", lockedUntil.Format(time.RFC3339))
+	app.errorResponse(w, r, http.StatusTooManyRequests, message)
+}
+
+func (app *application) groupArchivedResponse(w http.ResponseWriter, r *http.Request) {
+	message := "the group is archived and can no longer be changed"
+	app.errorResponse(w, r, http.StatusConflict, message)
+}
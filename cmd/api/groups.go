@@ -4,8 +4,11 @@ import (
 	"errors"
 	"fmt"
 	"net/http"
+	"strconv"
+	"time"
 
 	"github.com/obrikash/swimming_pool/internal/data"
+	"github.com/obrikash/swimming_pool/internal/validator"
 )
 
 func (app *application) listGroupsHandler(w http.ResponseWriter, r *http.Request) {
@@ -36,10 +39,18 @@ func (app *application) addGroupToPoolHandler(w http.ResponseWriter, r *http.Req
 
 	group := data.Group{Pool: input.PoolID, Category: input.CategoryID, Trainer: data.User{ID: input.TrainerID}}
 
-	err = app.models.Groups.AddToPool(&group)
+	err = app.authorizeGroup(r, &group)
+	if err != nil {
+		app.authorizationErrorResponse(w, r, err)
+		return
+	}
+
+	audit := app.newAuditEntry(r, data.AuditGroupCreate, "group")
+
+	err = app.models.Groups.AddToPool(&group, audit)
 	if err != nil {
 		switch {
-		case errors.Is(err, data.ErrTrainerOnlyOnePool):
+		case errors.Is(err, data.ErrTrainerNotInPool):
 			app.badRequestResponse(w, r, err)
 		default:
 			app.serverErrorResponse(w, r, err)
@@ -53,3 +64,284 @@ func (app *application) addGroupToPoolHandler(w http.ResponseWriter, r *http.Req
 		return
 	}
 }
+
+func (app *application) updateGroupHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	group, err := app.models.Groups.Get(id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.authorizeGroup(r, group)
+	if err != nil {
+		app.authorizationErrorResponse(w, r, err)
+		return
+	}
+
+	if group.ArchivedAt != nil {
+		app.groupArchivedResponse(w, r)
+		return
+	}
+
+	var input struct {
+		CategoryID *int64 `json:"category_id"`
+		TrainerID  *int64 `json:"trainer_id"`
+	}
+
+	err = app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	before := map[string]int64{"category_id": group.Category, "trainer_id": group.Trainer.ID}
+
+	if input.CategoryID != nil {
+		group.Category = *input.CategoryID
+	}
+
+	if input.TrainerID != nil {
+		group.Trainer = data.User{ID: *input.TrainerID}
+	}
+
+	v := validator.New()
+
+	v.Check(group.Category > 0, "category_id", "must be a positive integer")
+	v.Check(group.Trainer.ID > 0, "trainer_id", "must be a positive integer")
+
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	// a trainer may change their own group but not hand it over to someone
+	// else, that is up to the administration
+	err = app.authorizeGroup(r, group)
+	if err != nil {
+		app.authorizationErrorResponse(w, r, err)
+		return
+	}
+
+	audit := app.newAuditEntry(r, data.AuditGroupUpdate, "group")
+	audit.TargetID = strconv.FormatInt(group.ID, 10)
+
+	err = audit.SetChanges(before, map[string]int64{"category_id": group.Category, "trainer_id": group.Trainer.ID})
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.models.Groups.Update(group, audit)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrTrainerNotInPool), errors.Is(err, data.ErrDuplicateGroup):
+			app.badRequestResponse(w, r, err)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"group": group}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// archiveGroupHandler closes the group. Its members are told by email that
+// their sessions have ended.
+func (app *application) archiveGroupHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	group, err := app.models.Groups.Get(id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.authorizeGroup(r, group)
+	if err != nil {
+		app.authorizationErrorResponse(w, r, err)
+		return
+	}
+
+	pool, err := app.models.Pools.Get(group.Pool)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	members, err := app.models.Groups.GetMembers(group.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	audit := app.newAuditEntry(r, data.AuditGroupArchive, "group")
+	audit.TargetID = strconv.FormatInt(group.ID, 10)
+
+	err = app.models.Groups.Archive(group, audit)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrGroupArchived):
+			app.groupArchivedResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	app.background(func() {
+		for _, member := range members {
+			data := map[string]any{
+				"fullName": member.FullName,
+				"groupID":  group.ID,
+				"pool":     pool.Name,
+			}
+
+			err := app.mailer.Send(member.Email, "group_archived.tmpl", data)
+			if err != nil {
+				app.logger.Error(err.Error())
+			}
+		}
+	})
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"group": group}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// enrollGroupHandler adds a client to a group once they meet the eligibility
+// rules of its category. Clients enroll themselves; trainers and admins may
+// enroll someone else into a group they manage by passing user_id.
+func (app *application) enrollGroupHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	var input struct {
+		UserID *int64 `json:"user_id"`
+	}
+
+	err = app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	group, err := app.models.Groups.Get(id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	if group.ArchivedAt != nil {
+		app.groupArchivedResponse(w, r)
+		return
+	}
+
+	user := app.contextGetUser(r)
+
+	if input.UserID != nil && *input.UserID != user.ID {
+		permissions, err := app.contextPermissions(r)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+
+		if !permissions.Include("groups:write") {
+			app.notPermittedResponse(w, r)
+			return
+		}
+
+		err = app.authorizeGroup(r, group)
+		if err != nil {
+			app.authorizationErrorResponse(w, r, err)
+			return
+		}
+
+		user, err = app.models.Users.Get(*input.UserID)
+		if err != nil {
+			switch {
+			case errors.Is(err, data.ErrRecordNotFound):
+				v := validator.New()
+				v.AddError("user_id", "must be an existing user")
+				app.failedValidationResponse(w, r, v.Errors)
+			default:
+				app.serverErrorResponse(w, r, err)
+			}
+			return
+		}
+	}
+
+	eligibility, err := app.models.Eligibility.Get(group.Category)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+
+	if data.CheckEligibility(v, user, eligibility, time.Now()); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	// the same rule as at check-in: only members with a subscription swim
+	subscribed, err := app.models.Subscriptions.HasActive(user.ID, time.Now())
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	if !subscribed {
+		app.errorResponse(w, r, http.StatusConflict, data.ErrNoActiveSubscription.Error())
+		return
+	}
+
+	err = app.models.Groups.AddMember(group.ID, user.ID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrAlreadyMember):
+			app.errorResponse(w, r, http.StatusConflict, err.Error())
+		case errors.Is(err, data.ErrGroupArchived):
+			app.groupArchivedResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusCreated, envelope{"message": fmt.Sprintf("user with ID %d enrolled in group with ID %d", user.ID, group.ID)}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
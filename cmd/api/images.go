@@ -0,0 +1,238 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/julienschmidt/httprouter"
+	"github.com/obrikash/swimming_pool/internal/data"
+	"github.com/obrikash/swimming_pool/internal/images"
+	"github.com/obrikash/swimming_pool/internal/storage"
+	"github.com/obrikash/swimming_pool/internal/validator"
+)
+
+const imagesURLPrefix = "/v1/images/"
+
+// storeUploadedImage reads the "image" field of a multipart request, processes
+// it and stores the original and the thumbnail under the given key prefix.
+// It returns the URLs both variants are served from.
+func (app *application) storeUploadedImage(w http.ResponseWriter, r *http.Request, prefix string) (string, string, error) {
+	r.Body = http.MaxBytesReader(w, r.Body, app.config.upload.maxBytes)
+
+	err := r.ParseMultipartForm(app.config.upload.maxBytes)
+	if err != nil {
+		var maxBytesError *http.MaxBytesError
+		switch {
+		case errors.As(err, &maxBytesError):
+			return "", "", fmt.Errorf("body must not be larger than %d bytes", maxBytesError.Limit)
+		default:
+			return "", "", errors.New("body must be a valid multipart form")
+		}
+	}
+	defer r.MultipartForm.RemoveAll()
+
+	file, _, err := r.FormFile("image")
+	if err != nil {
+		return "", "", errors.New("body must contain an image file in the \"image\" field")
+	}
+	defer file.Close()
+
+	processed, err := images.Process(file)
+	if err != nil {
+		return "", "", err
+	}
+
+	randomBytes := make([]byte, 8)
+	_, err = rand.Read(randomBytes)
+	if err != nil {
+		return "", "", err
+	}
+
+	base := prefix + "/" + hex.EncodeToString(randomBytes)
+	originalKey := base + processed.Original.Extension
+	thumbnailKey := base + "_thumb" + processed.Thumbnail.Extension
+
+	err = app.storage.Put(r.Context(), originalKey, bytes.NewReader(processed.Original.Data), processed.Original.ContentType)
+	if err != nil {
+		return "", "", err
+	}
+
+	err = app.storage.Put(r.Context(), thumbnailKey, bytes.NewReader(processed.Thumbnail.Data), processed.Thumbnail.ContentType)
+	if err != nil {
+		app.deleteStoredImages(imagesURLPrefix + originalKey)
+		return "", "", err
+	}
+
+	return imagesURLPrefix + originalKey, imagesURLPrefix + thumbnailKey, nil
+}
+
+// uploadErrorResponse maps errors returned by storeUploadedImage to responses.
+func (app *application) uploadErrorResponse(w http.ResponseWriter, r *http.Request, err error) {
+	v := validator.New()
+
+	switch {
+	case errors.Is(err, images.ErrUnsupportedType):
+		v.AddError("image", "must be a JPEG, PNG or GIF image")
+		app.failedValidationResponse(w, r, v.Errors)
+	case errors.Is(err, images.ErrInvalidImage):
+		v.AddError("image", "must be a valid image of a reasonable size")
+		app.failedValidationResponse(w, r, v.Errors)
+	case strings.HasPrefix(err.Error(), "body "):
+		app.badRequestResponse(w, r, err)
+	default:
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// deleteStoredImages removes replaced images in the background. Failures are
+// only logged, an orphaned file is not worth failing the request for.
+func (app *application) deleteStoredImages(urls ...string) {
+	app.background(func() {
+		for _, url := range urls {
+			key, ok := strings.CutPrefix(url, imagesURLPrefix)
+			if !ok || key == "" {
+				continue
+			}
+
+			err := app.storage.Delete(context.Background(), key)
+			if err != nil {
+				app.logger.Error(err.Error())
+			}
+		}
+	})
+}
+
+func (app *application) uploadAvatarHandler(w http.ResponseWriter, r *http.Request) {
+	user := app.contextGetUser(r)
+
+	image, thumbnail, err := app.storeUploadedImage(w, r, "avatars/"+strconv.FormatInt(user.ID, 10))
+	if err != nil {
+		app.uploadErrorResponse(w, r, err)
+		return
+	}
+
+	oldImage, oldThumbnail := user.Image, user.Thumbnail
+	user.Image = image
+	user.Thumbnail = thumbnail
+
+	err = app.models.Users.Update(user)
+	if err != nil {
+		// the new images are not referenced by anything
+		app.deleteStoredImages(image, thumbnail)
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	app.deleteStoredImages(oldImage, oldThumbnail)
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"user": user}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) uploadPoolPhotoHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	pool, err := app.models.Pools.Get(id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	image, thumbnail, err := app.storeUploadedImage(w, r, "pools/"+strconv.FormatInt(pool.ID, 10))
+	if err != nil {
+		app.uploadErrorResponse(w, r, err)
+		return
+	}
+
+	oldImage, oldThumbnail := pool.Image, pool.Thumbnail
+	pool.Image = image
+	pool.Thumbnail = thumbnail
+
+	err = app.models.Pools.UpdateImage(pool)
+	if err != nil {
+		app.deleteStoredImages(image, thumbnail)
+
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	app.deleteStoredImages(oldImage, oldThumbnail)
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"pool": pool}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) serveImageHandler(w http.ResponseWriter, r *http.Request) {
+	params := httprouter.ParamsFromContext(r.Context())
+	key := strings.TrimPrefix(params.ByName("key"), "/")
+
+	// Every upload gets a fresh random key, so the content behind a key never
+	// changes and the key itself is a strong validator.
+	sum := sha256.Sum256([]byte(key))
+	etag := `"` + hex.EncodeToString(sum[:8]) + `"`
+
+	w.Header().Set("Cache-Control", "public, max-age=31536000, immutable")
+	w.Header().Set("ETag", etag)
+
+	if r.Header.Get("If-None-Match") == etag {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+
+	body, object, err := app.storage.Get(r.Context(), key)
+	if err != nil {
+		w.Header().Del("Cache-Control")
+		w.Header().Del("ETag")
+		switch {
+		case errors.Is(err, storage.ErrNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	defer body.Close()
+
+	if object.ContentType != "" {
+		w.Header().Set("Content-Type", object.ContentType)
+	}
+	if object.Size > 0 {
+		w.Header().Set("Content-Length", strconv.FormatInt(object.Size, 10))
+	}
+	if !object.ModTime.IsZero() {
+		w.Header().Set("Last-Modified", object.ModTime.UTC().Format(http.TimeFormat))
+	}
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+
+	_, err = io.Copy(w, body)
+	if err != nil {
+		app.logError(r, err)
+	}
+}
@@ -3,13 +3,20 @@ package main
 import (
 	"context"
 	"database/sql"
+	"encoding/json"
 	"flag"
+	"fmt"
 	"log/slog"
+	"os"
 	"strings"
 	"sync"
 	"time"
 
+	"github.com/obrikash/swimming_pool/internal/breached"
 	"github.com/obrikash/swimming_pool/internal/data"
+	"github.com/obrikash/swimming_pool/internal/mailer"
+	"github.com/obrikash/swimming_pool/internal/oidc"
+	"github.com/obrikash/swimming_pool/internal/storage"
 
 	_ "github.com/lib/pq"
 )
@@ -28,19 +35,68 @@ type config struct {
 	jwt struct {
 		secret string
 	}
+	storage struct {
+		backend  string
+		localDir string
+		s3       struct {
+			endpoint  string
+			bucket    string
+			region    string
+			accessKey string
+			secretKey string
+		}
+	}
+	login struct {
+		ipRPS               float64
+		ipBurst             int
+		lockoutThreshold    int
+		lockoutBaseDuration time.Duration
+	}
+	password struct {
+		argon2Memory      uint
+		argon2Iterations  uint
+		argon2Parallelism uint
+		minEntropy        float64
+		breachedList      string
+	}
+	twoFactor struct {
+		requiredForStaff bool
+		issuer           string
+	}
+	oidc struct {
+		configFile string
+		provider   oidc.Config
+	}
+	upload struct {
+		maxBytes int64
+	}
+	smtp struct {
+		host     string
+		port     int
+		username string
+		password string
+		sender   string
+	}
+	timeZone string
 }
 
 type application struct {
-	config config
-	logger *slog.Logger
-	models data.Models
-	wg     sync.WaitGroup
+	config  config
+	logger  *slog.Logger
+	models  data.Models
+	mailer  mailer.Mailer
+	storage storage.Storage
+
+	oidcProviders map[string]*oidc.Provider
+	loginLimiter  *ipLimiter
+	wg            sync.WaitGroup
 }
 
 func main() {
 	var cfg config
 
 	flag.IntVar(&cfg.port, "port", 4000, "API Server Port")
+	flag.StringVar(&cfg.timeZone, "time-zone", data.DefaultTimeZone, "IANA time zone for dates that don't belong to a pool, such as trainer time off and reports")
 	flag.StringVar(&cfg.db.dsn, "db-dsn", "", "PostgreSQL DSN")
 
 	flag.IntVar(&cfg.db.maxOpenConns, "db-max-open-conns", 25, "PostgreSQL max open connections")
@@ -48,6 +104,43 @@ func main() {
 	flag.StringVar(&cfg.db.maxIdleTime, "db-max-idle-time", "15m", "PostgreSQL max connection idle time")
 
 	flag.StringVar(&cfg.jwt.secret, "jwt-secret", "", "JWT secret")
+	flag.UintVar(&cfg.password.argon2Memory, "password-argon2-memory", uint(data.PasswordHashing.Memory), "Argon2id memory for password hashes in KiB")
+	flag.UintVar(&cfg.password.argon2Iterations, "password-argon2-iterations", uint(data.PasswordHashing.Iterations), "Argon2id iterations for password hashes")
+	flag.UintVar(&cfg.password.argon2Parallelism, "password-argon2-parallelism", uint(data.PasswordHashing.Parallelism), "Argon2id parallelism for password hashes")
+
+	flag.Float64Var(&cfg.password.minEntropy, "password-min-entropy", data.PasswordRules.MinEntropy, "Minimum estimated strength of new passwords in bits")
+	flag.StringVar(&cfg.password.breachedList, "password-breached-list", "", "File with breached password hashes built by cmd/breached (disabled if empty)")
+
+	flag.Float64Var(&cfg.login.ipRPS, "login-ip-rps", 0.2, "Requests per second allowed per IP across the login endpoints")
+	flag.IntVar(&cfg.login.ipBurst, "login-ip-burst", 10, "Burst allowed per IP across the login endpoints")
+	flag.IntVar(&cfg.login.lockoutThreshold, "login-lockout-threshold", 5, "Failed logins before an account is locked")
+	flag.DurationVar(&cfg.login.lockoutBaseDuration, "login-lockout-duration", time.Minute, "Duration of the first account lockout, doubled on every next one")
+
+	flag.BoolVar(&cfg.twoFactor.requiredForStaff, "2fa-required-staff", false, "Require two-factor authentication for trainers and admins")
+	flag.StringVar(&cfg.twoFactor.issuer, "2fa-issuer", "Swimming Pool", "Issuer name shown in authenticator apps")
+
+	flag.StringVar(&cfg.oidc.configFile, "oidc-config", "", "Path to a JSON file with a list of OpenID Connect providers")
+	flag.StringVar(&cfg.oidc.provider.Name, "oidc-name", "default", "OpenID Connect provider name")
+	flag.StringVar(&cfg.oidc.provider.Issuer, "oidc-issuer", "", "OpenID Connect issuer URL")
+	flag.StringVar(&cfg.oidc.provider.ClientID, "oidc-client-id", "", "OpenID Connect client ID")
+	flag.StringVar(&cfg.oidc.provider.ClientSecret, "oidc-client-secret", "", "OpenID Connect client secret")
+	flag.StringVar(&cfg.oidc.provider.RedirectURL, "oidc-redirect-url", "", "OpenID Connect redirect URL")
+
+	flag.StringVar(&cfg.storage.backend, "storage-backend", "local", "Storage backend for uploaded files (local|s3)")
+	flag.StringVar(&cfg.storage.localDir, "storage-local-dir", "./uploads", "Directory for the local storage backend")
+	flag.StringVar(&cfg.storage.s3.endpoint, "storage-s3-endpoint", "http://localhost:9000", "S3-compatible endpoint")
+	flag.StringVar(&cfg.storage.s3.bucket, "storage-s3-bucket", "swimming-pool", "S3 bucket")
+	flag.StringVar(&cfg.storage.s3.region, "storage-s3-region", "us-east-1", "S3 region")
+	flag.StringVar(&cfg.storage.s3.accessKey, "storage-s3-access-key", "", "S3 access key")
+	flag.StringVar(&cfg.storage.s3.secretKey, "storage-s3-secret-key", "", "S3 secret key")
+	flag.Int64Var(&cfg.upload.maxBytes, "upload-max-bytes", 5<<20, "Maximum size of an uploaded file in bytes")
+
+	flag.StringVar(&cfg.smtp.host, "smtp-host", "localhost", "SMTP host")
+	flag.IntVar(&cfg.smtp.port, "smtp-port", 25, "SMTP port")
+	flag.StringVar(&cfg.smtp.username, "smtp-username", "", "SMTP username")
+	flag.StringVar(&cfg.smtp.password, "smtp-password", "", "SMTP password")
+	flag.StringVar(&cfg.smtp.sender, "smtp-sender", "Swimming Pool <no-reply@swimming-pool.local>", "SMTP sender")
+
 	flag.Func("cors-trusted-origins", "Trusted CORS origins (space separated)", func(val string) error {
 		cfg.cors.trustedOrigins = strings.Fields(val)
 		return nil
@@ -56,6 +149,36 @@ func main() {
 	flag.Parse()
 
 	logger := slog.Default()
+
+	if cfg.password.argon2Memory < 8*cfg.password.argon2Parallelism || cfg.password.argon2Iterations < 1 ||
+		cfg.password.argon2Parallelism < 1 || cfg.password.argon2Parallelism > 255 {
+		logger.Error("invalid Argon2id parameters")
+		os.Exit(1)
+	}
+
+	loc, err := time.LoadLocation(cfg.timeZone)
+	if err != nil || cfg.timeZone == "" || cfg.timeZone == "Local" {
+		logger.Error("invalid time zone", slog.String("time_zone", cfg.timeZone))
+		os.Exit(1)
+	}
+
+	data.DefaultLocation = loc
+
+	data.PasswordHashing.Memory = uint32(cfg.password.argon2Memory)
+	data.PasswordHashing.Iterations = uint32(cfg.password.argon2Iterations)
+	data.PasswordHashing.Parallelism = uint8(cfg.password.argon2Parallelism)
+	data.PasswordRules.MinEntropy = cfg.password.minEntropy
+
+	if cfg.password.breachedList != "" {
+		list, err := breached.Open(cfg.password.breachedList)
+		if err != nil {
+			logger.Error("Fail loading breached password list", slog.Any("error", err))
+			os.Exit(1)
+		}
+
+		data.PasswordRules.Breached = list
+		logger.Info("breached password list loaded", slog.Int("hashes", list.Len()))
+	}
 	db, err := openDB(cfg)
 	if err != nil {
 		logger.Error("Fail opening db connection", slog.Any("error", err))
@@ -66,10 +189,27 @@ func main() {
 
 	logger.Info("Database connection pool established")
 
+	store, err := openStorage(cfg)
+	if err != nil {
+		logger.Error("Fail opening storage", slog.Any("error", err))
+		panic(err)
+	}
+
+	oidcProviders, err := loadOIDCProviders(cfg)
+	if err != nil {
+		logger.Error("Fail loading OpenID Connect providers", slog.Any("error", err))
+		panic(err)
+	}
+
 	app := application{
-		config: cfg,
-		logger: logger,
-		models: data.NewModels(db),
+		config:  cfg,
+		logger:  logger,
+		models:  data.NewModels(db),
+		mailer:  mailer.New(cfg.smtp.host, cfg.smtp.port, cfg.smtp.username, cfg.smtp.password, cfg.smtp.sender),
+		storage: store,
+
+		oidcProviders: oidcProviders,
+		loginLimiter:  newIPLimiter(cfg.login.ipRPS, cfg.login.ipBurst),
 	}
 
 	err = app.serve()
@@ -105,3 +245,53 @@ func openDB(cfg config) (*sql.DB, error) {
 
 	return db, nil
 }
+
+func openStorage(cfg config) (storage.Storage, error) {
+	switch cfg.storage.backend {
+	case "local":
+		return storage.NewLocal(cfg.storage.localDir)
+	case "s3":
+		s3 := cfg.storage.s3
+		return storage.NewS3(s3.endpoint, s3.bucket, s3.region, s3.accessKey, s3.secretKey)
+	default:
+		return nil, fmt.Errorf("unknown storage backend %q", cfg.storage.backend)
+	}
+}
+
+// loadOIDCProviders combines the providers listed in the -oidc-config file
+// with the one configured through the -oidc-* flags.
+func loadOIDCProviders(cfg config) (map[string]*oidc.Provider, error) {
+	var configs []oidc.Config
+
+	if cfg.oidc.configFile != "" {
+		f, err := os.ReadFile(cfg.oidc.configFile)
+		if err != nil {
+			return nil, err
+		}
+
+		err = json.Unmarshal(f, &configs)
+		if err != nil {
+			return nil, fmt.Errorf("parsing %s: %w", cfg.oidc.configFile, err)
+		}
+	}
+
+	if cfg.oidc.provider.Issuer != "" {
+		configs = append(configs, cfg.oidc.provider)
+	}
+
+	providers := make(map[string]*oidc.Provider)
+
+	for _, c := range configs {
+		if c.Name == "" || c.Issuer == "" || c.ClientID == "" || c.RedirectURL == "" {
+			return nil, fmt.Errorf("provider %q must have name, issuer, client_id and redirect_url", c.Name)
+		}
+
+		if _, exists := providers[c.Name]; exists {
+			return nil, fmt.Errorf("duplicate provider %q", c.Name)
+		}
+
+		providers[c.Name] = oidc.NewProvider(c)
+	}
+
+	return providers, nil
+}
@@ -1,15 +1,20 @@
 package main
 
 import (
+	"crypto/rand"
+	"encoding/hex"
 	"errors"
 	"fmt"
+	"net"
 	"net/http"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/golang-jwt/jwt/v5"
 	"github.com/obrikash/swimming_pool/internal/data"
+	"golang.org/x/time/rate"
 )
 
 func (app *application) recoverPanic(next http.Handler) http.Handler {
@@ -25,6 +30,103 @@ func (app *application) recoverPanic(next http.Handler) http.Handler {
 	})
 }
 
+// requestID tags every request with an ID, reusing the one set by a proxy in
+// front of us, so log lines and audit entries can be matched to requests.
+func (app *application) requestID(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get("X-Request-ID")
+		if id == "" || len(id) > 64 {
+			b := make([]byte, 16)
+			_, err := rand.Read(b)
+			if err != nil {
+				app.serverErrorResponse(w, r, err)
+				return
+			}
+			id = hex.EncodeToString(b)
+		}
+
+		w.Header().Set("X-Request-ID", id)
+		r = app.contextSetRequestID(r, id)
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// ipLimiter keeps a token bucket per client IP. One limiter is shared by all
+// the routes it guards, so an IP gets a single budget across them.
+type ipLimiter struct {
+	rps   float64
+	burst int
+
+	mu      sync.Mutex
+	clients map[string]*ipClient
+}
+
+type ipClient struct {
+	limiter  *rate.Limiter
+	lastSeen time.Time
+}
+
+// newIPLimiter creates a limiter and starts forgetting the IPs that haven't
+// been seen for a few minutes.
+func newIPLimiter(rps float64, burst int) *ipLimiter {
+	l := &ipLimiter{rps: rps, burst: burst, clients: make(map[string]*ipClient)}
+
+	go func() {
+		for {
+			time.Sleep(time.Minute)
+
+			l.mu.Lock()
+			for ip, client := range l.clients {
+				if time.Since(client.lastSeen) > 3*time.Minute {
+					delete(l.clients, ip)
+				}
+			}
+			l.mu.Unlock()
+		}
+	}()
+
+	return l
+}
+
+func (l *ipLimiter) allow(ip string) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	client, found := l.clients[ip]
+	if !found {
+		client = &ipClient{limiter: rate.NewLimiter(rate.Limit(l.rps), l.burst)}
+		l.clients[ip] = client
+	}
+
+	client.lastSeen = time.Now()
+
+	return client.limiter.Allow()
+}
+
+// rateLimitByIP throttles requests to next per client IP with the login
+// limiter. It is meant for expensive or sensitive endpoints such as the
+// token endpoint.
+func (app *application) rateLimitByIP(next http.HandlerFunc) http.HandlerFunc {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !app.loginLimiter.allow(app.clientIP(r)) {
+			app.rateLimitExceededResponse(w, r)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+func (app *application) clientIP(r *http.Request) string {
+	ip, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+
+	return ip
+}
+
 func (app *application) authenticate(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		w.Header().Add("Vary", "Authorization")
@@ -43,6 +145,12 @@ func (app *application) authenticate(next http.Handler) http.Handler {
 		}
 
 		tokenString := headerParts[1]
+
+		if strings.HasPrefix(tokenString, data.APIKeyPrefix) {
+			app.authenticateAPIKey(w, r, tokenString, next)
+			return
+		}
+
 		token, err := jwt.Parse(tokenString, func(token *jwt.Token) (any, error) {
 			if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
 				return nil, fmt.Errorf("unexpected method: %s", token.Header["alg"])
@@ -94,15 +202,77 @@ func (app *application) authenticate(next http.Handler) http.Handler {
 			return
 		}
 
+		if !user.Active {
+			app.inactiveAccountResponse(w, r)
+			return
+		}
+
+		sid, _ := claims["sid"].(string)
+		sessionID, err := strconv.ParseInt(sid, 10, 64)
+		if err != nil {
+			app.invalidAuthenticationTokenResponse(w, r)
+			return
+		}
+
+		err = app.models.Sessions.Touch(sessionID, user.ID, app.clientIP(r))
+		if err != nil {
+			switch {
+			case errors.Is(err, data.ErrRecordNotFound):
+				app.invalidAuthenticationTokenResponse(w, r)
+			default:
+				app.serverErrorResponse(w, r, err)
+			}
+			return
+		}
+
 		r = app.contextSetUser(r, user)
+		r = app.contextSetSessionID(r, sessionID)
 
 		next.ServeHTTP(w, r)
 
 	})
 }
 
+// authenticateAPIKey authenticates devices and integrations. Requests made
+// with an API key have no user, only the scopes granted to the key.
+func (app *application) authenticateAPIKey(w http.ResponseWriter, r *http.Request, plaintext string, next http.Handler) {
+	key, err := app.models.APIKeys.GetForPlaintext(plaintext)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.invalidAuthenticationTokenResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	ip := app.clientIP(r)
+
+	if !key.AllowsIP(ip) {
+		app.notPermittedResponse(w, r)
+		return
+	}
+
+	err = app.models.APIKeys.Touch(key.ID, ip)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	r = app.contextSetUser(r, data.AnonymousUser)
+	r = app.contextSetAPIKey(r, key)
+
+	next.ServeHTTP(w, r)
+}
+
 func (app *application) requireAuthenticatedUser(next http.HandlerFunc) http.HandlerFunc {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if app.contextGetAPIKey(r) != nil {
+			app.notPermittedResponse(w, r)
+			return
+		}
+
 		user := app.contextGetUser(r)
 
 		if user.IsAnonymous() {
@@ -114,19 +284,42 @@ func (app *application) requireAuthenticatedUser(next http.HandlerFunc) http.Han
 	})
 }
 
-func (app *application) requireAdmin(next http.HandlerFunc) http.HandlerFunc {
+func (app *application) requirePermission(code string, next http.HandlerFunc) http.HandlerFunc {
 	fn := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		user := app.contextGetUser(r)
+		permissions, err := app.contextPermissions(r)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
 
-		if user.RoleID != 3 {
+		if !permissions.Include(code) {
 			app.notPermittedResponse(w, r)
 			return
 		}
 
 		next.ServeHTTP(w, r)
+	})
 
+	requireUser := app.requireAuthenticatedUser(fn)
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if app.contextGetAPIKey(r) != nil {
+			fn.ServeHTTP(w, r)
+			return
+		}
+
+		requireUser.ServeHTTP(w, r)
 	})
-	return app.requireAuthenticatedUser(fn)
+}
+
+// contextPermissions returns the scopes of the API key the request was made
+// with, or the permissions of the user's role.
+func (app *application) contextPermissions(r *http.Request) (data.Permissions, error) {
+	if key := app.contextGetAPIKey(r); key != nil {
+		return key.Permissions(), nil
+	}
+
+	return app.models.Permissions.GetAllForUser(app.contextGetUser(r).ID)
 }
 
 func (app *application) enableCORS(next http.Handler) http.Handler {
@@ -0,0 +1,236 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+	"github.com/obrikash/swimming_pool/internal/data"
+	"github.com/obrikash/swimming_pool/internal/oidc"
+	"github.com/obrikash/swimming_pool/internal/validator"
+)
+
+var (
+	errUnverifiedEmail   = errors.New("the identity provider did not supply a verified email address")
+	errIdentityNotLinked = errors.New("an account with this email address already exists and can't be linked to the identity provider automatically")
+)
+
+func (app *application) startOIDCLoginHandler(w http.ResponseWriter, r *http.Request) {
+	params := httprouter.ParamsFromContext(r.Context())
+
+	provider, ok := app.oidcProviders[params.ByName("provider")]
+	if !ok {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	login := &data.OIDCLogin{
+		Provider: provider.Name(),
+		Expiry:   time.Now().Add(10 * time.Minute),
+	}
+
+	var err error
+
+	login.State, err = oidc.GenerateState()
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	login.Nonce, err = oidc.GenerateState()
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	login.CodeVerifier, err = oidc.GenerateVerifier()
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	authorizationURL, err := provider.AuthCodeURL(r.Context(), login.State, login.Nonce, login.CodeVerifier)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.models.Identities.InsertLogin(login)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusCreated, envelope{"authorization_url": authorizationURL, "state": login.State}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) createOIDCAuthenticationTokenHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		State string `json:"state"`
+		Code  string `json:"code"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+
+	v.Check(input.State != "", "state", "must be provided")
+	v.Check(input.Code != "", "code", "must be provided")
+
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	login, err := app.models.Identities.TakeLogin(input.State)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			v.AddError("state", "invalid or expired login state")
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	provider, ok := app.oidcProviders[login.Provider]
+	if !ok {
+		v.AddError("state", "invalid or expired login state")
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	claims, err := provider.Exchange(r.Context(), input.Code, login.CodeVerifier, login.Nonce)
+	if err != nil {
+		app.logError(r, err)
+		app.invalidCredentialsResponse(w, r)
+		return
+	}
+
+	user, err := app.userForIdentity(login.Provider, claims)
+	if err != nil {
+		switch {
+		case errors.Is(err, errUnverifiedEmail):
+			v.AddError("email", err.Error())
+			app.failedValidationResponse(w, r, v.Errors)
+		case errors.Is(err, errIdentityNotLinked):
+			app.errorResponse(w, r, http.StatusConflict, err.Error())
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	if !user.Active {
+		app.inactiveAccountResponse(w, r)
+		return
+	}
+
+	app.completeLogin(w, r, user)
+}
+
+// userForIdentity returns the user the external identity is linked to. An
+// identity seen for the first time is linked to the client with the same,
+// verified email address, or a new client account is created for it. Staff
+// accounts are never linked by email alone, since whoever controls the
+// address at the provider would take them over.
+func (app *application) userForIdentity(provider string, claims *oidc.Claims) (*data.User, error) {
+	userID, err := app.models.Identities.GetUserID(provider, claims.Subject)
+	if err == nil {
+		return app.models.Users.Get(userID)
+	}
+
+	if !errors.Is(err, data.ErrRecordNotFound) {
+		return nil, err
+	}
+
+	if claims.Email == "" || !claims.EmailVerified {
+		return nil, errUnverifiedEmail
+	}
+
+	claims.Email = data.NormalizeEmail(claims.Email)
+
+	user, err := app.models.Users.GetByEmail(claims.Email)
+	if err != nil {
+		if !errors.Is(err, data.ErrRecordNotFound) {
+			return nil, err
+		}
+
+		user, err = app.createUserForIdentity(claims)
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		role, err := app.models.Roles.Get(user.RoleID)
+		if err != nil {
+			return nil, err
+		}
+
+		if !linkableByEmail(role) {
+			return nil, errIdentityNotLinked
+		}
+	}
+
+	identity := &data.Identity{
+		UserID:   user.ID,
+		Provider: provider,
+		Subject:  claims.Subject,
+		Email:    claims.Email,
+	}
+
+	err = app.models.Identities.Insert(identity)
+	if err != nil {
+		return nil, err
+	}
+
+	return user, nil
+}
+
+// linkableByEmail reports whether an existing account with the role may be
+// linked to an external identity with the same email. Only plain clients
+// can be, any role granting more than that needs a password login.
+func linkableByEmail(role *data.Role) bool {
+	return role.Code == data.RoleClient
+}
+
+func (app *application) createUserForIdentity(claims *oidc.Claims) (*data.User, error) {
+	user := &data.User{
+		FullName: claims.Name,
+		Email:    claims.Email,
+	}
+
+	if user.FullName == "" {
+		user.FullName = claims.Email
+	}
+
+	// the account is only reachable through the identity provider until the
+	// user sets a password of their own
+	randomBytes := make([]byte, 32)
+	_, err := rand.Read(randomBytes)
+	if err != nil {
+		return nil, err
+	}
+
+	err = user.Password.Set(base64.RawURLEncoding.EncodeToString(randomBytes)[:64])
+	if err != nil {
+		return nil, err
+	}
+
+	err = app.models.Users.Insert(user)
+	if err != nil {
+		return nil, err
+	}
+
+	return user, nil
+}
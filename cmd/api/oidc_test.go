@@ -0,0 +1,27 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/obrikash/swimming_pool/internal/data"
+)
+
+func TestLinkableByEmail(t *testing.T) {
+	tests := []struct {
+		code string
+		want bool
+	}{
+		{code: data.RoleClient, want: true},
+		{code: data.RoleTrainer, want: false},
+		{code: data.RoleAdmin, want: false},
+		{code: "receptionist", want: false},
+	}
+
+	for _, tt := range tests {
+		role := &data.Role{Reference: data.Reference{Code: tt.code}}
+
+		if got := linkableByEmail(role); got != tt.want {
+			t.Errorf("linkableByEmail(%q) = %v, want %v", tt.code, got, tt.want)
+		}
+	}
+}
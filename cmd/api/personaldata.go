@@ -0,0 +1,265 @@
+package main
+
+import (
+	"archive/zip"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/obrikash/swimming_pool/internal/data"
+	"github.com/obrikash/swimming_pool/internal/storage"
+	"github.com/obrikash/swimming_pool/internal/validator"
+)
+
+// personalData is everything we keep about a user, as handed out on request
+// under 152-FZ.
+type personalData struct {
+	ExportedAt    time.Time             `json:"exported_at"`
+	Profile       *data.User            `json:"profile"`
+	Subscriptions []*data.Subscriptions `json:"subscriptions"`
+	Groups        []*data.Groups        `json:"groups"`
+	Sessions      []*data.Session       `json:"sessions"`
+	Attendance    []*data.Attendance    `json:"attendance"`
+}
+
+func (app *application) collectPersonalData(user *data.User) (*personalData, error) {
+	subscriptions, err := app.models.Subscriptions.UserSubscriptions(user.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	groups, err := app.models.Groups.GetAllForUser(user.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	sessions, err := app.models.Sessions.GetAllForUser(user.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	attendance, err := app.models.TrainingSessions.GetAttendanceForUser(user.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	pd := &personalData{
+		ExportedAt:    time.Now(),
+		Profile:       user,
+		Subscriptions: subscriptions,
+		Groups:        groups,
+		Sessions:      sessions,
+		Attendance:    attendance,
+	}
+
+	return pd, nil
+}
+
+// exportUserDataHandler returns the data of the current user either as JSON
+// or, with ?format=zip, as an archive that also contains the uploaded images.
+func (app *application) exportUserDataHandler(w http.ResponseWriter, r *http.Request) {
+	user := app.contextGetUser(r)
+
+	v := validator.New()
+
+	format := app.readString(r.URL.Query(), "format", "json")
+	v.Check(validator.PermittedValue(format, "json", "zip"), "format", "must be json or zip")
+
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	pd, err := app.collectPersonalData(user)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	filename := fmt.Sprintf("personal-data-%d-%s", user.ID, pd.ExportedAt.Format("20060102"))
+
+	if format == "json" {
+		headers := make(http.Header)
+		headers.Set("Content-Disposition", fmt.Sprintf(`attachment; filename="%s.json"`, filename))
+		headers.Set("Cache-Control", "no-store")
+
+		err = app.writeJSON(w, http.StatusOK, envelope{"personal_data": pd}, headers)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	js, err := json.MarshalIndent(pd, "", "\t")
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/zip")
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="%s.zip"`, filename))
+	w.Header().Set("Cache-Control", "no-store")
+
+	// the archive is streamed, so from here on errors can only be logged
+	zw := zip.NewWriter(w)
+
+	f, err := zw.Create("personal_data.json")
+	if err == nil {
+		_, err = f.Write(js)
+	}
+
+	for _, url := range []string{user.Image, user.Thumbnail} {
+		if err != nil {
+			break
+		}
+		err = app.addImageToArchive(r.Context(), zw, url)
+	}
+
+	if err == nil {
+		err = zw.Close()
+	}
+
+	if err != nil {
+		app.logError(r, err)
+	}
+}
+
+func (app *application) addImageToArchive(ctx context.Context, zw *zip.Writer, url string) error {
+	key, ok := strings.CutPrefix(url, imagesURLPrefix)
+	if !ok || key == "" {
+		return nil
+	}
+
+	body, _, err := app.storage.Get(ctx, key)
+	if err != nil {
+		if errors.Is(err, storage.ErrNotFound) {
+			return nil
+		}
+		return err
+	}
+	defer body.Close()
+
+	f, err := zw.Create("images/" + path.Base(key))
+	if err != nil {
+		return err
+	}
+
+	_, err = io.Copy(f, body)
+	return err
+}
+
+// createDeletionRequestHandler mails the user a token that confirms the
+// deletion of their account. Going through the mailbox works for accounts
+// that only sign in through an identity provider and have no password.
+func (app *application) createDeletionRequestHandler(w http.ResponseWriter, r *http.Request) {
+	user := app.contextGetUser(r)
+
+	role, err := app.models.Roles.Get(user.RoleID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	if role.IsStaff() {
+		app.errorResponse(w, r, http.StatusConflict, "staff accounts can only be closed by an administrator")
+		return
+	}
+
+	err = app.models.Tokens.DeleteAllForUser(data.ScopeAccountDeletion, user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	token, err := app.models.Tokens.New(user.ID, 24*time.Hour, data.ScopeAccountDeletion)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	app.background(func() {
+		data := map[string]any{
+			"fullName": user.FullName,
+			"token":    token.Plaintext,
+		}
+
+		err := app.mailer.Send(user.Email, "account_deletion.tmpl", data)
+		if err != nil {
+			app.logger.Error(err.Error())
+		}
+	})
+
+	err = app.writeJSON(w, http.StatusAccepted, envelope{"message": "a confirmation token was sent to your email address"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// deleteUserHandler anonymises the current user once the token from the
+// deletion request is presented. Subscriptions are kept for accounting.
+func (app *application) deleteUserHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		TokenPlaintext string `json:"token"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+
+	if data.ValidateTokenPlaintext(v, input.TokenPlaintext); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	user, err := app.models.Users.GetForToken(data.ScopeAccountDeletion, input.TokenPlaintext)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			v.AddError("token", "invalid or expired account deletion token")
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	if user.ID != app.contextGetUser(r).ID {
+		v.AddError("token", "invalid or expired account deletion token")
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	image, thumbnail := user.Image, user.Thumbnail
+
+	audit := app.newAuditEntry(r, data.AuditUserAnonymise, "user")
+	audit.TargetID = strconv.FormatInt(user.ID, 10)
+
+	err = app.models.Users.Anonymise(user, audit)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	app.deleteStoredImages(image, thumbnail)
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"message": "your account and personal data were deleted"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
@@ -1,9 +1,12 @@
 package main
 
 import (
+	"errors"
+	"fmt"
 	"net/http"
 
 	"github.com/obrikash/swimming_pool/internal/data"
+	"github.com/obrikash/swimming_pool/internal/validator"
 )
 
 func (app *application) listPoolsHandler(w http.ResponseWriter, r *http.Request) {
@@ -40,3 +43,157 @@ func (app *application) mostProfitPoolHandler(w http.ResponseWriter, r *http.Req
 		return
 	}
 }
+
+func (app *application) updatePoolHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	var input struct {
+		Lanes    *int    `json:"lanes"`
+		TimeZone *string `json:"time_zone"`
+	}
+
+	err = app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	pool, err := app.models.Pools.Get(id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	if input.Lanes != nil {
+		pool.Lanes = *input.Lanes
+	}
+
+	if input.TimeZone != nil {
+		pool.TimeZone = *input.TimeZone
+	}
+
+	v := validator.New()
+
+	v.Check(pool.Lanes >= 1 && pool.Lanes <= 50, "lanes", "must be between 1 and 50")
+
+	if data.ValidateTimeZone(v, "time_zone", pool.TimeZone); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = app.models.Pools.Update(pool, app.newAuditEntry(r, data.AuditPoolUpdate, "pool"))
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		case errors.Is(err, data.ErrLanesInUse):
+			app.errorResponse(w, r, http.StatusConflict, err.Error())
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"pool": pool}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// hourOccupancy tells how many lanes of a pool are taken during an hour and
+// by which sessions.
+type hourOccupancy struct {
+	Hour      string  `json:"hour"`
+	LanesUsed int     `json:"lanes_used"`
+	LanesFree int     `json:"lanes_free"`
+	Sessions  []int64 `json:"session_ids"`
+}
+
+// showPoolOccupancyHandler breaks the sessions of a pool on a day down by
+// hour. Upcoming sessions are generated from the schedules on the way.
+func (app *application) showPoolOccupancyHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	pool, err := app.models.Pools.Get(id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	v := validator.New()
+
+	day := app.readDate(r.URL.Query(), "date", data.Today(pool.Location()), v)
+
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = app.models.TrainingSessions.Generate(day, day)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	// the hours are those of the pool's own clock
+	start := day.In(pool.Location())
+
+	sessions, err := app.models.TrainingSessions.GetAllForPool(pool.ID, start, start.AddDate(0, 0, 1))
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	// sessions are placed on the day in minutes, one starting the evening
+	// before is cut off at midnight
+	uses := make([]data.LaneUse, len(sessions))
+	for i, s := range sessions {
+		uses[i] = data.LaneUse{
+			Start: max(0, int(s.StartsAt.Sub(start).Minutes())),
+			End:   min(data.MinutesPerDay, int(s.EndsAt().Sub(start).Minutes())),
+			Lanes: s.Lanes,
+		}
+	}
+
+	hours := make([]*hourOccupancy, 24)
+
+	for h := range hours {
+		from, to := h*60, (h+1)*60
+
+		ho := &hourOccupancy{Hour: fmt.Sprintf("%02d:00", h), Sessions: []int64{}}
+
+		for i, u := range uses {
+			if u.Start < to && u.End > from {
+				ho.Sessions = append(ho.Sessions, sessions[i].ID)
+			}
+		}
+
+		ho.LanesUsed = data.PeakLanes(uses, from, to)
+		ho.LanesFree = max(0, pool.Lanes-ho.LanesUsed)
+
+		hours[h] = ho
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"pool": pool, "date": day, "hours": hours, "sessions": sessions}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
@@ -0,0 +1,226 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/obrikash/swimming_pool/internal/data"
+	"github.com/obrikash/swimming_pool/internal/validator"
+)
+
+// referenceTable describes one of the lookup tables admins manage, the same
+// handlers serve all of them.
+type referenceTable struct {
+	model    data.ReferenceModel
+	singular string
+	plural   string
+	path     string
+}
+
+func (app *application) poolTypesTable() referenceTable {
+	return referenceTable{model: app.models.PoolTypes, singular: "pool_type", plural: "pool_types", path: "/v1/admin/pool-types"}
+}
+
+func (app *application) categoriesTable() referenceTable {
+	return referenceTable{model: app.models.Categories, singular: "category", plural: "categories", path: "/v1/admin/categories"}
+}
+
+func (app *application) rolesTable() referenceTable {
+	return referenceTable{model: app.models.Roles.ReferenceModel, singular: "role", plural: "roles", path: "/v1/admin/roles"}
+}
+
+func (app *application) listReferenceHandler(table referenceTable) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		refs, err := table.model.GetAll()
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+
+		err = app.writeJSON(w, http.StatusOK, envelope{table.plural: refs}, nil)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+		}
+	}
+}
+
+func (app *application) createReferenceHandler(table referenceTable) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var input struct {
+			Code         string            `json:"code"`
+			Name         string            `json:"name"`
+			Translations map[string]string `json:"translations"`
+		}
+
+		err := app.readJSON(w, r, &input)
+		if err != nil {
+			app.badRequestResponse(w, r, err)
+			return
+		}
+
+		ref := &data.Reference{Code: input.Code, Name: input.Name, Translations: input.Translations}
+		if ref.Translations == nil {
+			ref.Translations = map[string]string{}
+		}
+
+		v := validator.New()
+
+		if data.ValidateReference(v, ref); !v.Valid() {
+			app.failedValidationResponse(w, r, v.Errors)
+			return
+		}
+
+		audit := app.newAuditEntry(r, data.AuditReferenceCreate, table.singular)
+
+		err = audit.SetChanges(nil, ref)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+
+		err = table.model.Insert(ref, audit)
+		if err != nil {
+			app.referenceErrorResponse(w, r, err)
+			return
+		}
+
+		headers := make(http.Header)
+		headers.Set("Location", fmt.Sprintf("%s/%d", table.path, ref.ID))
+
+		err = app.writeJSON(w, http.StatusCreated, envelope{table.singular: ref}, headers)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+		}
+	}
+}
+
+func (app *application) updateReferenceHandler(table referenceTable) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id, err := app.readIDParam(r)
+		if err != nil {
+			app.notFoundResponse(w, r)
+			return
+		}
+
+		ref, err := table.model.Get(id)
+		if err != nil {
+			switch {
+			case errors.Is(err, data.ErrRecordNotFound):
+				app.notFoundResponse(w, r)
+			default:
+				app.serverErrorResponse(w, r, err)
+			}
+			return
+		}
+
+		var input struct {
+			Code         *string           `json:"code"`
+			Name         *string           `json:"name"`
+			Translations map[string]string `json:"translations"`
+		}
+
+		err = app.readJSON(w, r, &input)
+		if err != nil {
+			app.badRequestResponse(w, r, err)
+			return
+		}
+
+		v := validator.New()
+
+		v.Check(input.Code == nil || *input.Code == ref.Code, "code", "cannot be changed")
+
+		before := *ref
+
+		if input.Name != nil {
+			ref.Name = *input.Name
+		}
+
+		if input.Translations != nil {
+			ref.Translations = input.Translations
+		}
+
+		if data.ValidateReference(v, ref); !v.Valid() {
+			app.failedValidationResponse(w, r, v.Errors)
+			return
+		}
+
+		audit := app.newAuditEntry(r, data.AuditReferenceUpdate, table.singular)
+
+		err = audit.SetChanges(before, ref)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+
+		err = table.model.Update(ref, audit)
+		if err != nil {
+			app.referenceErrorResponse(w, r, err)
+			return
+		}
+
+		err = app.writeJSON(w, http.StatusOK, envelope{table.singular: ref}, nil)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+		}
+	}
+}
+
+func (app *application) deleteReferenceHandler(table referenceTable) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id, err := app.readIDParam(r)
+		if err != nil {
+			app.notFoundResponse(w, r)
+			return
+		}
+
+		ref, err := table.model.Get(id)
+		if err != nil {
+			switch {
+			case errors.Is(err, data.ErrRecordNotFound):
+				app.notFoundResponse(w, r)
+			default:
+				app.serverErrorResponse(w, r, err)
+			}
+			return
+		}
+
+		audit := app.newAuditEntry(r, data.AuditReferenceDelete, table.singular)
+
+		err = audit.SetChanges(ref, nil)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+
+		err = table.model.Delete(ref, audit)
+		if err != nil {
+			app.referenceErrorResponse(w, r, err)
+			return
+		}
+
+		err = app.writeJSON(w, http.StatusOK, envelope{"message": fmt.Sprintf("%s %q successfully deleted", table.singular, ref.Code)}, nil)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+		}
+	}
+}
+
+func (app *application) referenceErrorResponse(w http.ResponseWriter, r *http.Request, err error) {
+	v := validator.New()
+
+	switch {
+	case errors.Is(err, data.ErrRecordNotFound):
+		app.notFoundResponse(w, r)
+	case errors.Is(err, data.ErrDuplicateCode):
+		v.AddError("code", "is already taken")
+		app.failedValidationResponse(w, r, v.Errors)
+	case errors.Is(err, data.ErrDuplicateName):
+		v.AddError("name", "is already taken")
+		app.failedValidationResponse(w, r, v.Errors)
+	case errors.Is(err, data.ErrReferenceInUse), errors.Is(err, data.ErrReferenceProtected):
+		app.errorResponse(w, r, http.StatusConflict, err.Error())
+	default:
+		app.serverErrorResponse(w, r, err)
+	}
+}
@@ -12,23 +12,108 @@ func (app *application) routes() http.Handler {
 	router.MethodNotAllowed = http.HandlerFunc(app.methodNotAllowedResponse)
 	router.NotFound = http.HandlerFunc(app.notFoundResponse)
 
-	router.HandlerFunc(http.MethodGet, "/v1/pools", app.requireAuthenticatedUser(app.listPoolsHandler))
-	router.HandlerFunc(http.MethodGet, "/v1/pool", app.requireAdmin(app.mostProfitPoolHandler))
+	router.HandlerFunc(http.MethodGet, "/v1/pools", app.requirePermission("pools:read", app.listPoolsHandler))
+	router.HandlerFunc(http.MethodGet, "/v1/pool", app.requirePermission("reports:read", app.mostProfitPoolHandler))
+	router.HandlerFunc(http.MethodPut, "/v1/pools/:id/photo", app.requirePermission("pools:write", app.uploadPoolPhotoHandler))
+	router.HandlerFunc(http.MethodGet, "/v1/pool-types", app.requirePermission("pools:read", app.listReferenceHandler(app.poolTypesTable())))
 
-	router.HandlerFunc(http.MethodGet, "/v1/users/trainers", app.requireAuthenticatedUser(app.listTrainersHandler))
-	router.HandlerFunc(http.MethodGet, "/v1/pools/trainers", app.requireAuthenticatedUser(app.listTrainersForPoolsHandler))
-	router.HandlerFunc(http.MethodPost, "/v1/pools/trainers", app.requireAdmin(app.attachTrainerToPoolHandler))
-	router.HandlerFunc(http.MethodGet, "/v1/users/trainers/profit", app.requireAdmin(app.profitOfTrainers))
+	router.HandlerFunc(http.MethodGet, "/v1/users/trainers", app.requirePermission("trainers:read", app.listTrainersHandler))
+	router.HandlerFunc(http.MethodGet, "/v1/pools/trainers", app.requirePermission("trainers:read", app.listTrainersForPoolsHandler))
+	router.HandlerFunc(http.MethodPost, "/v1/pools/trainers", app.requirePermission("trainers:write", app.attachTrainerToPoolHandler))
+	router.HandlerFunc(http.MethodGet, "/v1/users/trainers/profit", app.requirePermission("reports:read", app.profitOfTrainers))
+	router.HandlerFunc(http.MethodGet, "/v1/users/trainers/payroll", app.requirePermission("reports:read", app.trainerPayrollHandler))
 
-	router.HandlerFunc(http.MethodGet, "/v1/groups", app.requireAuthenticatedUser(app.listGroupsHandler))
-	router.HandlerFunc(http.MethodPost, "/v1/groups", app.requireAuthenticatedUser(app.addGroupToPoolHandler))
+	router.HandlerFunc(http.MethodGet, "/v1/groups", app.requirePermission("groups:read", app.listGroupsHandler))
+	router.HandlerFunc(http.MethodPost, "/v1/groups", app.requirePermission("groups:write", app.addGroupToPoolHandler))
+	router.HandlerFunc(http.MethodPatch, "/v1/groups/:id", app.requirePermission("groups:write", app.updateGroupHandler))
+	router.HandlerFunc(http.MethodDelete, "/v1/groups/:id", app.requirePermission("groups:write", app.archiveGroupHandler))
+	router.HandlerFunc(http.MethodPost, "/v1/groups/:id/members", app.requireAuthenticatedUser(app.enrollGroupHandler))
+	router.HandlerFunc(http.MethodGet, "/v1/categories", app.requirePermission("groups:read", app.listReferenceHandler(app.categoriesTable())))
+	router.HandlerFunc(http.MethodGet, "/v1/categories/eligibility", app.requirePermission("groups:read", app.listEligibilityHandler))
+	router.HandlerFunc(http.MethodPut, "/v1/admin/categories/:id/eligibility", app.requirePermission("categories:write", app.updateEligibilityHandler))
+	router.HandlerFunc(http.MethodGet, "/v1/groups/:id/schedules", app.requirePermission("schedules:read", app.listGroupSchedulesHandler))
+	router.HandlerFunc(http.MethodPost, "/v1/groups/:id/schedules", app.requirePermission("schedules:write", app.createScheduleHandler))
+	router.HandlerFunc(http.MethodPut, "/v1/sessions/:id/attendance", app.requirePermission("attendance:write", app.markAttendanceHandler))
+	router.HandlerFunc(http.MethodPost, "/v1/checkins", app.requirePermission("checkin:write", app.checkInHandler))
+	router.HandlerFunc(http.MethodGet, "/v1/sessions/:id/substitutes", app.requirePermission("substitutes:read", app.listSubstituteCandidatesHandler))
+	router.HandlerFunc(http.MethodPut, "/v1/sessions/:id/substitute", app.requirePermission("substitutes:write", app.assignSubstituteHandler))
+	router.HandlerFunc(http.MethodDelete, "/v1/sessions/:id/substitute", app.requirePermission("substitutes:write", app.removeSubstituteHandler))
 
-	router.HandlerFunc(http.MethodGet, "/v1/subscriptions", app.requireAuthenticatedUser(app.listSubscriptionsHandler))
+	router.HandlerFunc(http.MethodGet, "/v1/trainers/me/groups", app.requirePermission("attendance:read", app.listMyGroupsHandler))
+	router.HandlerFunc(http.MethodGet, "/v1/trainers/me/groups/:id/roster", app.requirePermission("attendance:read", app.showMyGroupRosterHandler))
+	router.HandlerFunc(http.MethodGet, "/v1/trainers/me/sessions", app.requirePermission("attendance:read", app.listMySessionsHandler))
+	router.HandlerFunc(http.MethodGet, "/v1/trainers/me/time-off", app.requirePermission("availability:read", app.listMyTimeOffHandler))
+	router.HandlerFunc(http.MethodPost, "/v1/trainers/me/time-off", app.requirePermission("availability:write", app.createTimeOffHandler))
+	router.HandlerFunc(http.MethodDelete, "/v1/trainers/me/time-off/:id", app.requirePermission("availability:write", app.deleteTimeOffHandler))
+	router.HandlerFunc(http.MethodGet, "/v1/trainers/me/availability", app.requirePermission("availability:read", app.showMyAvailabilityHandler))
+	router.HandlerFunc(http.MethodPut, "/v1/trainers/me/availability", app.requirePermission("availability:write", app.updateMyAvailabilityHandler))
+	router.HandlerFunc(http.MethodDelete, "/v1/schedules/:id", app.requirePermission("schedules:write", app.deleteScheduleHandler))
+
+	router.HandlerFunc(http.MethodGet, "/v1/subscriptions", app.requirePermission("subscriptions:read", app.listSubscriptionsHandler))
 	router.HandlerFunc(http.MethodGet, "/v1/users/subscriptions", app.requireAuthenticatedUser(app.listUsersSubscriptionsHandler))
 
-	router.HandlerFunc(http.MethodPost, "/v1/tokens/authentication", app.createAuthenticationTokenHandler)
+	router.HandlerFunc(http.MethodPost, "/v1/tokens/authentication", app.rateLimitByIP(app.createAuthenticationTokenHandler))
+	router.HandlerFunc(http.MethodPost, "/v1/tokens/two-factor", app.rateLimitByIP(app.createTwoFactorAuthenticationTokenHandler))
+	router.HandlerFunc(http.MethodPost, "/v1/tokens/password-reset", app.rateLimitByIP(app.createPasswordResetTokenHandler))
+	router.HandlerFunc(http.MethodPost, "/v1/tokens/two-factor/enrolment", app.rateLimitByIP(app.createTwoFactorEnrolmentHandler))
+	router.HandlerFunc(http.MethodPost, "/v1/tokens/oidc", app.rateLimitByIP(app.createOIDCAuthenticationTokenHandler))
+	router.HandlerFunc(http.MethodPost, "/v1/oidc/:provider/authorize", app.rateLimitByIP(app.startOIDCLoginHandler))
 
 	router.HandlerFunc(http.MethodPost, "/v1/users", app.registerUserHandler)
 	router.HandlerFunc(http.MethodGet, "/v1/users", app.requireAuthenticatedUser(app.profileUserHandler))
-	return app.recoverPanic(app.enableCORS((app.authenticate(router))))
+	router.HandlerFunc(http.MethodPatch, "/v1/users", app.requireAuthenticatedUser(app.updateUserHandler))
+	router.HandlerFunc(http.MethodDelete, "/v1/users", app.requireAuthenticatedUser(app.deleteUserHandler))
+	router.HandlerFunc(http.MethodGet, "/v1/users/export", app.requireAuthenticatedUser(app.exportUserDataHandler))
+	router.HandlerFunc(http.MethodPost, "/v1/users/deletion", app.requireAuthenticatedUser(app.createDeletionRequestHandler))
+	router.HandlerFunc(http.MethodPut, "/v1/users/email", app.requireAuthenticatedUser(app.confirmEmailChangeHandler))
+	router.HandlerFunc(http.MethodPut, "/v1/users/password", app.requireAuthenticatedUser(app.changePasswordHandler))
+	router.HandlerFunc(http.MethodPut, "/v1/users/password-reset", app.resetPasswordHandler)
+	router.HandlerFunc(http.MethodPut, "/v1/users/avatar", app.requireAuthenticatedUser(app.uploadAvatarHandler))
+	router.HandlerFunc(http.MethodPost, "/v1/users/two-factor", app.requireAuthenticatedUser(app.enableTwoFactorHandler))
+	router.HandlerFunc(http.MethodPut, "/v1/users/two-factor", app.requireAuthenticatedUser(app.confirmTwoFactorHandler))
+	router.HandlerFunc(http.MethodDelete, "/v1/users/two-factor", app.requireAuthenticatedUser(app.disableTwoFactorHandler))
+	router.HandlerFunc(http.MethodGet, "/v1/users/sessions", app.requireAuthenticatedUser(app.listSessionsHandler))
+	router.HandlerFunc(http.MethodDelete, "/v1/users/sessions", app.requireAuthenticatedUser(app.revokeOtherSessionsHandler))
+	router.HandlerFunc(http.MethodDelete, "/v1/users/sessions/:id", app.requireAuthenticatedUser(app.revokeSessionHandler))
+
+	router.HandlerFunc(http.MethodGet, "/v1/admin/users", app.requirePermission("users:read", app.listUsersHandler))
+	router.HandlerFunc(http.MethodPatch, "/v1/admin/users/:id", app.requirePermission("users:write", app.updateUserByAdminHandler))
+	router.HandlerFunc(http.MethodDelete, "/v1/admin/users/:id/lockout", app.requirePermission("users:write", app.unlockUserHandler))
+	router.HandlerFunc(http.MethodGet, "/v1/admin/users/:id/assignments", app.requirePermission("trainers:read", app.listTrainerAssignmentsHandler))
+	router.HandlerFunc(http.MethodGet, "/v1/admin/users/:id/qualifications", app.requirePermission("trainers:read", app.showQualificationsHandler))
+	router.HandlerFunc(http.MethodPut, "/v1/admin/users/:id/qualifications", app.requirePermission("trainers:write", app.updateQualificationsHandler))
+	router.HandlerFunc(http.MethodPatch, "/v1/admin/assignments/:id", app.requirePermission("trainers:write", app.updateTrainerAssignmentHandler))
+	router.HandlerFunc(http.MethodGet, "/v1/admin/users/:id/sessions", app.requirePermission("users:read", app.listUserSessionsHandler))
+	router.HandlerFunc(http.MethodDelete, "/v1/admin/users/:id/sessions/:session_id", app.requirePermission("users:write", app.revokeUserSessionHandler))
+	router.HandlerFunc(http.MethodPost, "/v1/admin/users/:id/merge", app.requirePermission("users:write", app.mergeUsersHandler))
+	router.HandlerFunc(http.MethodGet, "/v1/admin/email-collisions", app.requirePermission("users:read", app.listEmailCollisionsHandler))
+	router.HandlerFunc(http.MethodGet, "/v1/admin/lockouts", app.requirePermission("users:read", app.listLockoutsHandler))
+	router.HandlerFunc(http.MethodGet, "/v1/admin/roles", app.requirePermission("users:read", app.listReferenceHandler(app.rolesTable())))
+	router.HandlerFunc(http.MethodPost, "/v1/admin/roles", app.requirePermission("users:write", app.createReferenceHandler(app.rolesTable())))
+	router.HandlerFunc(http.MethodPatch, "/v1/admin/roles/:id", app.requirePermission("users:write", app.updateReferenceHandler(app.rolesTable())))
+	router.HandlerFunc(http.MethodDelete, "/v1/admin/roles/:id", app.requirePermission("users:write", app.deleteReferenceHandler(app.rolesTable())))
+	router.HandlerFunc(http.MethodPatch, "/v1/admin/pools/:id", app.requirePermission("pools:write", app.updatePoolHandler))
+	router.HandlerFunc(http.MethodGet, "/v1/admin/pools/:id/occupancy", app.requirePermission("schedules:read", app.showPoolOccupancyHandler))
+	router.HandlerFunc(http.MethodGet, "/v1/admin/pools/:id/opening-hours", app.requirePermission("pools:read", app.showOpeningHoursHandler))
+	router.HandlerFunc(http.MethodPut, "/v1/admin/pools/:id/opening-hours", app.requirePermission("pools:write", app.updateOpeningHoursHandler))
+	router.HandlerFunc(http.MethodGet, "/v1/admin/pools/:id/calendar", app.requirePermission("pools:read", app.listPoolCalendarHandler))
+	router.HandlerFunc(http.MethodPost, "/v1/admin/pools/:id/calendar", app.requirePermission("pools:write", app.createCalendarDayHandler))
+	router.HandlerFunc(http.MethodDelete, "/v1/admin/calendar/:id", app.requirePermission("pools:write", app.deleteCalendarDayHandler))
+	router.HandlerFunc(http.MethodPost, "/v1/admin/pool-types", app.requirePermission("pools:write", app.createReferenceHandler(app.poolTypesTable())))
+	router.HandlerFunc(http.MethodPatch, "/v1/admin/pool-types/:id", app.requirePermission("pools:write", app.updateReferenceHandler(app.poolTypesTable())))
+	router.HandlerFunc(http.MethodDelete, "/v1/admin/pool-types/:id", app.requirePermission("pools:write", app.deleteReferenceHandler(app.poolTypesTable())))
+	router.HandlerFunc(http.MethodPost, "/v1/admin/categories", app.requirePermission("categories:write", app.createReferenceHandler(app.categoriesTable())))
+	router.HandlerFunc(http.MethodPatch, "/v1/admin/categories/:id", app.requirePermission("categories:write", app.updateReferenceHandler(app.categoriesTable())))
+	router.HandlerFunc(http.MethodDelete, "/v1/admin/categories/:id", app.requirePermission("categories:write", app.deleteReferenceHandler(app.categoriesTable())))
+	router.HandlerFunc(http.MethodGet, "/v1/admin/time-off", app.requirePermission("time_off:read", app.listTimeOffCalendarHandler))
+	router.HandlerFunc(http.MethodPut, "/v1/admin/time-off/:id/decision", app.requirePermission("time_off:write", app.decideTimeOffHandler))
+	router.HandlerFunc(http.MethodDelete, "/v1/admin/time-off/:id", app.requirePermission("time_off:write", app.cancelTimeOffHandler))
+	router.HandlerFunc(http.MethodGet, "/v1/admin/audit", app.requirePermission("audit:read", app.listAuditLogHandler))
+
+	router.HandlerFunc(http.MethodGet, "/v1/admin/api-keys", app.requirePermission("api_keys:read", app.listAPIKeysHandler))
+	router.HandlerFunc(http.MethodPost, "/v1/admin/api-keys", app.requirePermission("api_keys:write", app.createAPIKeyHandler))
+	router.HandlerFunc(http.MethodDelete, "/v1/admin/api-keys/:id", app.requirePermission("api_keys:write", app.revokeAPIKeyHandler))
+
+	router.HandlerFunc(http.MethodGet, "/v1/images/*key", app.serveImageHandler)
+	return app.recoverPanic(app.requestID(app.enableCORS((app.authenticate(router)))))
 }
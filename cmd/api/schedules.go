@@ -0,0 +1,257 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/obrikash/swimming_pool/internal/data"
+	"github.com/obrikash/swimming_pool/internal/validator"
+)
+
+func (app *application) listGroupSchedulesHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	schedules, err := app.models.Schedules.GetForGroup(id)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"schedules": schedules}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) createScheduleHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	var input struct {
+		DayOfWeek       int    `json:"day_of_week"`
+		TimeOfDay       string `json:"time_of_day"`
+		DurationMinutes int    `json:"duration_minutes"`
+		Lanes           int    `json:"lanes"`
+	}
+
+	err = app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	group, err := app.models.Groups.Get(id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.authorizeGroup(r, group)
+	if err != nil {
+		app.authorizationErrorResponse(w, r, err)
+		return
+	}
+
+	if group.ArchivedAt != nil {
+		app.groupArchivedResponse(w, r)
+		return
+	}
+
+	if input.DurationMinutes == 0 {
+		input.DurationMinutes = data.DefaultSessionMinutes
+	}
+
+	if input.Lanes == 0 {
+		input.Lanes = 1
+	}
+
+	schedule := &data.Schedule{
+		GroupID:         group.ID,
+		DayOfWeek:       input.DayOfWeek,
+		TimeOfDay:       input.TimeOfDay,
+		DurationMinutes: input.DurationMinutes,
+		Lanes:           input.Lanes,
+	}
+
+	v := validator.New()
+
+	if data.ValidateSchedule(v, schedule); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	// a schedule outside the weekly hours of the trainer is refused, one
+	// that runs into upcoming time off is created with a warning
+	windows, err := app.models.Availability.GetForTrainer(group.Trainer.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	if !data.IsAvailable(windows, schedule.DayOfWeek, schedule.TimeOfDay, schedule.DurationMinutes) {
+		v.AddError("time_of_day", "is outside the weekly availability of the trainer")
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	pool, err := app.models.Pools.Get(group.Pool)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	// the pool has to be open at that time every week, upcoming holidays and
+	// closures only skip single sessions and are reported as warnings
+	hours, err := app.models.Calendar.GetOpeningHours(pool.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	if !data.FitsOpeningHours(hours, schedule.DayOfWeek, schedule.TimeOfDay, schedule.DurationMinutes) {
+		v.AddError("time_of_day", "is outside the opening hours of the pool")
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	now := data.Today(pool.Location())
+
+	// sessions are only generated on the days the trainer is assigned to
+	// the pool, so the assignment has to cover the day of the week
+	assignments, err := app.models.Assignments.GetAllForTrainer(group.Trainer.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	covered, ongoing := false, false
+	var lastDay data.Date
+
+	for _, a := range assignments {
+		if a.PoolID != pool.ID || !a.Covers(schedule.DayOfWeek, now) {
+			continue
+		}
+
+		covered = true
+
+		switch {
+		case a.EndsOn == nil:
+			ongoing = true
+		case a.EndsOn.After(lastDay.Time):
+			lastDay = *a.EndsOn
+		}
+	}
+
+	if !covered {
+		v.AddError("day_of_week", "is not a day the trainer is assigned to the pool")
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	days, err := app.models.Calendar.GetHours(pool.ID, now, data.Date{Time: now.AddDate(0, 0, data.MaxSessionRange)})
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	timeOff, err := app.models.TimeOff.GetAllForTrainer(group.Trainer.ID, now)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	warnings := []string{}
+
+	if !ongoing {
+		warnings = append(warnings, fmt.Sprintf("the trainer is assigned to the pool until %s, no sessions will be generated after that", lastDay))
+	}
+
+	for _, day := range days {
+		if day.Date.ISOWeekday() == schedule.DayOfWeek && !day.Fits(schedule.TimeOfDay, schedule.DurationMinutes) {
+			warnings = append(warnings, fmt.Sprintf("there will be no session on %s, the pool is closed at that time (%s)",
+				day.Date, day.Kind))
+		}
+	}
+
+	for _, t := range timeOff {
+		if t.Status != data.TimeOffRejected && t.IncludesWeekday(schedule.DayOfWeek, now) {
+			warnings = append(warnings, fmt.Sprintf("the trainer is off from %s to %s (%s, %s), sessions on those days will need a substitute",
+				t.StartsOn, t.EndsOn, t.Kind, t.Status))
+		}
+	}
+
+	err = app.models.Schedules.Insert(schedule)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrPoolFull):
+			app.errorResponse(w, r, http.StatusConflict, err.Error())
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	env := envelope{"schedule": schedule}
+	if len(warnings) > 0 {
+		env["warnings"] = warnings
+	}
+
+	err = app.writeJSON(w, http.StatusCreated, env, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) deleteScheduleHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	schedule, err := app.models.Schedules.Get(id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.authorizeSchedule(r, schedule)
+	if err != nil {
+		app.authorizationErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.models.Schedules.Delete(schedule.ID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"message": "schedule successfully deleted"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
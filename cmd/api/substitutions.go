@@ -0,0 +1,308 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"slices"
+	"time"
+
+	"github.com/obrikash/swimming_pool/internal/data"
+	"github.com/obrikash/swimming_pool/internal/validator"
+)
+
+// listSubstituteCandidatesHandler suggests the trainers who could take over
+// a session, best first.
+func (app *application) listSubstituteCandidatesHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	session, err := app.models.TrainingSessions.Get(id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	candidates, err := app.models.TrainingSessions.SuggestSubstitutes(session.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"candidates": candidates}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// assignSubstituteHandler hands a session over to one of the suggested
+// trainers and lets the members of the group know.
+func (app *application) assignSubstituteHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	var input struct {
+		TrainerID int64 `json:"trainer_id"`
+	}
+
+	err = app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	session, err := app.models.TrainingSessions.Get(id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	candidates, err := app.models.TrainingSessions.SuggestSubstitutes(session.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	i := slices.IndexFunc(candidates, func(c *data.SubstituteCandidate) bool { return c.TrainerID == input.TrainerID })
+
+	v := validator.New()
+
+	if v.Check(i >= 0, "trainer_id", "must be one of the suggested candidates"); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	substitute := candidates[i]
+
+	err = app.models.TrainingSessions.SetSubstitute(session, &substitute.TrainerID, app.newAuditEntry(r, data.AuditSubstitute, "training_session"))
+	if err != nil {
+		app.substituteErrorResponse(w, r, err)
+		return
+	}
+
+	group, err := app.models.Groups.Get(session.GroupID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	pool, err := app.models.Pools.Get(group.Pool)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	category, err := app.models.Categories.Get(group.Category)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	members, err := app.models.Groups.GetMembers(group.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	app.background(func() {
+		for _, member := range members {
+			data := map[string]any{
+				"fullName":   member.FullName,
+				"category":   category.Name,
+				"pool":       pool.Name,
+				"startsAt":   session.StartsAt.Format("02.01.2006 в 15:04"),
+				"substitute": substitute.FullName,
+			}
+
+			err := app.mailer.Send(member.Email, "substitute_assigned.tmpl", data)
+			if err != nil {
+				app.logger.Error(err.Error())
+			}
+		}
+	})
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"session": session, "substitute": substitute}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// removeSubstituteHandler gives the session back to the trainer of the group.
+func (app *application) removeSubstituteHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	session, err := app.models.TrainingSessions.Get(id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	if session.SubstituteTrainerID == nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	err = app.models.TrainingSessions.SetSubstitute(session, nil, app.newAuditEntry(r, data.AuditSubstitute, "training_session"))
+	if err != nil {
+		app.substituteErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"session": session}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) substituteErrorResponse(w http.ResponseWriter, r *http.Request, err error) {
+	switch {
+	case errors.Is(err, data.ErrSessionCancelled), errors.Is(err, data.ErrSessionStarted):
+		app.errorResponse(w, r, http.StatusConflict, err.Error())
+	default:
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// trainerPayrollHandler returns the sessions run by each trainer between the
+// from and to dates, both inclusive, the current month by default.
+func (app *application) trainerPayrollHandler(w http.ResponseWriter, r *http.Request) {
+	v := validator.New()
+
+	qs := r.URL.Query()
+
+	now := today()
+	monthStart := data.NewDate(now.Year(), now.Month(), 1)
+
+	from := app.readDate(qs, "from", monthStart, v)
+	to := app.readDate(qs, "to", data.Date{Time: from.AddDate(0, 1, -1)}, v)
+
+	v.Check(!to.Before(from.Time), "to", "must not be before from")
+	v.Check(to.Sub(from.Time) <= data.MaxTimeOffDays*24*time.Hour, "to", "must be at most a year after from")
+
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	payroll, err := app.models.TrainingSessions.Payroll(from, to)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"payroll": payroll}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) showQualificationsHandler(w http.ResponseWriter, r *http.Request) {
+	trainer, ok := app.readTrainerParam(w, r)
+	if !ok {
+		return
+	}
+
+	categories, err := app.models.Qualifications.GetForTrainer(trainer.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"category_ids": categories}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// updateQualificationsHandler replaces the categories the trainer may teach.
+func (app *application) updateQualificationsHandler(w http.ResponseWriter, r *http.Request) {
+	trainer, ok := app.readTrainerParam(w, r)
+	if !ok {
+		return
+	}
+
+	var input struct {
+		CategoryIDs []int64 `json:"category_ids"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	if input.CategoryIDs == nil {
+		input.CategoryIDs = []int64{}
+	}
+
+	v := validator.New()
+
+	if v.Check(validator.Unique(input.CategoryIDs), "category_ids", "must not contain duplicate values"); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = app.models.Qualifications.Replace(trainer.ID, input.CategoryIDs)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			v.AddError("category_ids", "must contain existing categories")
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"category_ids": input.CategoryIDs}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// readTrainerParam returns the trainer record of the user in the :id
+// parameter, writing a not found response when there is none.
+func (app *application) readTrainerParam(w http.ResponseWriter, r *http.Request) (*data.Trainer, bool) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return nil, false
+	}
+
+	trainer, err := app.models.Users.GetTrainer(id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return nil, false
+	}
+
+	return trainer, true
+}
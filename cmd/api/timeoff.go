@@ -0,0 +1,377 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/obrikash/swimming_pool/internal/data"
+	"github.com/obrikash/swimming_pool/internal/validator"
+)
+
+// today returns the current date in the default zone, for dates that don't
+// belong to any pool.
+func today() data.Date {
+	return data.Today(data.DefaultLocation)
+}
+
+// listMyTimeOffHandler returns the time off of the current trainer that
+// hasn't ended yet.
+func (app *application) listMyTimeOffHandler(w http.ResponseWriter, r *http.Request) {
+	trainer, err := app.currentTrainer(r)
+	if err != nil {
+		app.authorizationErrorResponse(w, r, err)
+		return
+	}
+
+	timeOff, err := app.models.TimeOff.GetAllForTrainer(trainer.ID, today())
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"time_off": timeOff}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// cancelTimeOffHandler removes time off of a trainer, including approved
+// time off the trainer can no longer withdraw themselves.
+func (app *application) cancelTimeOffHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	timeOff, err := app.models.TimeOff.Get(id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.models.TimeOff.Cancel(timeOff, app.newAuditEntry(r, data.AuditTimeOffCancel, "time_off"))
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"message": "time off successfully cancelled"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// createTimeOffHandler declares time off for the current trainer. It stays
+// pending until an admin decides on it.
+func (app *application) createTimeOffHandler(w http.ResponseWriter, r *http.Request) {
+	trainer, err := app.currentTrainer(r)
+	if err != nil {
+		app.authorizationErrorResponse(w, r, err)
+		return
+	}
+
+	var input struct {
+		Kind     string    `json:"kind"`
+		StartsOn data.Date `json:"starts_on"`
+		EndsOn   data.Date `json:"ends_on"`
+		Note     string    `json:"note"`
+	}
+
+	err = app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	timeOff := &data.TimeOff{
+		TrainerID: trainer.ID,
+		Kind:      input.Kind,
+		StartsOn:  input.StartsOn,
+		EndsOn:    input.EndsOn,
+		Note:      input.Note,
+	}
+
+	v := validator.New()
+
+	// sick leave is usually reported after the fact, anything else has to
+	// be planned ahead
+	if timeOff.Kind != data.TimeOffSickLeave {
+		v.Check(!timeOff.EndsOn.Before(today().Time), "ends_on", "must not be in the past")
+	}
+
+	if data.ValidateTimeOff(v, timeOff); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = app.models.TimeOff.Insert(timeOff)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	headers := make(http.Header)
+	headers.Set("Location", fmt.Sprintf("/v1/trainers/me/time-off/%d", timeOff.ID))
+
+	err = app.writeJSON(w, http.StatusCreated, envelope{"time_off": timeOff}, headers)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// deleteTimeOffHandler withdraws time off of the current trainer. Approved
+// time off can only be changed by an admin.
+func (app *application) deleteTimeOffHandler(w http.ResponseWriter, r *http.Request) {
+	trainer, err := app.currentTrainer(r)
+	if err != nil {
+		app.authorizationErrorResponse(w, r, err)
+		return
+	}
+
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	timeOff, err := app.models.TimeOff.Get(id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	if timeOff.TrainerID != trainer.ID {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	if timeOff.Status == data.TimeOffApproved {
+		app.errorResponse(w, r, http.StatusConflict, "approved time off can only be cancelled by an administrator")
+		return
+	}
+
+	err = app.models.TimeOff.Delete(timeOff.ID, trainer.ID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"message": "time off successfully withdrawn"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) showMyAvailabilityHandler(w http.ResponseWriter, r *http.Request) {
+	trainer, err := app.currentTrainer(r)
+	if err != nil {
+		app.authorizationErrorResponse(w, r, err)
+		return
+	}
+
+	windows, err := app.models.Availability.GetForTrainer(trainer.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"availability": windows}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// updateMyAvailabilityHandler replaces the weekly availability of the
+// current trainer. Existing schedules that fall outside the new windows are
+// kept, but reported as warnings so they can be moved.
+func (app *application) updateMyAvailabilityHandler(w http.ResponseWriter, r *http.Request) {
+	trainer, err := app.currentTrainer(r)
+	if err != nil {
+		app.authorizationErrorResponse(w, r, err)
+		return
+	}
+
+	var input struct {
+		Windows []*data.AvailabilityWindow `json:"windows"`
+	}
+
+	err = app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	if input.Windows == nil {
+		input.Windows = []*data.AvailabilityWindow{}
+	}
+
+	v := validator.New()
+
+	v.Check(len(input.Windows) <= 50, "windows", "must not contain more than 50 windows")
+
+	if data.ValidateAvailability(v, input.Windows); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	schedules, err := app.models.Schedules.GetForTrainer(trainer.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.models.Availability.Replace(trainer.ID, input.Windows)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	warnings := []string{}
+
+	for _, schedule := range schedules {
+		if !data.IsAvailable(input.Windows, schedule.DayOfWeek, schedule.TimeOfDay, schedule.DurationMinutes) {
+			warnings = append(warnings, fmt.Sprintf("schedule %d of group %d on day %d at %s is outside the new availability",
+				schedule.ID, schedule.GroupID, schedule.DayOfWeek, schedule.TimeOfDay))
+		}
+	}
+
+	env := envelope{"availability": input.Windows}
+	if len(warnings) > 0 {
+		env["warnings"] = warnings
+	}
+
+	err = app.writeJSON(w, http.StatusOK, env, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// calendarDay lists the trainers who are off on a day.
+type calendarDay struct {
+	Date data.Date       `json:"date"`
+	Off  []*data.TimeOff `json:"off"`
+}
+
+// listTimeOffCalendarHandler returns the time off of all trainers between
+// from and to, both as a list and broken down by day.
+func (app *application) listTimeOffCalendarHandler(w http.ResponseWriter, r *http.Request) {
+	v := validator.New()
+
+	qs := r.URL.Query()
+
+	from := app.readDate(qs, "from", today(), v)
+	to := app.readDate(qs, "to", data.Date{Time: from.AddDate(0, 0, 30)}, v)
+	status := app.readString(qs, "status", "")
+
+	v.Check(status == "" || validator.PermittedValue(status, data.TimeOffPending, data.TimeOffApproved, data.TimeOffRejected),
+		"status", "must be pending, approved or rejected")
+
+	if data.ValidateSessionRange(v, from, to); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	timeOff, err := app.models.TimeOff.GetAll(from, to, status)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	days := []*calendarDay{}
+
+	for day := from.Time; !day.After(to.Time); day = day.AddDate(0, 0, 1) {
+		cd := &calendarDay{Date: data.Date{Time: day}, Off: []*data.TimeOff{}}
+
+		for _, t := range timeOff {
+			if !day.Before(t.StartsOn.Time) && !day.After(t.EndsOn.Time) && t.Status != data.TimeOffRejected {
+				cd.Off = append(cd.Off, t)
+			}
+		}
+
+		days = append(days, cd)
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"time_off": timeOff, "calendar": days}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) decideTimeOffHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	var input struct {
+		Status string `json:"status"`
+	}
+
+	err = app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+
+	if v.Check(validator.PermittedValue(input.Status, data.TimeOffApproved, data.TimeOffRejected), "status", "must be approved or rejected"); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	timeOff, err := app.models.TimeOff.Get(id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	var decidedBy *int64
+	if user := app.contextGetUser(r); !user.IsAnonymous() {
+		decidedBy = &user.ID
+	}
+
+	err = app.models.TimeOff.Decide(timeOff, input.Status, decidedBy, app.newAuditEntry(r, data.AuditTimeOffDecide, "time_off"))
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrTimeOffDecided):
+			app.errorResponse(w, r, http.StatusConflict, err.Error())
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"time_off": timeOff}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
@@ -2,6 +2,7 @@ package main
 
 import (
 	"errors"
+	"log/slog"
 	"net/http"
 	"strconv"
 	"time"
@@ -23,6 +24,8 @@ func (app *application) createAuthenticationTokenHandler(w http.ResponseWriter,
 		return
 	}
 
+	input.Email = data.NormalizeEmail(input.Email)
+
 	v := validator.New()
 
 	data.ValidateEmail(v, input.Email)
@@ -44,6 +47,17 @@ func (app *application) createAuthenticationTokenHandler(w http.ResponseWriter,
 		return
 	}
 
+	lockedUntil, err := app.models.Lockouts.LockedUntil(user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	if !lockedUntil.IsZero() {
+		app.accountLockedResponse(w, r, lockedUntil)
+		return
+	}
+
 	match, err := user.Password.Matches(input.Password)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
@@ -51,28 +65,188 @@ func (app *application) createAuthenticationTokenHandler(w http.ResponseWriter,
 	}
 
 	if !match {
+		lockedUntil, err := app.models.Lockouts.RegisterFailure(user.ID, app.clientIP(r),
+			app.config.login.lockoutThreshold, app.config.login.lockoutBaseDuration)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+
+		if !lockedUntil.IsZero() {
+			app.logger.Warn("account locked after failed logins", slog.Int64("user_id", user.ID),
+				slog.String("ip", app.clientIP(r)), slog.Time("locked_until", lockedUntil))
+		}
+
 		app.invalidCredentialsResponse(w, r)
 		return
 	}
 
+	if !user.Active {
+		app.inactiveAccountResponse(w, r)
+		return
+	}
+
+	err = app.models.Lockouts.Reset(user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	// bcrypt hashes and hashes with outdated parameters are upgraded while
+	// the plaintext is at hand, so nobody has to reset their password
+	if user.Password.NeedsRehash() {
+		err = user.Password.Set(input.Password)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+
+		err = app.models.Users.UpdatePassword(user)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+	}
+
+	app.completeLogin(w, r, user)
+}
+
+// completeLogin finishes a login once the user has proven who they are, either
+// with a password or through an external identity provider. It responds with
+// a JWT, or with a two-factor token when a second factor is still required.
+func (app *application) completeLogin(w http.ResponseWriter, r *http.Request, user *data.User) {
+	twoFactor, err := app.models.TwoFactor.Get(user.ID)
+	if err != nil && !errors.Is(err, data.ErrRecordNotFound) {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	required, err := app.twoFactorRequired(user)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	// the JWT is only handed out in exchange for a one-time code at /v1/tokens/two-factor
+	if twoFactor.Enabled() || required {
+		err = app.models.Tokens.DeleteAllForUser(data.ScopeTwoFactor, user.ID)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+
+		token, err := app.models.Tokens.New(user.ID, 5*time.Minute, data.ScopeTwoFactor)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+
+		env := envelope{"two_factor_token": token}
+		if !twoFactor.Enabled() {
+			env["enrolment_required"] = true
+		}
+
+		err = app.writeJSON(w, http.StatusAccepted, env, nil)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	signedToken, err := app.issueAuthenticationToken(r, user)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusCreated, envelope{"authentication_token": signedToken}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+}
+
+// issueAuthenticationToken starts a server-side session for the device the
+// request came from and returns a JWT bound to it, so that the token can be
+// revoked before it expires.
+func (app *application) issueAuthenticationToken(r *http.Request, user *data.User) (string, error) {
+	session := &data.Session{
+		UserID:    user.ID,
+		Expiry:    time.Now().Add(24 * time.Hour),
+		IP:        app.clientIP(r),
+		UserAgent: r.UserAgent(),
+		Device:    data.DescribeDevice(r.UserAgent()),
+	}
+
+	err := app.models.Sessions.Insert(session)
+	if err != nil {
+		return "", err
+	}
+
 	token := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
 		"sub": strconv.FormatInt(user.ID, 10),
+		"sid": strconv.FormatInt(session.ID, 10),
 		"iss": "github.com/obrikash/swimming_pool",
 		"iat": time.Now().Unix(),
 		"nbf": time.Now().Unix(),
 		"aud": []string{"github.com/obrikash/swimming_pool"},
-		"exp": time.Now().Add(24 * time.Hour).Unix(),
+		"exp": session.Expiry.Unix(),
 	})
 
-	signedToken, err := token.SignedString([]byte(app.config.jwt.secret))
+	return token.SignedString([]byte(app.config.jwt.secret))
+}
+
+// createPasswordResetTokenHandler mails a password reset token. The response
+// is the same whether or not the address belongs to an account, so the
+// endpoint can't be used to find out who is registered.
+func (app *application) createPasswordResetTokenHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Email string `json:"email"`
+	}
+
+	err := app.readJSON(w, r, &input)
 	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	input.Email = data.NormalizeEmail(input.Email)
+
+	v := validator.New()
+
+	if data.ValidateEmail(v, input.Email); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	user, err := app.models.Users.GetByEmail(input.Email)
+	switch {
+	case errors.Is(err, data.ErrRecordNotFound):
+	case err != nil:
 		app.serverErrorResponse(w, r, err)
 		return
+	case user.Active:
+		token, err := app.models.Tokens.New(user.ID, 45*time.Minute, data.ScopePasswordReset)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+
+		app.background(func() {
+			data := map[string]any{
+				"fullName": user.FullName,
+				"token":    token.Plaintext,
+			}
+
+			err := app.mailer.Send(user.Email, "password_reset.tmpl", data)
+			if err != nil {
+				app.logger.Error(err.Error())
+			}
+		})
 	}
 
-	err = app.writeJSON(w, http.StatusCreated, envelope{"authentication_token": signedToken}, nil)
+	err = app.writeJSON(w, http.StatusAccepted, envelope{"message": "if an account with this email exists, you will receive password reset instructions"}, nil)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
-		return
 	}
 }
@@ -0,0 +1,215 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"slices"
+
+	"github.com/obrikash/swimming_pool/internal/data"
+	"github.com/obrikash/swimming_pool/internal/validator"
+)
+
+// currentTrainer returns the trainer record of the user making the request.
+// Users who have never been assigned to a pool as trainers get errNotOwner.
+func (app *application) currentTrainer(r *http.Request) (*data.Trainer, error) {
+	user := app.contextGetUser(r)
+	if user.IsAnonymous() {
+		return nil, errNotOwner
+	}
+
+	trainer, err := app.models.Users.GetTrainer(user.ID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			return nil, errNotOwner
+		default:
+			return nil, err
+		}
+	}
+
+	return trainer, nil
+}
+
+func (app *application) listMyGroupsHandler(w http.ResponseWriter, r *http.Request) {
+	trainer, err := app.currentTrainer(r)
+	if err != nil {
+		app.authorizationErrorResponse(w, r, err)
+		return
+	}
+
+	groups, err := app.models.TrainingSessions.GetGroupsForTrainer(trainer.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"groups": groups}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// listMySessionsHandler returns the sessions of the trainer's groups between
+// the from and to dates, both inclusive. Upcoming sessions are generated
+// from the schedules on the way.
+func (app *application) listMySessionsHandler(w http.ResponseWriter, r *http.Request) {
+	trainer, err := app.currentTrainer(r)
+	if err != nil {
+		app.authorizationErrorResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+
+	qs := r.URL.Query()
+
+	from := app.readDate(qs, "from", today(), v)
+	to := app.readDate(qs, "to", data.Date{Time: from.AddDate(0, 0, 6)}, v)
+
+	if data.ValidateSessionRange(v, from, to); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = app.models.TrainingSessions.Generate(from, to)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	sessions, err := app.models.TrainingSessions.GetAllForTrainer(trainer.ID, from, to)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"sessions": sessions}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) showMyGroupRosterHandler(w http.ResponseWriter, r *http.Request) {
+	trainer, err := app.currentTrainer(r)
+	if err != nil {
+		app.authorizationErrorResponse(w, r, err)
+		return
+	}
+
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	group, err := app.models.Groups.Get(id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	// only the trainer's own groups are part of their workspace, even for
+	// trainers who may manage others
+	if group.Trainer.ID != trainer.ID {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	err = app.authorizeGroup(r, group)
+	if err != nil {
+		app.authorizationErrorResponse(w, r, err)
+		return
+	}
+
+	roster, err := app.models.TrainingSessions.GetRoster(group.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"roster": roster}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// markAttendanceHandler records who came to a session. Trainers may only mark
+// sessions of the groups they own or substitute in.
+func (app *application) markAttendanceHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	session, err := app.models.TrainingSessions.Get(id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.authorizeTrainingSession(r, session)
+	if err != nil {
+		app.authorizationErrorResponse(w, r, err)
+		return
+	}
+
+	var input struct {
+		Attendance []*data.Attendance `json:"attendance"`
+	}
+
+	err = app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+
+	if v.Check(!slices.Contains(input.Attendance, nil), "attendance", "must not contain null entries"); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	userIDs := make([]int64, len(input.Attendance))
+	for i, mark := range input.Attendance {
+		userIDs[i] = mark.UserID
+	}
+
+	v.Check(len(input.Attendance) > 0, "attendance", "must contain at least one entry")
+	v.Check(validator.Unique(userIDs), "attendance", "must not contain the same user twice")
+
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = app.models.TrainingSessions.MarkAttendance(session, input.Attendance, app.contextGetUser(r).ID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrNotGroupMember):
+			v.AddError("attendance", "must only contain members of the group")
+			app.failedValidationResponse(w, r, v.Errors)
+		case errors.Is(err, data.ErrSessionCancelled), errors.Is(err, data.ErrSessionNotOpen):
+			app.errorResponse(w, r, http.StatusConflict, err.Error())
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"attendance": input.Attendance}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
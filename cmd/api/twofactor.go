@@ -0,0 +1,360 @@
+package main
+
+import (
+	"encoding/base64"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/obrikash/swimming_pool/internal/data"
+	"github.com/obrikash/swimming_pool/internal/totp"
+	"github.com/obrikash/swimming_pool/internal/validator"
+	"github.com/skip2/go-qrcode"
+)
+
+// twoFactorRequired reports whether the policy makes two-factor
+// authentication mandatory for the user.
+func (app *application) twoFactorRequired(user *data.User) (bool, error) {
+	if !app.config.twoFactor.requiredForStaff {
+		return false, nil
+	}
+
+	role, err := app.models.Roles.Get(user.RoleID)
+	if err != nil {
+		return false, err
+	}
+
+	return role.IsStaff(), nil
+}
+
+// beginTwoFactorEnrolment generates a new secret for the user and responds
+// with everything an authenticator app needs to be set up.
+func (app *application) beginTwoFactorEnrolment(w http.ResponseWriter, r *http.Request, user *data.User) {
+	secret, err := totp.GenerateSecret()
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.models.TwoFactor.Begin(user.ID, secret)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrTwoFactorEnabled):
+			app.badRequestResponse(w, r, err)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	uri := totp.ProvisioningURI(app.config.twoFactor.issuer, user.Email, secret)
+
+	png, err := qrcode.Encode(uri, qrcode.Medium, 256)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	env := envelope{"two_factor": map[string]string{
+		"secret":           secret,
+		"provisioning_uri": uri,
+		"qr_code":          "data:image/png;base64," + base64.StdEncoding.EncodeToString(png),
+	}}
+
+	err = app.writeJSON(w, http.StatusCreated, env, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// verifySecondFactor checks a TOTP code or, for enabled two-factor
+// authentication, a recovery code. Codes for a pending enrolment confirm it,
+// in which case the new recovery codes are returned.
+func (app *application) verifySecondFactor(twoFactor *data.TwoFactor, code, recoveryCode string) (bool, []string, error) {
+	if code != "" {
+		step, ok := totp.Validate(twoFactor.Secret, code, time.Now())
+		if !ok {
+			return false, nil, nil
+		}
+
+		if !twoFactor.Enabled() {
+			recoveryCodes, err := app.models.TwoFactor.Confirm(twoFactor.UserID, step)
+			if err != nil {
+				return false, nil, err
+			}
+			return true, recoveryCodes, nil
+		}
+
+		ok, err := app.models.TwoFactor.UseStep(twoFactor.UserID, step)
+		return ok, nil, err
+	}
+
+	if recoveryCode != "" && twoFactor.Enabled() {
+		ok, err := app.models.TwoFactor.UseRecoveryCode(twoFactor.UserID, recoveryCode)
+		return ok, nil, err
+	}
+
+	return false, nil, nil
+}
+
+func (app *application) userForTwoFactorToken(w http.ResponseWriter, r *http.Request, tokenPlaintext string) *data.User {
+	v := validator.New()
+
+	if data.ValidateTokenPlaintext(v, tokenPlaintext); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return nil
+	}
+
+	user, err := app.models.Users.GetForToken(data.ScopeTwoFactor, tokenPlaintext)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			v.AddError("two_factor_token", "invalid or expired two-factor token")
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return nil
+	}
+
+	if !user.Active {
+		app.inactiveAccountResponse(w, r)
+		return nil
+	}
+
+	return user
+}
+
+func (app *application) createTwoFactorEnrolmentHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		TwoFactorToken string `json:"two_factor_token"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	user := app.userForTwoFactorToken(w, r, input.TwoFactorToken)
+	if user == nil {
+		return
+	}
+
+	app.beginTwoFactorEnrolment(w, r, user)
+}
+
+func (app *application) createTwoFactorAuthenticationTokenHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		TwoFactorToken string `json:"two_factor_token"`
+		Code           string `json:"code"`
+		RecoveryCode   string `json:"recovery_code"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+	v.Check(input.Code != "" || input.RecoveryCode != "", "code", "either code or recovery_code must be provided")
+
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	user := app.userForTwoFactorToken(w, r, input.TwoFactorToken)
+	if user == nil {
+		return
+	}
+
+	lockedUntil, err := app.models.Lockouts.LockedUntil(user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	if !lockedUntil.IsZero() {
+		app.accountLockedResponse(w, r, lockedUntil)
+		return
+	}
+
+	twoFactor, err := app.models.TwoFactor.Get(user.ID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			v.AddError("code", "two-factor authentication has not been set up")
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	ok, recoveryCodes, err := app.verifySecondFactor(twoFactor, input.Code, input.RecoveryCode)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	if !ok {
+		_, err := app.models.Lockouts.RegisterFailure(user.ID, app.clientIP(r),
+			app.config.login.lockoutThreshold, app.config.login.lockoutBaseDuration)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+
+		app.invalidCredentialsResponse(w, r)
+		return
+	}
+
+	err = app.models.Tokens.DeleteAllForUser(data.ScopeTwoFactor, user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.models.Lockouts.Reset(user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	signedToken, err := app.issueAuthenticationToken(r, user)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	env := envelope{"authentication_token": signedToken}
+	if recoveryCodes != nil {
+		env["recovery_codes"] = recoveryCodes
+	}
+
+	err = app.writeJSON(w, http.StatusCreated, env, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) enableTwoFactorHandler(w http.ResponseWriter, r *http.Request) {
+	app.beginTwoFactorEnrolment(w, r, app.contextGetUser(r))
+}
+
+func (app *application) confirmTwoFactorHandler(w http.ResponseWriter, r *http.Request) {
+	user := app.contextGetUser(r)
+
+	var input struct {
+		Code string `json:"code"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+
+	twoFactor, err := app.models.TwoFactor.Get(user.ID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			v.AddError("code", "two-factor enrolment has not been started")
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	if twoFactor.Enabled() {
+		app.badRequestResponse(w, r, data.ErrTwoFactorEnabled)
+		return
+	}
+
+	ok, recoveryCodes, err := app.verifySecondFactor(twoFactor, input.Code, "")
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	if !ok {
+		v.AddError("code", "is invalid")
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"recovery_codes": recoveryCodes}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) disableTwoFactorHandler(w http.ResponseWriter, r *http.Request) {
+	user := app.contextGetUser(r)
+
+	var input struct {
+		Code         string `json:"code"`
+		RecoveryCode string `json:"recovery_code"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	required, err := app.twoFactorRequired(user)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	if required {
+		app.notPermittedResponse(w, r)
+		return
+	}
+
+	v := validator.New()
+
+	twoFactor, err := app.models.TwoFactor.Get(user.ID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	// a pending enrolment can simply be abandoned
+	if twoFactor.Enabled() {
+		ok, _, err := app.verifySecondFactor(twoFactor, input.Code, input.RecoveryCode)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+
+		if !ok {
+			v.AddError("code", "is invalid")
+			app.failedValidationResponse(w, r, v.Errors)
+			return
+		}
+	}
+
+	err = app.models.TwoFactor.Delete(user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"message": "two-factor authentication was disabled"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
@@ -4,6 +4,9 @@ import (
 	"errors"
 	"fmt"
 	"net/http"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/obrikash/swimming_pool/internal/data"
 	"github.com/obrikash/swimming_pool/internal/validator"
@@ -14,7 +17,10 @@ func (app *application) registerUserHandler(w http.ResponseWriter, r *http.Reque
 		FullName string `json:"full_name"`
 		Email    string `json:"email"`
 		Password string `json:"password"`
-		Role     uint8  `json:"role"`
+		// Role is still accepted so existing clients keep working, but it
+		// is ignored: everyone registers as a client and administrators
+		// change roles afterwards.
+		Role uint8 `json:"role"`
 	}
 
 	err := app.readJSON(w, r, &input)
@@ -25,8 +31,7 @@ func (app *application) registerUserHandler(w http.ResponseWriter, r *http.Reque
 
 	user := &data.User{
 		FullName: input.FullName,
-		Email:    input.Email,
-		RoleID:   input.Role,
+		Email:    data.NormalizeEmail(input.Email),
 	}
 
 	err = user.Password.Set(input.Password)
@@ -75,8 +80,19 @@ func (app *application) listTrainersHandler(w http.ResponseWriter, r *http.Reque
 	}
 }
 
+// listTrainersForPoolsHandler returns the trainers assigned to each pool on
+// the date given in the query string, today by default.
 func (app *application) listTrainersForPoolsHandler(w http.ResponseWriter, r *http.Request) {
-	trainers, err := app.models.Users.GetTrainersForPools()
+	v := validator.New()
+
+	day := app.readDate(r.URL.Query(), "date", today(), v)
+
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	trainers, err := app.models.Users.GetTrainersForPools(day)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 		return
@@ -103,10 +119,16 @@ func (app *application) profitOfTrainers(w http.ResponseWriter, r *http.Request)
 	}
 }
 
+// attachTrainerToPoolHandler assigns a user to a pool as a trainer from the
+// given date, today by default. Moving a trainer to another pool means
+// ending the current assignment and adding a new one.
 func (app *application) attachTrainerToPoolHandler(w http.ResponseWriter, r *http.Request) {
 	var input struct {
-		UserID int64 `json:"user_id"`
-		PoolID int64 `json:"pool_id"`
+		UserID     int64      `json:"user_id"`
+		PoolID     int64      `json:"pool_id"`
+		StartsOn   *data.Date `json:"starts_on"`
+		EndsOn     *data.Date `json:"ends_on"`
+		DaysOfWeek []int      `json:"days_of_week"`
 	}
 
 	err := app.readJSON(w, r, &input)
@@ -115,21 +137,179 @@ func (app *application) attachTrainerToPoolHandler(w http.ResponseWriter, r *htt
 		return
 	}
 
-	err = app.models.Users.AttachTrainerToPool(input.UserID, input.PoolID)
+	assignment := &data.TrainerAssignment{
+		UserID:     input.UserID,
+		PoolID:     input.PoolID,
+		StartsOn:   today(),
+		EndsOn:     input.EndsOn,
+		DaysOfWeek: input.DaysOfWeek,
+	}
+
+	if input.StartsOn != nil {
+		assignment.StartsOn = *input.StartsOn
+	}
+
+	if assignment.DaysOfWeek == nil {
+		assignment.DaysOfWeek = []int{}
+	}
+
+	v := validator.New()
+
+	if data.ValidateTrainerAssignment(v, assignment); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	_, err = app.models.Users.Get(assignment.UserID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			v.AddError("user_id", "must be an existing user")
+		default:
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+	}
+
+	pool, err := app.models.Pools.Get(assignment.PoolID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			v.AddError("pool_id", "must be an existing pool")
+		default:
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+	}
+
+	// without a start date the assignment starts today where the pool is
+	if pool != nil && input.StartsOn == nil {
+		assignment.StartsOn = data.Today(pool.Location())
+	}
+
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	audit := app.newAuditEntry(r, data.AuditTrainerAttach, "trainer_assignment")
+
+	err = app.models.Assignments.Insert(assignment, audit)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrAssignmentOverlap):
+			app.errorResponse(w, r, http.StatusConflict, err.Error())
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusCreated, envelope{"assignment": assignment}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+}
+
+func (app *application) listTrainerAssignmentsHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	assignments, err := app.models.Assignments.GetAllForUser(id)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"assignments": assignments}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// updateTrainerAssignmentHandler changes when an assignment ends and on
+// which days it applies. The pool and the start date are history and can't
+// be changed.
+func (app *application) updateTrainerAssignmentHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	assignment, err := app.models.Assignments.Get(id)
 	if err != nil {
 		switch {
-		case errors.Is(err, data.ErrTrainerAlreadyAttached):
-			app.badRequestResponse(w, r, err)
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
 		default:
 			app.serverErrorResponse(w, r, err)
 		}
 		return
 	}
-	err = app.writeJSON(w, http.StatusCreated, envelope{"success": fmt.Sprintf("attached trainer with ID %d to pool with ID %d", input.UserID, input.PoolID)}, nil)
+
+	var input struct {
+		EndsOn     *data.Date `json:"ends_on"`
+		DaysOfWeek []int      `json:"days_of_week"`
+	}
+
+	err = app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	before := *assignment
+
+	if input.EndsOn != nil {
+		assignment.EndsOn = input.EndsOn
+	}
+
+	if input.DaysOfWeek != nil {
+		assignment.DaysOfWeek = input.DaysOfWeek
+	}
+
+	v := validator.New()
+
+	if data.ValidateTrainerAssignment(v, assignment); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	audit := app.newAuditEntry(r, data.AuditAssignmentEdit, "trainer_assignment")
+
+	err = audit.SetChanges(before, assignment)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 		return
 	}
+
+	unassigned, err := app.models.Assignments.Update(assignment, audit)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		case errors.Is(err, data.ErrAssignmentOverlap):
+			app.errorResponse(w, r, http.StatusConflict, err.Error())
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	env := envelope{"assignment": assignment}
+	if unassigned > 0 {
+		env["warnings"] = []string{fmt.Sprintf("%d upcoming sessions of the trainer's groups at the pool need a substitute", unassigned)}
+	}
+
+	err = app.writeJSON(w, http.StatusOK, env, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
 }
 
 func (app *application) profileUserHandler(w http.ResponseWriter, r *http.Request) {
@@ -141,3 +321,610 @@ func (app *application) profileUserHandler(w http.ResponseWriter, r *http.Reques
 		return
 	}
 }
+
+func (app *application) updateUserHandler(w http.ResponseWriter, r *http.Request) {
+	user := app.contextGetUser(r)
+
+	var input struct {
+		FullName  *string    `json:"full_name"`
+		Email     *string    `json:"email"`
+		Birthdate *data.Date `json:"birthdate"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	if input.FullName != nil {
+		user.FullName = *input.FullName
+	}
+
+	// the skill level is assessed by the staff, clients only state their age
+	if input.Birthdate != nil {
+		user.Birthdate = input.Birthdate
+	}
+
+	if input.Email != nil {
+		*input.Email = data.NormalizeEmail(*input.Email)
+	}
+
+	emailChanged := input.Email != nil && *input.Email != user.Email
+
+	v := validator.New()
+
+	if emailChanged {
+		data.ValidateEmail(v, *input.Email)
+	}
+
+	data.ValidateBirthdate(v, user.Birthdate)
+
+	if data.ValidateUser(v, user); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	if emailChanged {
+		// changing only the case of the own address is fine
+		existing, err := app.models.Users.GetByEmail(*input.Email)
+		switch {
+		case err == nil && existing.ID != user.ID:
+			v.AddError("email", "a user with this email address already exists")
+			app.failedValidationResponse(w, r, v.Errors)
+			return
+		case err != nil && !errors.Is(err, data.ErrRecordNotFound):
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+
+		user.PendingEmail = *input.Email
+	}
+
+	err = app.models.Users.Update(user)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrDuplicateEmail):
+			v.AddError("email", "a user with this email address already exists")
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	if emailChanged {
+		err = app.models.Tokens.DeleteAllForUser(data.ScopeEmailChange, user.ID)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+
+		token, err := app.models.Tokens.New(user.ID, 24*time.Hour, data.ScopeEmailChange)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+
+		app.background(func() {
+			data := map[string]any{
+				"fullName": user.FullName,
+				"token":    token.Plaintext,
+			}
+
+			err := app.mailer.Send(user.PendingEmail, "email_change.tmpl", data)
+			if err != nil {
+				app.logger.Error(err.Error())
+			}
+		})
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"user": user}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) confirmEmailChangeHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		TokenPlaintext string `json:"token"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+
+	if data.ValidateTokenPlaintext(v, input.TokenPlaintext); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	user, err := app.models.Users.GetForToken(data.ScopeEmailChange, input.TokenPlaintext)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			v.AddError("token", "invalid or expired email change token")
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	if user.ID != app.contextGetUser(r).ID || user.PendingEmail == "" {
+		v.AddError("token", "invalid or expired email change token")
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	user.Email = user.PendingEmail
+	user.PendingEmail = ""
+
+	err = app.models.Users.Update(user)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrDuplicateEmail):
+			v.AddError("email", "a user with this email address already exists")
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.models.Tokens.DeleteAllForUser(data.ScopeEmailChange, user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"user": user}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) changePasswordHandler(w http.ResponseWriter, r *http.Request) {
+	user := app.contextGetUser(r)
+
+	var input struct {
+		CurrentPassword string `json:"current_password"`
+		NewPassword     string `json:"new_password"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+
+	v.Check(input.CurrentPassword != "", "current_password", "must be provided")
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	match, err := user.Password.Matches(input.CurrentPassword)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	if !match {
+		v.AddError("current_password", "is incorrect")
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = user.Password.Set(input.NewPassword)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	if data.ValidateUser(v, user); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = app.models.Users.Update(user)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrDuplicateEmail):
+			v.AddError("email", "a user with this email address already exists")
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	// whoever else knew the old password is signed out everywhere
+	err = app.models.Sessions.RevokeAllForUser(user.ID, app.contextGetSessionID(r))
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"message": "your password was successfully changed"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// resetPasswordHandler sets a new password for the owner of a password reset
+// token and signs them out everywhere.
+func (app *application) resetPasswordHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Password       string `json:"password"`
+		TokenPlaintext string `json:"token"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+
+	if data.ValidateTokenPlaintext(v, input.TokenPlaintext); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	user, err := app.models.Users.GetForToken(data.ScopePasswordReset, input.TokenPlaintext)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			v.AddError("token", "invalid or expired password reset token")
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = user.Password.Set(input.Password)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	if data.ValidateUser(v, user); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = app.models.Users.UpdatePassword(user)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.models.Tokens.DeleteAllForUser(data.ScopePasswordReset, user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.models.Sessions.RevokeAllForUser(user.ID, 0)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	// proving access to the mailbox is as good as knowing the password
+	err = app.models.Lockouts.Reset(user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"message": "your password was successfully reset"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) listUsersHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Search string
+		RoleID int
+		data.Filters
+	}
+
+	v := validator.New()
+
+	qs := r.URL.Query()
+
+	input.Search = app.readString(qs, "search", "")
+	input.RoleID = app.readInt(qs, "role_id", 0, v)
+
+	input.Filters.Page = app.readInt(qs, "page", 1, v)
+	input.Filters.PageSize = app.readInt(qs, "page_size", 20, v)
+	input.Filters.Sort = app.readString(qs, "sort", "id")
+	input.Filters.SortSafelist = []string{"id", "full_name", "email", "created_at", "-id", "-full_name", "-email", "-created_at"}
+
+	if data.ValidateFilters(v, input.Filters); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	users, metadata, err := app.models.Users.GetAll(input.Search, input.RoleID, input.Filters)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"users": users, "metadata": metadata}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) updateUserByAdminHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	user, err := app.models.Users.Get(id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	var input struct {
+		RoleID     *uint8     `json:"role_id"`
+		Active     *bool      `json:"active"`
+		Birthdate  *data.Date `json:"birthdate"`
+		SkillLevel *int       `json:"skill_level"`
+	}
+
+	err = app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+
+	// an admin locking themselves out would leave nobody to undo it
+	if user.ID == app.contextGetUser(r).ID {
+		v.Check(input.RoleID == nil || *input.RoleID == user.RoleID, "role_id", "you cannot change your own role")
+		v.Check(input.Active == nil || *input.Active, "active", "you cannot deactivate your own account")
+	}
+
+	before := *user
+
+	if input.RoleID != nil {
+		user.RoleID = *input.RoleID
+	}
+
+	if input.Active != nil {
+		user.Active = *input.Active
+	}
+
+	if input.Birthdate != nil {
+		user.Birthdate = input.Birthdate
+	}
+
+	if input.SkillLevel != nil {
+		user.SkillLevel = *input.SkillLevel
+	}
+
+	data.ValidateBirthdate(v, user.Birthdate)
+	data.ValidateSkillLevel(v, user.SkillLevel)
+
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	audit := app.newAuditEntry(r, data.AuditUserUpdate, "user")
+	audit.TargetID = strconv.FormatInt(user.ID, 10)
+
+	err = audit.SetChanges(before, user)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.models.Users.UpdateByAdmin(user, audit)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrInvalidRole):
+			v.AddError("role_id", "must be an existing role")
+			app.failedValidationResponse(w, r, v.Errors)
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"user": user}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) listLockoutsHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		UserID     int
+		ActiveOnly bool
+		data.Filters
+	}
+
+	v := validator.New()
+
+	qs := r.URL.Query()
+
+	input.UserID = app.readInt(qs, "user_id", 0, v)
+	input.ActiveOnly = app.readString(qs, "active", "") == "true"
+
+	input.Filters.Page = app.readInt(qs, "page", 1, v)
+	input.Filters.PageSize = app.readInt(qs, "page_size", 20, v)
+	input.Filters.Sort = "-created_at"
+	input.Filters.SortSafelist = []string{"-created_at"}
+
+	if data.ValidateFilters(v, input.Filters); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	lockouts, metadata, err := app.models.Lockouts.GetAll(int64(input.UserID), input.ActiveOnly, input.Filters)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"lockouts": lockouts, "metadata": metadata}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) unlockUserHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	user, err := app.models.Users.Get(id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	audit := app.newAuditEntry(r, data.AuditUserUnlock, "user")
+	audit.TargetID = strconv.FormatInt(user.ID, 10)
+
+	err = app.models.Lockouts.Unlock(user.ID, app.contextGetUser(r).ID, audit)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"message": fmt.Sprintf("user with ID %d was unlocked", user.ID)}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) listEmailCollisionsHandler(w http.ResponseWriter, r *http.Request) {
+	collisions, err := app.models.Users.GetEmailCollisions()
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"email_collisions": collisions}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// mergeUsersHandler folds a duplicate account into the one from the URL. It
+// is meant for accounts whose emails only differ in case.
+func (app *application) mergeUsersHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	var input struct {
+		DuplicateID int64 `json:"duplicate_id"`
+	}
+
+	err = app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+
+	v.Check(input.DuplicateID > 0, "duplicate_id", "must be provided")
+	v.Check(input.DuplicateID != id, "duplicate_id", "must be another user")
+
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	user, err := app.models.Users.Get(id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	duplicate, err := app.models.Users.Get(input.DuplicateID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			v.AddError("duplicate_id", "must be an existing user")
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	// collisions are found by lower(trim(email)), so the same is compared here
+	if !strings.EqualFold(data.NormalizeEmail(user.Email), data.NormalizeEmail(duplicate.Email)) {
+		v.AddError("duplicate_id", "must have the same email address apart from case and surrounding spaces")
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	if duplicate.ID == app.contextGetUser(r).ID {
+		v.AddError("duplicate_id", "you cannot merge away your own account")
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = app.models.Users.Merge(user, duplicate, app.newAuditEntry(r, data.AuditUserMerge, "user"))
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrMergeConflict):
+			app.errorResponse(w, r, http.StatusConflict, err.Error())
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	app.deleteStoredImages(duplicate.Image, duplicate.Thumbnail)
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"user": user}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
@@ -0,0 +1,59 @@
+// Command breached converts a list of breached passwords into the compact
+// file read by the API's -password-breached-list flag.
+//
+//	go run ./cmd/breached -in rockyou.txt -out breached.bin
+//	go run ./cmd/breached -in pwned-passwords-sha1.txt -sha1 -out breached.bin
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/obrikash/swimming_pool/internal/breached"
+)
+
+func main() {
+	in := flag.String("in", "", "Input file, one password or SHA-1 hash per line")
+	out := flag.String("out", "breached.bin", "Output file")
+	hashed := flag.Bool("sha1", false, "Input lines are SHA-1 hashes in HASH:COUNT format")
+	flag.Parse()
+
+	if *in == "" {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	err := run(*in, *out, *hashed)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
+
+func run(in, out string, hashed bool) error {
+	r, err := os.Open(in)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+
+	w, err := os.Create(out)
+	if err != nil {
+		return err
+	}
+
+	n, err := breached.Build(w, r, hashed)
+	if err != nil {
+		w.Close()
+		return err
+	}
+
+	err = w.Close()
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("wrote %d hashes to %s\n", n, out)
+	return nil
+}
@@ -0,0 +1,69 @@
+// Command prodcal imports the Russian production calendar of a year from a
+// file in the xmlcalendar.ru format. It replaces the public holidays,
+// shortened days and moved working days of that year and cancels the
+// upcoming sessions of every pool that fall on a closed day.
+//
+//	go run ./cmd/prodcal -db-dsn=${SWIMMING_POOL_DSN} -in calendar-2025.xml
+package main
+
+import (
+	"database/sql"
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/obrikash/swimming_pool/internal/data"
+	"github.com/obrikash/swimming_pool/internal/prodcal"
+
+	_ "github.com/lib/pq"
+)
+
+func main() {
+	dsn := flag.String("db-dsn", "", "PostgreSQL DSN")
+	in := flag.String("in", "", "Input file in the xmlcalendar.ru format")
+	flag.Parse()
+
+	if *dsn == "" || *in == "" {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	err := run(*dsn, *in)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
+
+func run(dsn, in string) error {
+	r, err := os.Open(in)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+
+	year, days, err := prodcal.Parse(r)
+	if err != nil {
+		return err
+	}
+
+	db, err := sql.Open("postgres", dsn)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	audit := &data.AuditEntry{
+		Actor:      "cmd/prodcal",
+		Action:     data.AuditCalendarImport,
+		TargetType: "calendar_year",
+	}
+
+	cancelled, err := data.NewModels(db).Calendar.ImportPublic(year, days, audit)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("imported %d days of %d, cancelled %d sessions\n", len(days), year, cancelled)
+	return nil
+}
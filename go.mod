@@ -6,5 +6,10 @@ require (
 	github.com/golang-jwt/jwt/v5 v5.2.2
 	github.com/julienschmidt/httprouter v1.3.0
 	github.com/lib/pq v1.10.9
+	github.com/skip2/go-qrcode v0.0.0-20200617195104-da1b6568686e
 	golang.org/x/crypto v0.38.0
+	golang.org/x/image v0.27.0
+	golang.org/x/time v0.11.0
 )
+
+require golang.org/x/sys v0.33.0 // indirect
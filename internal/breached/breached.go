@@ -0,0 +1,140 @@
+// Package breached checks passwords against a local list of passwords known
+// from data breaches. The list is stored as the sorted SHA-1 prefixes of the
+// passwords, which keeps it compact and lets lookups binary search it
+// without any network calls.
+package breached
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha1"
+	"encoding/hex"
+	"errors"
+	"io"
+	"os"
+	"slices"
+	"strings"
+)
+
+// PrefixLength is the number of bytes kept of every hash. With 8 bytes the
+// chance of a false positive stays negligible even for a billion entries.
+const PrefixLength = 8
+
+var magic = []byte("SPBL1")
+
+var ErrInvalidList = errors.New("breached: invalid list file")
+
+type List struct {
+	prefixes []byte
+}
+
+// Open loads a list written by Build.
+func Open(path string) (*List, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	if !bytes.HasPrefix(b, magic) || (len(b)-len(magic))%PrefixLength != 0 {
+		return nil, ErrInvalidList
+	}
+
+	return &List{prefixes: b[len(magic):]}, nil
+}
+
+// Len returns the number of hashes in the list.
+func (l *List) Len() int {
+	if l == nil {
+		return 0
+	}
+
+	return len(l.prefixes) / PrefixLength
+}
+
+// Contains reports whether the password is on the list. A nil list contains
+// nothing, so the check can be left unconfigured.
+func (l *List) Contains(password string) bool {
+	if l == nil {
+		return false
+	}
+
+	sum := sha1.Sum([]byte(password))
+
+	return l.containsPrefix(sum[:PrefixLength])
+}
+
+func (l *List) containsPrefix(prefix []byte) bool {
+	lo, hi := 0, l.Len()
+
+	for lo < hi {
+		mid := int(uint(lo+hi) >> 1)
+		switch c := bytes.Compare(l.prefixes[mid*PrefixLength:(mid+1)*PrefixLength], prefix); {
+		case c == 0:
+			return true
+		case c < 0:
+			lo = mid + 1
+		default:
+			hi = mid
+		}
+	}
+
+	return false
+}
+
+// Build reads passwords from r and writes the list to w. Lines are either
+// plaintext passwords or SHA-1 hashes in the "HASH:COUNT" format of the
+// Have I Been Pwned downloads. It returns the number of unique entries.
+func Build(w io.Writer, r io.Reader, hashed bool) (int, error) {
+	var prefixes [][]byte
+
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+
+	for scanner.Scan() {
+		line := strings.TrimRight(scanner.Text(), "\r")
+		if line == "" {
+			continue
+		}
+
+		var prefix []byte
+
+		if hashed {
+			hash, _, _ := strings.Cut(line, ":")
+
+			sum, err := hex.DecodeString(hash)
+			if err != nil || len(sum) != sha1.Size {
+				return 0, ErrInvalidList
+			}
+
+			prefix = sum[:PrefixLength]
+		} else {
+			sum := sha1.Sum([]byte(line))
+			prefix = sum[:PrefixLength]
+		}
+
+		prefixes = append(prefixes, prefix)
+	}
+
+	if err := scanner.Err(); err != nil {
+		return 0, err
+	}
+
+	slices.SortFunc(prefixes, bytes.Compare)
+	prefixes = slices.CompactFunc(prefixes, bytes.Equal)
+
+	bw := bufio.NewWriter(w)
+
+	_, err := bw.Write(magic)
+	if err != nil {
+		return 0, err
+	}
+
+	for _, prefix := range prefixes {
+		_, err = bw.Write(prefix)
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	return len(prefixes), bw.Flush()
+}
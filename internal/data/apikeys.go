@@ -0,0 +1,294 @@
+package data
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base32"
+	"errors"
+	"net/netip"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
+	"github.com/obrikash/swimming_pool/internal/validator"
+)
+
+// APIKeyPrefix marks API keys so that authenticate can tell them apart from JWTs.
+const APIKeyPrefix = "sp_"
+
+// DeviceScopes are the only permissions an API key can carry. Keys are
+// long-lived bearer credentials for devices such as turnstiles and lobby
+// screens, so nothing that manages users or bypasses ownership is allowed.
+var DeviceScopes = Permissions{"checkin:write", "pools:read", "schedules:read"}
+
+type APIKey struct {
+	ID         int64      `json:"id"`
+	Name       string     `json:"name"`
+	Plaintext  string     `json:"key,omitempty"`
+	Prefix     string     `json:"prefix"`
+	Hash       []byte     `json:"-"`
+	Scopes     []string   `json:"scopes"`
+	AllowedIPs []string   `json:"allowed_ips"`
+	CreatedBy  *int64     `json:"created_by"`
+	CreatedAt  time.Time  `json:"created_at"`
+	ExpiresAt  *time.Time `json:"expires_at"`
+	LastUsedAt *time.Time `json:"last_used_at"`
+	LastUsedIP *string    `json:"last_used_ip"`
+	RevokedAt  *time.Time `json:"revoked_at"`
+}
+
+func generateAPIKey() (string, []byte, error) {
+	randomBytes := make([]byte, 20)
+	_, err := rand.Read(randomBytes)
+	if err != nil {
+		return "", nil, err
+	}
+
+	plaintext := APIKeyPrefix + strings.ToLower(base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString(randomBytes))
+
+	return plaintext, hashAPIKey(plaintext), nil
+}
+
+func hashAPIKey(plaintext string) []byte {
+	hash := sha256.Sum256([]byte(plaintext))
+	return hash[:]
+}
+
+// AllowsIP reports whether requests from ip may use the key. A key without
+// an allowlist may be used from anywhere.
+func (k *APIKey) AllowsIP(ip string) bool {
+	if len(k.AllowedIPs) == 0 {
+		return true
+	}
+
+	addr, err := netip.ParseAddr(ip)
+	if err != nil {
+		return false
+	}
+
+	for _, allowed := range k.AllowedIPs {
+		prefix, err := parseIPOrPrefix(allowed)
+		if err == nil && prefix.Contains(addr.Unmap()) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func parseIPOrPrefix(s string) (netip.Prefix, error) {
+	if strings.Contains(s, "/") {
+		return netip.ParsePrefix(s)
+	}
+
+	addr, err := netip.ParseAddr(s)
+	if err != nil {
+		return netip.Prefix{}, err
+	}
+
+	return addr.Prefix(addr.BitLen())
+}
+
+func ValidateAPIKey(v *validator.Validator, key *APIKey, permittedScopes Permissions) {
+	v.Check(key.Name != "", "name", "must be provided")
+	v.Check(len(key.Name) <= 200, "name", "must not be more than 200 bytes long")
+
+	v.Check(len(key.Scopes) > 0, "scopes", "must contain at least one scope")
+	v.Check(validator.Unique(key.Scopes), "scopes", "must not contain duplicate values")
+	for _, scope := range key.Scopes {
+		v.Check(DeviceScopes.Include(scope), "scopes", "must only contain "+strings.Join(DeviceScopes, ", "))
+		v.Check(permittedScopes.Include(scope), "scopes", "must only contain permissions you have yourself")
+	}
+
+	for _, ip := range key.AllowedIPs {
+		_, err := parseIPOrPrefix(ip)
+		v.Check(err == nil, "allowed_ips", "must only contain IP addresses or CIDR ranges")
+	}
+
+	if key.ExpiresAt != nil {
+		v.Check(key.ExpiresAt.After(time.Now()), "expires_at", "must be in the future")
+	}
+}
+
+// Permissions returns the scopes of the key that are device scopes. Keys
+// created before scopes were limited may hold others, which are ignored.
+func (key *APIKey) Permissions() Permissions {
+	var permissions Permissions
+
+	for _, scope := range key.Scopes {
+		if DeviceScopes.Include(scope) {
+			permissions = append(permissions, scope)
+		}
+	}
+
+	return permissions
+}
+
+type APIKeyModel struct {
+	DB *sql.DB
+}
+
+// Insert generates the secret of the key and stores its hash. The plaintext
+// is only available on the returned key and can't be recovered later.
+func (am APIKeyModel) Insert(key *APIKey, audit *AuditEntry) error {
+	plaintext, hash, err := generateAPIKey()
+	if err != nil {
+		return err
+	}
+
+	key.Plaintext = plaintext
+	key.Hash = hash
+	key.Prefix = plaintext[:len(APIKeyPrefix)+6]
+
+	if key.AllowedIPs == nil {
+		key.AllowedIPs = []string{}
+	}
+
+	query := `INSERT INTO api_keys (name, prefix, hash, scopes, allowed_ips, created_by, expires_at)
+	VALUES ($1, $2, $3, $4, $5, $6, $7) RETURNING id, created_at`
+
+	args := []any{key.Name, key.Prefix, key.Hash, pq.Array(key.Scopes), pq.Array(key.AllowedIPs), key.CreatedBy, key.ExpiresAt}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := am.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	err = tx.QueryRowContext(ctx, query, args...).Scan(&key.ID, &key.CreatedAt)
+	if err != nil {
+		return err
+	}
+
+	if audit != nil {
+		audit.TargetID = strconv.FormatInt(key.ID, 10)
+
+		// the plaintext is left out on purpose, it must never reach the log
+		err = audit.SetChanges(nil, map[string]any{"name": key.Name, "prefix": key.Prefix, "scopes": key.Scopes,
+			"allowed_ips": key.AllowedIPs, "expires_at": key.ExpiresAt})
+		if err != nil {
+			return err
+		}
+
+		err = insertAuditEntry(ctx, tx, audit)
+		if err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// GetForPlaintext returns the active key matching the plaintext.
+func (am APIKeyModel) GetForPlaintext(plaintext string) (*APIKey, error) {
+	query := `SELECT id, name, prefix, scopes, allowed_ips, created_by, created_at, expires_at, last_used_at, last_used_ip, revoked_at
+	FROM api_keys
+	WHERE hash = $1 AND revoked_at IS NULL AND (expires_at IS NULL OR expires_at > NOW())`
+
+	var key APIKey
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := am.DB.QueryRowContext(ctx, query, hashAPIKey(plaintext)).Scan(&key.ID, &key.Name, &key.Prefix,
+		pq.Array(&key.Scopes), pq.Array(&key.AllowedIPs), &key.CreatedBy, &key.CreatedAt, &key.ExpiresAt,
+		&key.LastUsedAt, &key.LastUsedIP, &key.RevokedAt)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	return &key, nil
+}
+
+// Touch records that the key was used. To avoid a write on every request the
+// timestamp is only moved forward once a minute.
+func (am APIKeyModel) Touch(id int64, ip string) error {
+	query := `UPDATE api_keys SET last_used_at = NOW(), last_used_ip = $1
+	WHERE id = $2 AND (last_used_at IS NULL OR last_used_at < NOW() - INTERVAL '1 minute' OR last_used_ip <> $1)`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	_, err := am.DB.ExecContext(ctx, query, ip, id)
+	return err
+}
+
+func (am APIKeyModel) GetAll() ([]*APIKey, error) {
+	query := `SELECT id, name, prefix, scopes, allowed_ips, created_by, created_at, expires_at, last_used_at, last_used_ip, revoked_at
+	FROM api_keys ORDER BY id`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := am.DB.QueryContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	keys := []*APIKey{}
+
+	for rows.Next() {
+		var key APIKey
+
+		err := rows.Scan(&key.ID, &key.Name, &key.Prefix, pq.Array(&key.Scopes), pq.Array(&key.AllowedIPs),
+			&key.CreatedBy, &key.CreatedAt, &key.ExpiresAt, &key.LastUsedAt, &key.LastUsedIP, &key.RevokedAt)
+		if err != nil {
+			return nil, err
+		}
+
+		keys = append(keys, &key)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return keys, nil
+}
+
+func (am APIKeyModel) Revoke(id int64, audit *AuditEntry) error {
+	query := `UPDATE api_keys SET revoked_at = NOW() WHERE id = $1 AND revoked_at IS NULL`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := am.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	result, err := tx.ExecContext(ctx, query, id)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+
+	err = insertAuditEntry(ctx, tx, audit)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
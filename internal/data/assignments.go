@@ -0,0 +1,344 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"slices"
+	"strconv"
+	"time"
+
+	"github.com/lib/pq"
+	"github.com/obrikash/swimming_pool/internal/validator"
+)
+
+var (
+	ErrAssignmentOverlap = errors.New("the trainer is already assigned to a pool on some of these days")
+)
+
+// TrainerAssignment is a period during which a trainer works at a pool. An
+// open end date means the assignment is ongoing. A trainer may work at
+// several pools at once as long as the assignments are on different days of
+// the week; no days means every day.
+type TrainerAssignment struct {
+	ID         int64  `json:"id"`
+	TrainerID  int64  `json:"trainer_id"`
+	UserID     int64  `json:"user_id"`
+	PoolID     int64  `json:"pool_id"`
+	PoolName   string `json:"pool_name"`
+	StartsOn   Date   `json:"starts_on"`
+	EndsOn     *Date  `json:"ends_on"`
+	DaysOfWeek []int  `json:"days_of_week"`
+}
+
+func ValidateTrainerAssignment(v *validator.Validator, a *TrainerAssignment) {
+	v.Check(a.UserID > 0, "user_id", "must be a positive integer")
+	v.Check(a.PoolID > 0, "pool_id", "must be a positive integer")
+	v.Check(!a.StartsOn.IsZero(), "starts_on", "must be provided")
+
+	if a.EndsOn != nil {
+		v.Check(!a.EndsOn.Before(a.StartsOn.Time), "ends_on", "must not be before starts_on")
+	}
+
+	for _, day := range a.DaysOfWeek {
+		v.Check(day >= 1 && day <= 7, "days_of_week", "must contain days between 1 and 7")
+	}
+
+	v.Check(validator.Unique(a.DaysOfWeek), "days_of_week", "must not contain duplicate days")
+}
+
+// Covers reports whether the assignment is in effect on some day from the
+// given one on that falls on the ISO day of the week.
+func (a *TrainerAssignment) Covers(dayOfWeek int, from Date) bool {
+	if len(a.DaysOfWeek) > 0 && !slices.Contains(a.DaysOfWeek, dayOfWeek) {
+		return false
+	}
+
+	day := a.StartsOn.Time
+	if from.After(day) {
+		day = from.Time
+	}
+
+	day = day.AddDate(0, 0, (dayOfWeek-isoWeekday(day)+7)%7)
+
+	return a.EndsOn == nil || !day.After(a.EndsOn.Time)
+}
+
+// sessionOutsideAssignment is true for the session s of group g at pool p
+// when the trainer of the group isn't assigned to the pool on the local day
+// of the session, such as after the assignment was ended early.
+const sessionOutsideAssignment = `NOT EXISTS (SELECT 1 FROM trainer_assignments a
+	WHERE a.trainer_id = g.trainer_id AND a.pool_id = p.id
+	AND (s.starts_at AT TIME ZONE p.time_zone)::date >= a.starts_on
+	AND (a.ends_on IS NULL OR (s.starts_at AT TIME ZONE p.time_zone)::date <= a.ends_on)
+	AND (a.days_of_week IS NULL OR EXTRACT(ISODOW FROM s.starts_at AT TIME ZONE p.time_zone)::int = ANY(a.days_of_week)))`
+
+type TrainerAssignmentModel struct {
+	DB *sql.DB
+}
+
+// Insert assigns the user to the pool, creating their trainer record on the
+// first assignment. It refuses assignments that overlap another one of the
+// trainer in dates and days, except for a different pool on other days.
+func (am TrainerAssignmentModel) Insert(a *TrainerAssignment, audit *AuditEntry) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := am.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	// the trainer row is locked so concurrent assignments can't both pass
+	// the overlap check
+	query := `INSERT INTO trainers (user_id) VALUES ($1)
+	ON CONFLICT (user_id) DO UPDATE SET user_id = EXCLUDED.user_id
+	RETURNING id`
+
+	err = tx.QueryRowContext(ctx, query, a.UserID).Scan(&a.TrainerID)
+	if err != nil {
+		switch {
+		case err.Error() == `pq: insert or update on table "trainers" violates foreign key constraint "trainers_user_id_fkey"`:
+			return ErrRecordNotFound
+		default:
+			return err
+		}
+	}
+
+	err = checkAssignmentOverlap(ctx, tx, a)
+	if err != nil {
+		return err
+	}
+
+	query = `INSERT INTO trainer_assignments (trainer_id, pool_id, starts_on, ends_on, days_of_week)
+	VALUES ($1, $2, $3, $4, $5)
+	RETURNING id, (SELECT name FROM pools WHERE id = $2)`
+
+	args := []any{a.TrainerID, a.PoolID, a.StartsOn, a.EndsOn, daysOfWeek(a.DaysOfWeek)}
+
+	err = tx.QueryRowContext(ctx, query, args...).Scan(&a.ID, &a.PoolName)
+	if err != nil {
+		switch {
+		case err.Error() == `pq: insert or update on table "trainer_assignments" violates foreign key constraint "trainer_assignments_pool_id_fkey"`:
+			return ErrRecordNotFound
+		default:
+			return err
+		}
+	}
+
+	if audit != nil {
+		audit.TargetID = strconv.FormatInt(a.ID, 10)
+
+		err = audit.SetChanges(nil, a)
+		if err != nil {
+			return err
+		}
+
+		err = insertAuditEntry(ctx, tx, audit)
+		if err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+func (am TrainerAssignmentModel) Get(id int64) (*TrainerAssignment, error) {
+	if id < 1 {
+		return nil, ErrRecordNotFound
+	}
+
+	query := `SELECT a.id, a.trainer_id, t.user_id, a.pool_id, p.name, a.starts_on, a.ends_on, a.days_of_week
+	FROM trainer_assignments a JOIN trainers t ON t.id = a.trainer_id JOIN pools p ON p.id = a.pool_id
+	WHERE a.id = $1`
+
+	var a TrainerAssignment
+	var days []int64
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := am.DB.QueryRowContext(ctx, query, id).Scan(&a.ID, &a.TrainerID, &a.UserID, &a.PoolID, &a.PoolName,
+		&a.StartsOn, &a.EndsOn, pq.Array(&days))
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	a.DaysOfWeek = intsFromArray(days)
+
+	return &a, nil
+}
+
+// GetAllForUser returns the assignment history of the user, latest first.
+func (am TrainerAssignmentModel) GetAllForUser(userID int64) ([]*TrainerAssignment, error) {
+	query := `SELECT a.id, a.trainer_id, t.user_id, a.pool_id, p.name, a.starts_on, a.ends_on, a.days_of_week
+	FROM trainer_assignments a JOIN trainers t ON t.id = a.trainer_id JOIN pools p ON p.id = a.pool_id
+	WHERE t.user_id = $1
+	ORDER BY a.starts_on DESC, a.id DESC`
+
+	return am.query(query, userID)
+}
+
+// GetAllForTrainer returns the assignment history of the trainer, latest
+// first.
+func (am TrainerAssignmentModel) GetAllForTrainer(trainerID int64) ([]*TrainerAssignment, error) {
+	query := `SELECT a.id, a.trainer_id, t.user_id, a.pool_id, p.name, a.starts_on, a.ends_on, a.days_of_week
+	FROM trainer_assignments a JOIN trainers t ON t.id = a.trainer_id JOIN pools p ON p.id = a.pool_id
+	WHERE a.trainer_id = $1
+	ORDER BY a.starts_on DESC, a.id DESC`
+
+	return am.query(query, trainerID)
+}
+
+func (am TrainerAssignmentModel) query(query string, args ...any) ([]*TrainerAssignment, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := am.DB.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	assignments := []*TrainerAssignment{}
+
+	for rows.Next() {
+		var a TrainerAssignment
+		var days []int64
+
+		err := rows.Scan(&a.ID, &a.TrainerID, &a.UserID, &a.PoolID, &a.PoolName, &a.StartsOn, &a.EndsOn, pq.Array(&days))
+		if err != nil {
+			return nil, err
+		}
+
+		a.DaysOfWeek = intsFromArray(days)
+		assignments = append(assignments, &a)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return assignments, nil
+}
+
+// Update changes the end date and the days of the assignment, which is how a
+// trainer is moved to another pool: the old assignment ends and a new one
+// starts. Upcoming sessions already generated that the trainer is no longer
+// assigned for are left to a substitute; it returns how many there are.
+func (am TrainerAssignmentModel) Update(a *TrainerAssignment, audit *AuditEntry) (int64, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := am.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.ExecContext(ctx, `SELECT id FROM trainers WHERE id = $1 FOR UPDATE`, a.TrainerID)
+	if err != nil {
+		return 0, err
+	}
+
+	err = checkAssignmentOverlap(ctx, tx, a)
+	if err != nil {
+		return 0, err
+	}
+
+	query := `UPDATE trainer_assignments SET ends_on = $1, days_of_week = $2 WHERE id = $3`
+
+	result, err := tx.ExecContext(ctx, query, a.EndsOn, daysOfWeek(a.DaysOfWeek), a.ID)
+	if err != nil {
+		return 0, err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return 0, err
+	}
+
+	if rowsAffected == 0 {
+		return 0, ErrRecordNotFound
+	}
+
+	var unassigned int64
+
+	query = `SELECT count(*) FROM training_sessions s
+	JOIN training_groups g ON g.id = s.group_id
+	JOIN pools p ON p.id = g.pool_id
+	WHERE g.trainer_id = $1 AND g.pool_id = $2 AND s.starts_at > NOW()
+	AND s.cancelled_at IS NULL AND s.substitute_trainer_id IS NULL AND ` + sessionOutsideAssignment
+
+	err = tx.QueryRowContext(ctx, query, a.TrainerID, a.PoolID).Scan(&unassigned)
+	if err != nil {
+		return 0, err
+	}
+
+	if audit != nil {
+		audit.TargetID = strconv.FormatInt(a.ID, 10)
+
+		err = insertAuditEntry(ctx, tx, audit)
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	return unassigned, tx.Commit()
+}
+
+// checkAssignmentOverlap returns ErrAssignmentOverlap when another assignment
+// of the trainer is in effect on some of the same dates, unless it is at a
+// different pool and on different days of the week.
+func checkAssignmentOverlap(ctx context.Context, tx *sql.Tx, a *TrainerAssignment) error {
+	query := `SELECT EXISTS (
+		SELECT 1 FROM trainer_assignments
+		WHERE trainer_id = $1 AND id <> $2
+		AND daterange(starts_on, ends_on, '[]') && daterange($3::date, $4::date, '[]')
+		AND (pool_id = $5 OR days_of_week IS NULL OR $6::int[] IS NULL OR days_of_week && $6::int[])
+	)`
+
+	args := []any{a.TrainerID, a.ID, a.StartsOn, a.EndsOn, a.PoolID, daysOfWeek(a.DaysOfWeek)}
+
+	var overlaps bool
+
+	err := tx.QueryRowContext(ctx, query, args...).Scan(&overlaps)
+	if err != nil {
+		return err
+	}
+
+	if overlaps {
+		return ErrAssignmentOverlap
+	}
+
+	return nil
+}
+
+// daysOfWeek turns an empty list of days into NULL, meaning every day.
+func daysOfWeek(days []int) any {
+	if len(days) == 0 {
+		return nil
+	}
+
+	sorted := slices.Clone(days)
+	slices.Sort(sorted)
+
+	return pq.Array(sorted)
+}
+
+func intsFromArray(values []int64) []int {
+	ints := []int{}
+
+	for _, value := range values {
+		ints = append(ints, int(value))
+	}
+
+	return ints
+}
@@ -0,0 +1,186 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"reflect"
+	"time"
+)
+
+const (
+	AuditTrainerAttach   = "trainer.attach"
+	AuditAssignmentEdit  = "trainer.assignment"
+	AuditTimeOffDecide   = "trainer.time_off"
+	AuditTimeOffCancel   = "trainer.time_off_cancel"
+	AuditSubstitute      = "session.substitute"
+	AuditPoolUpdate      = "pool.update"
+	AuditPoolHours       = "pool.opening_hours"
+	AuditCalendarCreate  = "calendar.create"
+	AuditCalendarDelete  = "calendar.delete"
+	AuditCalendarImport  = "calendar.import"
+	AuditGroupCreate     = "group.create"
+	AuditGroupUpdate     = "group.update"
+	AuditGroupArchive    = "group.archive"
+	AuditEligibility     = "category.eligibility"
+	AuditReferenceCreate = "reference.create"
+	AuditReferenceUpdate = "reference.update"
+	AuditReferenceDelete = "reference.delete"
+	AuditUserUpdate      = "user.update"
+	AuditUserUnlock      = "user.unlock"
+	AuditUserAnonymise   = "user.anonymise"
+	AuditUserMerge       = "user.merge"
+	AuditAPIKeyCreate    = "api_key.create"
+	AuditAPIKeyRevoke    = "api_key.revoke"
+)
+
+// AuditEntry records who did what to which record. Entries are written in
+// the same transaction as the change they describe, so a change is never
+// committed without its entry.
+type AuditEntry struct {
+	ID         int64           `json:"id"`
+	CreatedAt  time.Time       `json:"created_at"`
+	ActorID    *int64          `json:"actor_id"`
+	APIKeyID   *int64          `json:"api_key_id"`
+	Actor      string          `json:"actor"`
+	Action     string          `json:"action"`
+	TargetType string          `json:"target_type"`
+	TargetID   string          `json:"target_id"`
+	Changes    json.RawMessage `json:"changes"`
+	IP         string          `json:"ip"`
+	RequestID  string          `json:"request_id"`
+}
+
+type change struct {
+	Before any `json:"before"`
+	After  any `json:"after"`
+}
+
+// SetChanges stores the fields that differ between the JSON representations
+// of before and after. Either may be nil for records that are created or
+// removed.
+func (e *AuditEntry) SetChanges(before, after any) error {
+	beforeFields, err := jsonFields(before)
+	if err != nil {
+		return err
+	}
+
+	afterFields, err := jsonFields(after)
+	if err != nil {
+		return err
+	}
+
+	changes := make(map[string]change)
+
+	for key, value := range beforeFields {
+		if !reflect.DeepEqual(value, afterFields[key]) {
+			changes[key] = change{Before: value, After: afterFields[key]}
+		}
+	}
+
+	for key, value := range afterFields {
+		if _, ok := beforeFields[key]; !ok {
+			changes[key] = change{After: value}
+		}
+	}
+
+	e.Changes, err = json.Marshal(changes)
+	return err
+}
+
+func jsonFields(v any) (map[string]any, error) {
+	fields := make(map[string]any)
+
+	if v == nil {
+		return fields, nil
+	}
+
+	js, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+
+	err = json.Unmarshal(js, &fields)
+	return fields, err
+}
+
+func insertAuditEntry(ctx context.Context, tx *sql.Tx, entry *AuditEntry) error {
+	if entry == nil {
+		return nil
+	}
+
+	if entry.Changes == nil {
+		entry.Changes = json.RawMessage(`{}`)
+	}
+
+	query := `INSERT INTO audit_log (actor_id, api_key_id, actor, action, target_type, target_id, changes, ip, request_id)
+	VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9) RETURNING id, created_at`
+
+	args := []any{entry.ActorID, entry.APIKeyID, entry.Actor, entry.Action, entry.TargetType, entry.TargetID,
+		[]byte(entry.Changes), entry.IP, entry.RequestID}
+
+	return tx.QueryRowContext(ctx, query, args...).Scan(&entry.ID, &entry.CreatedAt)
+}
+
+type AuditFilters struct {
+	ActorID    int64
+	Action     string
+	TargetType string
+	TargetID   string
+	From       *time.Time
+	To         *time.Time
+}
+
+type AuditModel struct {
+	DB *sql.DB
+}
+
+func (am AuditModel) GetAll(af AuditFilters, filters Filters) ([]*AuditEntry, Metadata, error) {
+	query := `SELECT count(*) OVER(), id, created_at, actor_id, api_key_id, actor, action, target_type, target_id, changes, ip, request_id
+	FROM audit_log
+	WHERE (actor_id = $1 OR $1 = 0)
+	AND (action = $2 OR $2 = '')
+	AND (target_type = $3 OR $3 = '')
+	AND (target_id = $4 OR $4 = '')
+	AND (created_at >= $5 OR $5 IS NULL)
+	AND (created_at < $6 OR $6 IS NULL)
+	ORDER BY created_at DESC, id DESC
+	LIMIT $7 OFFSET $8`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	args := []any{af.ActorID, af.Action, af.TargetType, af.TargetID, af.From, af.To, filters.limit(), filters.offset()}
+
+	rows, err := am.DB.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+
+	defer rows.Close()
+
+	totalRecords := 0
+	entries := []*AuditEntry{}
+
+	for rows.Next() {
+		var entry AuditEntry
+		var changes []byte
+
+		err := rows.Scan(&totalRecords, &entry.ID, &entry.CreatedAt, &entry.ActorID, &entry.APIKeyID, &entry.Actor, &entry.Action,
+			&entry.TargetType, &entry.TargetID, &changes, &entry.IP, &entry.RequestID)
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+
+		entry.Changes = changes
+		entries = append(entries, &entry)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, Metadata{}, err
+	}
+
+	metadata := calculateMetadata(totalRecords, filters.Page, filters.PageSize)
+
+	return entries, metadata, nil
+}
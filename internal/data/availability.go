@@ -0,0 +1,122 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/obrikash/swimming_pool/internal/validator"
+)
+
+// AvailabilityWindow is a span of a day of the week the trainer can work.
+// Times are "HH:MM", the end is exclusive.
+type AvailabilityWindow struct {
+	DayOfWeek int    `json:"day_of_week"`
+	StartsAt  string `json:"starts_at"`
+	EndsAt    string `json:"ends_at"`
+}
+
+func ValidateAvailability(v *validator.Validator, windows []*AvailabilityWindow) {
+	for i, w := range windows {
+		key := fmt.Sprintf("windows[%d]", i)
+
+		v.Check(w.DayOfWeek >= 1 && w.DayOfWeek <= 7, key, "day_of_week must be between 1 and 7")
+		v.Check(validator.Matches(w.StartsAt, TimeOfDayRX), key, "starts_at must be in HH:MM format")
+		v.Check(validator.Matches(w.EndsAt, TimeOfDayRX), key, "ends_at must be in HH:MM format")
+		v.Check(w.EndsAt > w.StartsAt, key, "ends_at must be after starts_at")
+
+		for _, other := range windows[:i] {
+			if other.DayOfWeek == w.DayOfWeek && other.StartsAt < w.EndsAt && w.StartsAt < other.EndsAt {
+				v.AddError(key, "must not overlap another window on the same day")
+				break
+			}
+		}
+	}
+}
+
+// IsAvailable reports whether a trainer with the given weekly windows can
+// run a session of the given length on the day of the week at the time. The
+// session has to be over by the end of the window. Trainers who haven't
+// declared any windows are always available.
+func IsAvailable(windows []*AvailabilityWindow, dayOfWeek int, timeOfDay string, durationMinutes int) bool {
+	if len(windows) == 0 {
+		return true
+	}
+
+	for _, w := range windows {
+		if w.DayOfWeek == dayOfWeek && w.StartsAt <= timeOfDay &&
+			minutesOfDay(timeOfDay)+durationMinutes <= closingMinutes(w.EndsAt) {
+			return true
+		}
+	}
+
+	return false
+}
+
+type AvailabilityModel struct {
+	DB *sql.DB
+}
+
+func (am AvailabilityModel) GetForTrainer(trainerID int64) ([]*AvailabilityWindow, error) {
+	query := `SELECT day_of_week, to_char(starts_at, 'HH24:MI'), to_char(ends_at, 'HH24:MI')
+	FROM trainer_availability WHERE trainer_id = $1
+	ORDER BY day_of_week, starts_at`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := am.DB.QueryContext(ctx, query, trainerID)
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	windows := []*AvailabilityWindow{}
+
+	for rows.Next() {
+		var w AvailabilityWindow
+
+		err := rows.Scan(&w.DayOfWeek, &w.StartsAt, &w.EndsAt)
+		if err != nil {
+			return nil, err
+		}
+
+		windows = append(windows, &w)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return windows, nil
+}
+
+// Replace sets the weekly availability of the trainer to the given windows.
+func (am AvailabilityModel) Replace(trainerID int64, windows []*AvailabilityWindow) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := am.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.ExecContext(ctx, `DELETE FROM trainer_availability WHERE trainer_id = $1`, trainerID)
+	if err != nil {
+		return err
+	}
+
+	query := `INSERT INTO trainer_availability (trainer_id, day_of_week, starts_at, ends_at) VALUES ($1, $2, $3, $4)`
+
+	for _, w := range windows {
+		_, err = tx.ExecContext(ctx, query, trainerID, w.DayOfWeek, w.StartsAt, w.EndsAt)
+		if err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
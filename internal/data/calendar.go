@@ -0,0 +1,465 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/obrikash/swimming_pool/internal/validator"
+)
+
+var (
+	ErrPoolClosed           = errors.New("the pool is closed at that time")
+	ErrDuplicateCalendarDay = errors.New("the pool already has an exception on that day")
+)
+
+// Public days come from the production calendar and apply to every pool,
+// the others are set for a single pool.
+const (
+	CalendarHoliday      = "holiday"
+	CalendarShortDay     = "short_day"
+	CalendarWorkingDay   = "working_day"
+	CalendarClosure      = "closure"
+	CalendarSpecialHours = "special_hours"
+)
+
+// sessionWithinHours is true for the session s at pool p when it starts and
+// ends while the pool is open on its local day.
+const sessionWithinHours = `EXISTS (SELECT 1 FROM pool_hours(p.id, (s.starts_at AT TIME ZONE p.time_zone)::date) h
+	WHERE (s.starts_at AT TIME ZONE p.time_zone)::time >= h.opens_at
+	AND (s.starts_at AT TIME ZONE p.time_zone)::time::interval + s.duration_minutes * interval '1 minute' <= h.closes_at::interval)`
+
+// OpeningHours is when a pool is open on a day of the week. Times are
+// "HH:MM", the closing time is exclusive and may be "24:00".
+type OpeningHours struct {
+	DayOfWeek int    `json:"day_of_week"`
+	OpensAt   string `json:"opens_at"`
+	ClosesAt  string `json:"closes_at"`
+}
+
+// CalendarDay is an exception to the weekly opening hours on a date.
+type CalendarDay struct {
+	ID       int64   `json:"id"`
+	PoolID   *int64  `json:"pool_id"`
+	Date     Date    `json:"date"`
+	Kind     string  `json:"kind"`
+	OpensAt  *string `json:"opens_at,omitempty"`
+	ClosesAt *string `json:"closes_at,omitempty"`
+	Note     string  `json:"note"`
+}
+
+// DayHours tells whether and when a pool is open on a date, and which
+// calendar exception, if any, decided it.
+type DayHours struct {
+	Date     Date   `json:"date"`
+	Open     bool   `json:"open"`
+	OpensAt  string `json:"opens_at,omitempty"`
+	ClosesAt string `json:"closes_at,omitempty"`
+	Kind     string `json:"kind,omitempty"`
+	Note     string `json:"note,omitempty"`
+}
+
+// Fits reports whether a session of the given length starting at the time
+// of day is over before the pool closes.
+func (h *DayHours) Fits(timeOfDay string, durationMinutes int) bool {
+	if !h.Open {
+		return false
+	}
+
+	start := minutesOfDay(timeOfDay)
+	return start >= minutesOfDay(h.OpensAt) && start+durationMinutes <= closingMinutes(h.ClosesAt)
+}
+
+// closingMinutes is minutesOfDay that also understands "24:00".
+func closingMinutes(timeOfDay string) int {
+	if timeOfDay == "24:00" {
+		return MinutesPerDay
+	}
+
+	return minutesOfDay(timeOfDay)
+}
+
+func validateOpeningTimes(v *validator.Validator, key, opensAt, closesAt string) {
+	v.Check(validator.Matches(opensAt, TimeOfDayRX), key, "opens_at must be in HH:MM format")
+	v.Check(closesAt == "24:00" || validator.Matches(closesAt, TimeOfDayRX), key, "closes_at must be in HH:MM format")
+	v.Check(closingMinutes(closesAt) > minutesOfDay(opensAt), key, "closes_at must be after opens_at")
+}
+
+func ValidateOpeningHours(v *validator.Validator, hours []*OpeningHours) {
+	for i, h := range hours {
+		key := fmt.Sprintf("hours[%d]", i)
+
+		v.Check(h.DayOfWeek >= 1 && h.DayOfWeek <= 7, key, "day_of_week must be between 1 and 7")
+		validateOpeningTimes(v, key, h.OpensAt, h.ClosesAt)
+
+		for _, other := range hours[:i] {
+			if other.DayOfWeek == h.DayOfWeek {
+				v.AddError(key, "must not repeat a day of the week")
+				break
+			}
+		}
+	}
+}
+
+// ValidateCalendarDay checks an exception set for a single pool.
+func ValidateCalendarDay(v *validator.Validator, day *CalendarDay) {
+	v.Check(!day.Date.IsZero(), "date", "must be provided")
+	v.Check(validator.PermittedValue(day.Kind, CalendarClosure, CalendarSpecialHours), "kind", "must be closure or special_hours")
+	v.Check(len(day.Note) <= 500, "note", "must not be more than 500 bytes long")
+
+	if day.Kind == CalendarSpecialHours {
+		if v.Check(day.OpensAt != nil && day.ClosesAt != nil, "opens_at", "must be provided for special hours"); v.Valid() {
+			validateOpeningTimes(v, "opens_at", *day.OpensAt, *day.ClosesAt)
+		}
+	} else {
+		v.Check(day.OpensAt == nil && day.ClosesAt == nil, "opens_at", "must only be provided for special hours")
+	}
+}
+
+// FitsOpeningHours reports whether a weekly session fits the opening hours
+// of its day of the week. Pools without opening hours are always open.
+func FitsOpeningHours(hours []*OpeningHours, dayOfWeek int, timeOfDay string, durationMinutes int) bool {
+	if len(hours) == 0 {
+		return true
+	}
+
+	for _, h := range hours {
+		if h.DayOfWeek == dayOfWeek {
+			day := DayHours{Open: true, OpensAt: h.OpensAt, ClosesAt: h.ClosesAt}
+			return day.Fits(timeOfDay, durationMinutes)
+		}
+	}
+
+	return false
+}
+
+type CalendarModel struct {
+	DB *sql.DB
+}
+
+func (cm CalendarModel) GetOpeningHours(poolID int64) ([]*OpeningHours, error) {
+	query := `SELECT day_of_week, to_char(opens_at, 'HH24:MI'), to_char(closes_at, 'HH24:MI')
+	FROM pool_opening_hours WHERE pool_id = $1
+	ORDER BY day_of_week`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := cm.DB.QueryContext(ctx, query, poolID)
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	hours := []*OpeningHours{}
+
+	for rows.Next() {
+		var h OpeningHours
+
+		err := rows.Scan(&h.DayOfWeek, &h.OpensAt, &h.ClosesAt)
+		if err != nil {
+			return nil, err
+		}
+
+		hours = append(hours, &h)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return hours, nil
+}
+
+// ReplaceOpeningHours sets the weekly opening hours of the pool and cancels
+// the upcoming sessions that no longer fit. It returns how many were
+// cancelled.
+func (cm CalendarModel) ReplaceOpeningHours(poolID int64, hours []*OpeningHours, audit *AuditEntry) (int64, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	tx, err := cm.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.ExecContext(ctx, `DELETE FROM pool_opening_hours WHERE pool_id = $1`, poolID)
+	if err != nil {
+		return 0, err
+	}
+
+	query := `INSERT INTO pool_opening_hours (pool_id, day_of_week, opens_at, closes_at) VALUES ($1, $2, $3, $4)`
+
+	for _, h := range hours {
+		_, err = tx.ExecContext(ctx, query, poolID, h.DayOfWeek, h.OpensAt, h.ClosesAt)
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	cancelled, err := cancelSessionsOutsideHours(ctx, tx, &poolID, nil, nil)
+	if err != nil {
+		return 0, err
+	}
+
+	if audit != nil {
+		audit.TargetID = strconv.FormatInt(poolID, 10)
+
+		err = audit.SetChanges(nil, map[string]any{"opening_hours": hours, "cancelled_sessions": cancelled})
+		if err != nil {
+			return 0, err
+		}
+
+		err = insertAuditEntry(ctx, tx, audit)
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	return cancelled, tx.Commit()
+}
+
+// GetHours returns whether and when the pool is open on every day between
+// from and to, both inclusive.
+func (cm CalendarModel) GetHours(poolID int64, from, to Date) ([]*DayHours, error) {
+	query := `SELECT d.day::date, h.opens_at IS NOT NULL, COALESCE(to_char(h.opens_at, 'HH24:MI'), ''),
+	COALESCE(to_char(h.closes_at, 'HH24:MI'), ''), COALESCE(e.kind, ''), COALESCE(e.note, '')
+	FROM generate_series($2::date, $3::date, interval '1 day') AS d(day)
+	LEFT JOIN LATERAL (SELECT * FROM pool_hours($1, d.day::date) LIMIT 1) h ON true
+	LEFT JOIN LATERAL (
+		SELECT c.kind, c.note FROM calendar_days c
+		WHERE c.day = d.day::date AND (c.pool_id = $1 OR c.pool_id IS NULL)
+		ORDER BY c.pool_id IS NULL
+		LIMIT 1
+	) e ON true
+	ORDER BY d.day`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := cm.DB.QueryContext(ctx, query, poolID, from, to)
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	days := []*DayHours{}
+
+	for rows.Next() {
+		var day DayHours
+
+		err := rows.Scan(&day.Date, &day.Open, &day.OpensAt, &day.ClosesAt, &day.Kind, &day.Note)
+		if err != nil {
+			return nil, err
+		}
+
+		days = append(days, &day)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return days, nil
+}
+
+func (cm CalendarModel) GetDay(id int64) (*CalendarDay, error) {
+	if id < 1 {
+		return nil, ErrRecordNotFound
+	}
+
+	query := `SELECT id, pool_id, day, kind, to_char(opens_at, 'HH24:MI'), to_char(closes_at, 'HH24:MI'), note
+	FROM calendar_days WHERE id = $1`
+
+	var day CalendarDay
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := cm.DB.QueryRowContext(ctx, query, id).Scan(&day.ID, &day.PoolID, &day.Date, &day.Kind, &day.OpensAt, &day.ClosesAt, &day.Note)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	return &day, nil
+}
+
+// InsertDay adds an exception for a pool and cancels its sessions on that
+// day that fall outside the new hours. It returns how many were cancelled.
+func (cm CalendarModel) InsertDay(day *CalendarDay, audit *AuditEntry) (int64, error) {
+	query := `INSERT INTO calendar_days (pool_id, day, kind, opens_at, closes_at, note)
+	VALUES ($1, $2, $3, $4, $5, $6)
+	RETURNING id`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := cm.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	args := []any{day.PoolID, day.Date, day.Kind, day.OpensAt, day.ClosesAt, day.Note}
+
+	err = tx.QueryRowContext(ctx, query, args...).Scan(&day.ID)
+	if err != nil {
+		switch {
+		case err.Error() == `pq: duplicate key value violates unique constraint "calendar_days_pool_day_idx"`:
+			return 0, ErrDuplicateCalendarDay
+		default:
+			return 0, err
+		}
+	}
+
+	cancelled, err := cancelSessionsOutsideHours(ctx, tx, day.PoolID, &day.Date, &day.Date)
+	if err != nil {
+		return 0, err
+	}
+
+	if audit != nil {
+		audit.TargetID = strconv.FormatInt(day.ID, 10)
+
+		err = audit.SetChanges(nil, day)
+		if err != nil {
+			return 0, err
+		}
+
+		err = insertAuditEntry(ctx, tx, audit)
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	return cancelled, tx.Commit()
+}
+
+// DeleteDay removes an exception of a pool. Sessions it cancelled stay
+// cancelled, sessions not generated yet will be.
+func (cm CalendarModel) DeleteDay(day *CalendarDay, audit *AuditEntry) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := cm.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	result, err := tx.ExecContext(ctx, `DELETE FROM calendar_days WHERE id = $1 AND pool_id IS NOT NULL`, day.ID)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+
+	if audit != nil {
+		audit.TargetID = strconv.FormatInt(day.ID, 10)
+
+		err = audit.SetChanges(day, nil)
+		if err != nil {
+			return err
+		}
+
+		err = insertAuditEntry(ctx, tx, audit)
+		if err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// ImportPublic replaces the public calendar of the year with the given
+// days, which all have to fall into it, and cancels the upcoming sessions
+// of every pool that no longer fit. It returns how many were cancelled.
+func (cm CalendarModel) ImportPublic(year int, days []*CalendarDay, audit *AuditEntry) (int64, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	tx, err := cm.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	from, to := NewDate(year, time.January, 1), NewDate(year, time.December, 31)
+
+	_, err = tx.ExecContext(ctx, `DELETE FROM calendar_days WHERE pool_id IS NULL AND day BETWEEN $1 AND $2`, from, to)
+	if err != nil {
+		return 0, err
+	}
+
+	query := `INSERT INTO calendar_days (day, kind, note) VALUES ($1, $2, $3)`
+
+	for _, day := range days {
+		if day.Date.Year() != year {
+			return 0, fmt.Errorf("day %s is not in %d", day.Date, year)
+		}
+
+		_, err = tx.ExecContext(ctx, query, day.Date, day.Kind, day.Note)
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	cancelled, err := cancelSessionsOutsideHours(ctx, tx, nil, &from, &to)
+	if err != nil {
+		return 0, err
+	}
+
+	if audit != nil {
+		audit.TargetID = strconv.Itoa(year)
+
+		err = audit.SetChanges(nil, map[string]any{"year": year, "days": len(days), "cancelled_sessions": cancelled})
+		if err != nil {
+			return 0, err
+		}
+
+		err = insertAuditEntry(ctx, tx, audit)
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	return cancelled, tx.Commit()
+}
+
+// cancelSessionsOutsideHours cancels the upcoming sessions of the pool, or of
+// every pool when poolID is nil, that fall outside its opening hours on
+// their local day. The from and to dates, when given, limit the days looked
+// at.
+func cancelSessionsOutsideHours(ctx context.Context, tx *sql.Tx, poolID *int64, from, to *Date) (int64, error) {
+	query := `UPDATE training_sessions s SET cancelled_at = NOW()
+	FROM training_groups g JOIN pools p ON p.id = g.pool_id
+	WHERE g.id = s.group_id AND s.cancelled_at IS NULL AND s.starts_at > NOW()
+	AND ($1::int IS NULL OR p.id = $1)
+	AND ($2::date IS NULL OR (s.starts_at AT TIME ZONE p.time_zone)::date >= $2)
+	AND ($3::date IS NULL OR (s.starts_at AT TIME ZONE p.time_zone)::date <= $3)
+	AND NOT ` + sessionWithinHours
+
+	result, err := tx.ExecContext(ctx, query, poolID, from, to)
+	if err != nil {
+		return 0, err
+	}
+
+	return result.RowsAffected()
+}
@@ -0,0 +1,100 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+)
+
+var (
+	ErrNoSessionToCheckIn   = errors.New("the member has no session at the pool open for check-in")
+	ErrNoActiveSubscription = errors.New("the member has no active subscription")
+)
+
+// CheckInOpensBefore is how long before the start of a session members may
+// check in. Check-in closes when the session ends.
+const CheckInOpensBefore = 30 * time.Minute
+
+// CheckIn marks the member present at the session of one of their groups at
+// the pool that is open for check-in at the given time. Nobody gets in on
+// days the pool is closed. A check-in is an attendance mark without a
+// trainer; of it and the mark of the trainer the later one counts.
+func (tm TrainingSessionModel) CheckIn(userID, poolID int64, at time.Time) (*TrainingSession, *Attendance, error) {
+	query := `SELECT s.id, s.group_id, s.schedule_id, c.name, p.name, p.time_zone, s.starts_at, s.duration_minutes, s.lanes
+	FROM training_sessions s
+	JOIN training_groups g ON g.id = s.group_id
+	JOIN user_groups ug ON ug.group_id = g.id AND ug.user_id = $1
+	JOIN group_category c ON c.id = g.category_id
+	JOIN pools p ON p.id = g.pool_id
+	WHERE g.pool_id = $2 AND s.cancelled_at IS NULL AND ` + sessionWithinHours + `
+	AND s.starts_at - $4 * interval '1 second' <= $3 AND s.starts_at + s.duration_minutes * interval '1 minute' > $3
+	ORDER BY s.starts_at
+	LIMIT 1`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := tm.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer tx.Rollback()
+
+	var open bool
+
+	err = tx.QueryRowContext(ctx, `SELECT EXISTS (SELECT 1 FROM pools p, pool_hours(p.id, ($2::timestamptz AT TIME ZONE p.time_zone)::date)
+		WHERE p.id = $1)`, poolID, at).Scan(&open)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if !open {
+		return nil, nil, ErrPoolClosed
+	}
+
+	var session TrainingSession
+
+	err = tx.QueryRowContext(ctx, query, userID, poolID, at, CheckInOpensBefore.Seconds()).Scan(&session.ID, &session.GroupID,
+		&session.ScheduleID, &session.Category, &session.PoolName, &session.TimeZone, &session.StartsAt,
+		&session.DurationMinutes, &session.Lanes)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, nil, ErrNoSessionToCheckIn
+		default:
+			return nil, nil, err
+		}
+	}
+
+	session.localize()
+
+	var subscribed bool
+
+	err = tx.QueryRowContext(ctx, `SELECT `+activeSubscription, userID, at).Scan(&subscribed)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if !subscribed {
+		return nil, nil, ErrNoActiveSubscription
+	}
+
+	mark := &Attendance{SessionID: session.ID, UserID: userID, Present: true}
+
+	query = `INSERT INTO attendance (session_id, user_id, present)
+	VALUES ($1, $2, true)
+	ON CONFLICT (session_id, user_id) DO UPDATE SET present = true, marked_by = NULL, marked_at = NOW()
+	RETURNING marked_at`
+
+	err = tx.QueryRowContext(ctx, query, session.ID, userID).Scan(&mark.MarkedAt)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	mark.StartsAt = &session.StartsAt
+	mark.TimeZone = session.TimeZone
+	mark.MarkedAt = mark.MarkedAt.In(session.StartsAt.Location())
+
+	return &session, mark, tx.Commit()
+}
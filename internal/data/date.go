@@ -0,0 +1,83 @@
+package data
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+// Date is a calendar date without a time of day, such as a birthdate. It is
+// written to JSON as "YYYY-MM-DD".
+type Date struct {
+	time.Time
+}
+
+func NewDate(year int, month time.Month, day int) Date {
+	return Date{time.Date(year, month, day, 0, 0, 0, 0, time.UTC)}
+}
+
+func ParseDate(s string) (Date, error) {
+	t, err := time.Parse(time.DateOnly, s)
+	if err != nil {
+		return Date{}, err
+	}
+
+	return Date{t}, nil
+}
+
+func (d Date) String() string {
+	return d.Format(time.DateOnly)
+}
+
+func (d Date) MarshalJSON() ([]byte, error) {
+	return json.Marshal(d.String())
+}
+
+func (d *Date) UnmarshalJSON(b []byte) error {
+	var s string
+
+	err := json.Unmarshal(b, &s)
+	if err != nil {
+		return err
+	}
+
+	parsed, err := ParseDate(s)
+	if err != nil {
+		return fmt.Errorf("invalid date %q, must be in YYYY-MM-DD format", s)
+	}
+
+	*d = parsed
+	return nil
+}
+
+func (d *Date) Scan(src any) error {
+	t, ok := src.(time.Time)
+	if !ok {
+		return fmt.Errorf("cannot scan %T into Date", src)
+	}
+
+	*d = NewDate(t.Year(), t.Month(), t.Day())
+	return nil
+}
+
+func (d Date) Value() (driver.Value, error) {
+	return d.String(), nil
+}
+
+// ISOWeekday returns the day of the week of d, 1 for Monday to 7 for Sunday.
+func (d Date) ISOWeekday() int {
+	return isoWeekday(d.Time)
+}
+
+// YearsUntil returns the age in full years on the given day of someone born
+// on d.
+func (d Date) YearsUntil(t time.Time) int {
+	years := t.Year() - d.Year()
+
+	if t.Month() < d.Month() || (t.Month() == d.Month() && t.Day() < d.Day()) {
+		years--
+	}
+
+	return years
+}
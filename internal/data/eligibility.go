@@ -0,0 +1,185 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/obrikash/swimming_pool/internal/validator"
+)
+
+// Skill levels of clients, from someone who can't swim yet to a competing
+// athlete.
+const (
+	SkillNone = iota
+	SkillBeginner
+	SkillIntermediate
+	SkillAdvanced
+	SkillAthlete
+)
+
+var SkillLevelNames = []string{"не умеет плавать", "начальный", "средний", "продвинутый", "спортсмен"}
+
+func ValidateSkillLevel(v *validator.Validator, level int) {
+	v.Check(level >= SkillNone && level <= SkillAthlete, "skill_level", "must be between 0 and 4")
+}
+
+func ValidateBirthdate(v *validator.Validator, birthdate *Date) {
+	if birthdate == nil {
+		return
+	}
+
+	v.Check(birthdate.Before(time.Now()), "birthdate", "must be in the past")
+	v.Check(birthdate.Year() >= 1900, "birthdate", "must be after 1900")
+}
+
+// Eligibility is who may enroll in the groups of a category. A nil age bound
+// means there is no limit on that side.
+type Eligibility struct {
+	CategoryID    int64  `json:"category_id"`
+	CategoryName  string `json:"category_name"`
+	MinAge        *int   `json:"min_age"`
+	MaxAge        *int   `json:"max_age"`
+	MinSkillLevel int    `json:"min_skill_level"`
+}
+
+func ValidateEligibility(v *validator.Validator, e *Eligibility) {
+	if e.MinAge != nil {
+		v.Check(*e.MinAge >= 0 && *e.MinAge <= 120, "min_age", "must be between 0 and 120")
+	}
+
+	if e.MaxAge != nil {
+		v.Check(*e.MaxAge >= 0 && *e.MaxAge <= 120, "max_age", "must be between 0 and 120")
+	}
+
+	if e.MinAge != nil && e.MaxAge != nil {
+		v.Check(*e.MinAge <= *e.MaxAge, "max_age", "must not be less than min_age")
+	}
+
+	if e.MinSkillLevel < SkillNone || e.MinSkillLevel > SkillAthlete {
+		v.AddError("min_skill_level", "must be between 0 and 4")
+	}
+}
+
+// CheckEligibility adds an error for every rule of the category the user
+// doesn't meet, explaining what is required.
+func CheckEligibility(v *validator.Validator, user *User, e *Eligibility, today time.Time) {
+	if e.MinAge != nil || e.MaxAge != nil {
+		if user.Birthdate == nil {
+			v.AddError("birthdate", fmt.Sprintf("must be set in your profile to enroll in the %q category, it has an age limit", e.CategoryName))
+		} else {
+			age := user.Birthdate.YearsUntil(today)
+
+			switch {
+			case e.MinAge != nil && age < *e.MinAge:
+				v.AddError("age", fmt.Sprintf("must be at least %d for the %q category, you are %d", *e.MinAge, e.CategoryName, age))
+			case e.MaxAge != nil && age > *e.MaxAge:
+				v.AddError("age", fmt.Sprintf("must be at most %d for the %q category, you are %d", *e.MaxAge, e.CategoryName, age))
+			}
+		}
+	}
+
+	if user.SkillLevel < e.MinSkillLevel {
+		v.AddError("skill_level", fmt.Sprintf("must be at least %d (%s) for the %q category, yours is %d (%s)",
+			e.MinSkillLevel, SkillLevelNames[e.MinSkillLevel], e.CategoryName, user.SkillLevel, SkillLevelNames[user.SkillLevel]))
+	}
+}
+
+type EligibilityModel struct {
+	DB *sql.DB
+}
+
+// Get returns the rules of the category. Categories without rules are open
+// to everyone.
+func (em EligibilityModel) Get(categoryID int64) (*Eligibility, error) {
+	query := `SELECT c.id, c.name, e.min_age, e.max_age, COALESCE(e.min_skill_level, 0)
+	FROM group_category c LEFT JOIN category_eligibility e ON e.category_id = c.id
+	WHERE c.id = $1`
+
+	var e Eligibility
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := em.DB.QueryRowContext(ctx, query, categoryID).Scan(&e.CategoryID, &e.CategoryName, &e.MinAge, &e.MaxAge, &e.MinSkillLevel)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	return &e, nil
+}
+
+func (em EligibilityModel) GetAll() ([]*Eligibility, error) {
+	query := `SELECT c.id, c.name, e.min_age, e.max_age, COALESCE(e.min_skill_level, 0)
+	FROM group_category c LEFT JOIN category_eligibility e ON e.category_id = c.id
+	ORDER BY c.id`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := em.DB.QueryContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	rules := []*Eligibility{}
+
+	for rows.Next() {
+		var e Eligibility
+
+		err := rows.Scan(&e.CategoryID, &e.CategoryName, &e.MinAge, &e.MaxAge, &e.MinSkillLevel)
+		if err != nil {
+			return nil, err
+		}
+
+		rules = append(rules, &e)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return rules, nil
+}
+
+func (em EligibilityModel) Upsert(e *Eligibility, audit *AuditEntry) error {
+	query := `INSERT INTO category_eligibility (category_id, min_age, max_age, min_skill_level)
+	VALUES ($1, $2, $3, $4)
+	ON CONFLICT (category_id) DO UPDATE SET min_age = EXCLUDED.min_age, max_age = EXCLUDED.max_age,
+	min_skill_level = EXCLUDED.min_skill_level`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := em.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.ExecContext(ctx, query, e.CategoryID, e.MinAge, e.MaxAge, e.MinSkillLevel)
+	if err != nil {
+		return err
+	}
+
+	if audit != nil {
+		audit.TargetID = strconv.FormatInt(e.CategoryID, 10)
+
+		err = insertAuditEntry(ctx, tx, audit)
+		if err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
@@ -4,20 +4,31 @@ import (
 	"context"
 	"database/sql"
 	"errors"
+	"strconv"
 	"strings"
 	"time"
 )
 
 var (
-	ErrTrainerOnlyOnePool = errors.New("Trainer could only work in one pool, not at both")
-	ErrDuplicateGroup     = errors.New("group already exists for this pool, category, and trainer")
+	ErrTrainerNotInPool = errors.New("the trainer is not assigned to the pool of the group")
+	ErrDuplicateGroup   = errors.New("group already exists for this pool, category, and trainer")
+	ErrGroupArchived    = errors.New("group is archived")
+	ErrAlreadyMember    = errors.New("user is already a member of the group")
 )
 
 type Group struct {
-	ID       int64 `json:"id"`
-	Pool     int64 `json:"pool"`
-	Category int64 `json:"category"`
-	Trainer  User  `json:"trainer"`
+	ID         int64      `json:"id"`
+	Pool       int64      `json:"pool"`
+	Category   int64      `json:"category"`
+	Trainer    User       `json:"trainer"`
+	ArchivedAt *time.Time `json:"archived_at,omitempty"`
+}
+
+// GroupMember is a client enrolled in a group.
+type GroupMember struct {
+	UserID   int64  `json:"user_id"`
+	FullName string `json:"full_name"`
+	Email    string `json:"-"`
 }
 
 type Groups struct {
@@ -36,7 +47,8 @@ type GroupModel struct {
 func (gm GroupModel) GetGroups() ([]*Groups, error) {
 	query := `SELECT g.id, c.name as "category", p.name as "pool", tr.full_name, t.user_id, tr.image
 	FROM training_groups g JOIN group_category c ON g.category_id = c.id 
-	JOIN pools p ON g.pool_id = p.id JOIN trainers t ON g.trainer_id = t.id JOIN users tr ON t.user_id = tr.id;`
+	JOIN pools p ON g.pool_id = p.id JOIN trainers t ON g.trainer_id = t.id JOIN users tr ON t.user_id = tr.id
+	WHERE g.archived_at IS NULL;`
 
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
@@ -68,16 +80,86 @@ func (gm GroupModel) GetGroups() ([]*Groups, error) {
 	return groups, nil
 }
 
-func (gm GroupModel) AddToPool(group *Group) error {
-	// we make this check so one trainer would not work in 2 pools, only at 1
+// GetAllForUser returns the groups the user is a member of.
+func (gm GroupModel) GetAllForUser(userID int64) ([]*Groups, error) {
+	query := `SELECT g.id, c.name as "category", p.name as "pool", tr.full_name, t.user_id, tr.image
+	FROM user_groups ug JOIN training_groups g ON ug.group_id = g.id
+	JOIN group_category c ON g.category_id = c.id JOIN pools p ON g.pool_id = p.id
+	JOIN trainers t ON g.trainer_id = t.id JOIN users tr ON t.user_id = tr.id
+	WHERE ug.user_id = $1
+	ORDER BY g.id`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := gm.DB.QueryContext(ctx, query, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	groups := []*Groups{}
+
+	for rows.Next() {
+		var group Groups
+
+		err := rows.Scan(&group.ID, &group.Category, &group.PoolName, &group.TrainerName, &group.UserID, &group.Image)
+		if err != nil {
+			return nil, err
+		}
+
+		groups = append(groups, &group)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return groups, nil
+}
+
+func (gm GroupModel) Get(id int64) (*Group, error) {
+	if id < 1 {
+		return nil, ErrRecordNotFound
+	}
+
+	query := `SELECT id, pool_id, category_id, trainer_id, archived_at FROM training_groups WHERE id = $1`
+
+	var group Group
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := gm.DB.QueryRowContext(ctx, query, id).Scan(&group.ID, &group.Pool, &group.Category, &group.Trainer.ID, &group.ArchivedAt)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	return &group, nil
+}
+
+func (gm GroupModel) AddToPool(group *Group, audit *AuditEntry) error {
+	// the trainer has to be assigned to the pool now or from some day on
 	query := `INSERT INTO training_groups (pool_id, category_id, trainer_id)
 SELECT $1, $2, id 
-FROM trainers 
-WHERE id = $3 AND pool_id = $1
+FROM trainers t
+WHERE id = $3
+AND EXISTS (
+    SELECT 1
+    FROM trainer_assignments a
+    JOIN pools p ON p.id = a.pool_id
+    WHERE a.trainer_id = t.id AND a.pool_id = $1 AND (a.ends_on IS NULL OR a.ends_on >= (NOW() AT TIME ZONE p.time_zone)::date)
+)
 AND NOT EXISTS (
     SELECT 1
     FROM training_groups
-    WHERE pool_id = $1 AND category_id = $2 AND trainer_id = $3
+    WHERE pool_id = $1 AND category_id = $2 AND trainer_id = $3 AND archived_at IS NULL
 )
 RETURNING id;`
 
@@ -86,11 +168,65 @@ RETURNING id;`
 
 	args := []any{group.Pool, group.Category, group.Trainer.ID}
 
-	err := gm.DB.QueryRowContext(ctx, query, args...).Scan(&group.ID)
+	tx, err := gm.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	err = tx.QueryRowContext(ctx, query, args...).Scan(&group.ID)
 	if err != nil {
 		switch {
 		case errors.Is(err, sql.ErrNoRows):
-			return ErrTrainerOnlyOnePool
+			return ErrTrainerNotInPool
+		case strings.Contains(err.Error(), "unique_group_per_pool_category_trainer"):
+			return ErrDuplicateGroup
+		default:
+			return err
+		}
+	}
+
+	if audit != nil {
+		audit.TargetID = strconv.FormatInt(group.ID, 10)
+
+		err = audit.SetChanges(nil, map[string]int64{"pool_id": group.Pool, "category_id": group.Category, "trainer_id": group.Trainer.ID})
+		if err != nil {
+			return err
+		}
+
+		err = insertAuditEntry(ctx, tx, audit)
+		if err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// Update changes the category and trainer of the group. Like AddToPool it
+// only accepts a trainer assigned to the pool of the group.
+func (gm GroupModel) Update(group *Group, audit *AuditEntry) error {
+	query := `UPDATE training_groups g SET category_id = $1, trainer_id = t.id
+	FROM trainers t
+	WHERE g.id = $3 AND g.archived_at IS NULL AND t.id = $2
+	AND EXISTS (SELECT 1 FROM trainer_assignments a JOIN pools p ON p.id = a.pool_id
+		WHERE a.trainer_id = t.id AND a.pool_id = g.pool_id
+		AND (a.ends_on IS NULL OR a.ends_on >= (NOW() AT TIME ZONE p.time_zone)::date))`
+
+	args := []any{group.Category, group.Trainer.ID, group.ID}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := gm.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	result, err := tx.ExecContext(ctx, query, args...)
+	if err != nil {
+		switch {
 		case strings.Contains(err.Error(), "unique_group_per_pool_category_trainer"):
 			return ErrDuplicateGroup
 		default:
@@ -98,5 +234,133 @@ RETURNING id;`
 		}
 	}
 
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return ErrTrainerNotInPool
+	}
+
+	err = insertAuditEntry(ctx, tx, audit)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// Archive closes the group. The group and its memberships are kept for the
+// history of the members, but its schedules are removed and its upcoming
+// sessions cancelled.
+func (gm GroupModel) Archive(group *Group, audit *AuditEntry) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := gm.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	query := `UPDATE training_groups SET archived_at = NOW() WHERE id = $1 AND archived_at IS NULL
+	RETURNING archived_at`
+
+	err = tx.QueryRowContext(ctx, query, group.ID).Scan(&group.ArchivedAt)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrGroupArchived
+		default:
+			return err
+		}
+	}
+
+	query = `UPDATE training_sessions SET cancelled_at = NOW()
+	WHERE group_id = $1 AND starts_at > NOW() AND cancelled_at IS NULL`
+
+	_, err = tx.ExecContext(ctx, query, group.ID)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.ExecContext(ctx, `DELETE FROM schedules WHERE group_id = $1`, group.ID)
+	if err != nil {
+		return err
+	}
+
+	err = insertAuditEntry(ctx, tx, audit)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func (gm GroupModel) GetMembers(groupID int64) ([]*GroupMember, error) {
+	query := `SELECT u.id, u.full_name, u.email
+	FROM user_groups ug JOIN users u ON ug.user_id = u.id
+	WHERE ug.group_id = $1
+	ORDER BY u.full_name`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := gm.DB.QueryContext(ctx, query, groupID)
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	members := []*GroupMember{}
+
+	for rows.Next() {
+		var member GroupMember
+
+		err := rows.Scan(&member.UserID, &member.FullName, &member.Email)
+		if err != nil {
+			return nil, err
+		}
+
+		members = append(members, &member)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return members, nil
+}
+
+// AddMember enrolls the user in the group. Eligibility is checked by the
+// caller, since its errors are meant for the user.
+func (gm GroupModel) AddMember(groupID, userID int64) error {
+	query := `INSERT INTO user_groups (user_id, group_id)
+	SELECT $1, id FROM training_groups WHERE id = $2 AND archived_at IS NULL`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	result, err := gm.DB.ExecContext(ctx, query, userID, groupID)
+	if err != nil {
+		switch {
+		case strings.Contains(err.Error(), "user_groups_pkey"):
+			return ErrAlreadyMember
+		default:
+			return err
+		}
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return ErrGroupArchived
+	}
+
 	return nil
 }
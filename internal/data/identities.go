@@ -0,0 +1,121 @@
+package data
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"errors"
+	"time"
+)
+
+var (
+	ErrDuplicateIdentity = errors.New("external identity is already linked to another user")
+)
+
+type Identity struct {
+	ID        int64     `json:"id"`
+	UserID    int64     `json:"user_id"`
+	Provider  string    `json:"provider"`
+	Subject   string    `json:"subject"`
+	Email     string    `json:"email"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// OIDCLogin is a sign-in through an external provider that has been started
+// but not yet completed. It is looked up by the state parameter.
+type OIDCLogin struct {
+	State        string
+	Provider     string
+	Nonce        string
+	CodeVerifier string
+	Expiry       time.Time
+}
+
+type IdentityModel struct {
+	DB *sql.DB
+}
+
+func (im IdentityModel) GetUserID(provider, subject string) (int64, error) {
+	query := `SELECT user_id FROM user_identities WHERE provider = $1 AND subject = $2`
+
+	var userID int64
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := im.DB.QueryRowContext(ctx, query, provider, subject).Scan(&userID)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return 0, ErrRecordNotFound
+		default:
+			return 0, err
+		}
+	}
+
+	return userID, nil
+}
+
+func (im IdentityModel) Insert(identity *Identity) error {
+	query := `INSERT INTO user_identities (user_id, provider, subject, email) VALUES ($1, $2, $3, $4)
+	RETURNING id, created_at`
+
+	args := []any{identity.UserID, identity.Provider, identity.Subject, identity.Email}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := im.DB.QueryRowContext(ctx, query, args...).Scan(&identity.ID, &identity.CreatedAt)
+	if err != nil {
+		switch {
+		case err.Error() == `pq: duplicate key value violates unique constraint "user_identities_provider_subject_key"`:
+			return ErrDuplicateIdentity
+		default:
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (im IdentityModel) InsertLogin(login *OIDCLogin) error {
+	query := `INSERT INTO oidc_logins (state_hash, provider, nonce, code_verifier, expiry) VALUES ($1, $2, $3, $4, $5)`
+
+	stateHash := sha256.Sum256([]byte(login.State))
+	args := []any{stateHash[:], login.Provider, login.Nonce, login.CodeVerifier, login.Expiry}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	_, err := im.DB.ExecContext(ctx, query, args...)
+	return err
+}
+
+// TakeLogin returns the pending login for the state and deletes it, so that
+// every state can be used only once. Expired logins are cleaned up on the way.
+func (im IdentityModel) TakeLogin(state string) (*OIDCLogin, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	_, err := im.DB.ExecContext(ctx, `DELETE FROM oidc_logins WHERE expiry < NOW()`)
+	if err != nil {
+		return nil, err
+	}
+
+	query := `DELETE FROM oidc_logins WHERE state_hash = $1 RETURNING provider, nonce, code_verifier, expiry`
+
+	stateHash := sha256.Sum256([]byte(state))
+	login := OIDCLogin{State: state}
+
+	err = im.DB.QueryRowContext(ctx, query, stateHash[:]).Scan(&login.Provider, &login.Nonce, &login.CodeVerifier, &login.Expiry)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	return &login, nil
+}
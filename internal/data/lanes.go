@@ -0,0 +1,48 @@
+package data
+
+import "errors"
+
+var (
+	ErrPoolFull   = errors.New("the pool has no free lanes at that time")
+	ErrLanesInUse = errors.New("the pool has schedules using more lanes at once")
+)
+
+// MinutesPerDay bounds the spans of a day; sessions have to end by midnight.
+const MinutesPerDay = 24 * 60
+
+// LaneUse is a span of a day, in minutes since midnight with the end
+// exclusive, during which a number of lanes of a pool are taken.
+type LaneUse struct {
+	Start int
+	End   int
+	Lanes int
+}
+
+// PeakLanes returns the most lanes taken at once between from and to. The
+// count can only go up where a span starts, so it is enough to look at from
+// and at the starts that fall inside the window.
+func PeakLanes(uses []LaneUse, from, to int) int {
+	points := []int{from}
+
+	for _, u := range uses {
+		if u.Start > from && u.Start < to {
+			points = append(points, u.Start)
+		}
+	}
+
+	peak := 0
+
+	for _, p := range points {
+		lanes := 0
+
+		for _, u := range uses {
+			if u.Start <= p && p < u.End {
+				lanes += u.Lanes
+			}
+		}
+
+		peak = max(peak, lanes)
+	}
+
+	return peak
+}
@@ -0,0 +1,196 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+)
+
+// MaxLockoutDuration caps the progressive lockout so that an account is never
+// locked for longer than a day by failed attempts alone.
+const MaxLockoutDuration = 24 * time.Hour
+
+type LockoutEvent struct {
+	ID             int64      `json:"id"`
+	UserID         int64      `json:"user_id"`
+	Email          string     `json:"email"`
+	CreatedAt      time.Time  `json:"created_at"`
+	IP             string     `json:"ip"`
+	FailedAttempts int        `json:"failed_attempts"`
+	LockedUntil    time.Time  `json:"locked_until"`
+	UnlockedAt     *time.Time `json:"unlocked_at,omitempty"`
+	UnlockedBy     *int64     `json:"unlocked_by,omitempty"`
+}
+
+type LockoutModel struct {
+	DB *sql.DB
+}
+
+// LockedUntil returns the time the account stays locked until, or the zero
+// time when the account is not locked.
+func (lm LockoutModel) LockedUntil(userID int64) (time.Time, error) {
+	query := `SELECT locked_until FROM login_failures WHERE user_id = $1 AND locked_until > NOW()`
+
+	var lockedUntil time.Time
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := lm.DB.QueryRowContext(ctx, query, userID).Scan(&lockedUntil)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return time.Time{}, nil
+		default:
+			return time.Time{}, err
+		}
+	}
+
+	return lockedUntil, nil
+}
+
+// RegisterFailure counts a failed login attempt. Every threshold-th
+// consecutive failure locks the account, each lockout twice as long as the
+// previous one. Failures older than a day are forgotten. It returns the time
+// the account is locked until, or the zero time if it wasn't locked.
+func (lm LockoutModel) RegisterFailure(userID int64, ip string, threshold int, baseDuration time.Duration) (time.Time, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := lm.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return time.Time{}, err
+	}
+	defer tx.Rollback()
+
+	query := `INSERT INTO login_failures (user_id, failed_attempts, last_failed_at) VALUES ($1, 1, NOW())
+	ON CONFLICT (user_id) DO UPDATE SET
+		failed_attempts = CASE WHEN login_failures.last_failed_at < NOW() - INTERVAL '24 hours' THEN 1
+			ELSE login_failures.failed_attempts + 1 END,
+		last_failed_at = NOW()
+	RETURNING failed_attempts`
+
+	var failedAttempts int
+
+	err = tx.QueryRowContext(ctx, query, userID).Scan(&failedAttempts)
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	if threshold < 1 || failedAttempts%threshold != 0 {
+		return time.Time{}, tx.Commit()
+	}
+
+	duration := baseDuration
+	for i := 1; i < failedAttempts/threshold && duration < MaxLockoutDuration; i++ {
+		duration *= 2
+	}
+	duration = min(duration, MaxLockoutDuration)
+
+	lockedUntil := time.Now().Add(duration).Truncate(time.Second)
+
+	query = `UPDATE login_failures SET locked_until = $1 WHERE user_id = $2`
+
+	_, err = tx.ExecContext(ctx, query, lockedUntil, userID)
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	query = `INSERT INTO lockout_events (user_id, ip, failed_attempts, locked_until) VALUES ($1, $2, $3, $4)`
+
+	_, err = tx.ExecContext(ctx, query, userID, ip, failedAttempts, lockedUntil)
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	return lockedUntil, tx.Commit()
+}
+
+func (lm LockoutModel) Reset(userID int64) error {
+	query := `DELETE FROM login_failures WHERE user_id = $1`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	_, err := lm.DB.ExecContext(ctx, query, userID)
+	return err
+}
+
+// Unlock clears the failure counter of the user and marks their active
+// lockouts as lifted by the given admin.
+func (lm LockoutModel) Unlock(userID, adminID int64, audit *AuditEntry) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := lm.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.ExecContext(ctx, `DELETE FROM login_failures WHERE user_id = $1`, userID)
+	if err != nil {
+		return err
+	}
+
+	query := `UPDATE lockout_events SET unlocked_at = NOW(), unlocked_by = NULLIF($1, 0)
+	WHERE user_id = $2 AND unlocked_at IS NULL AND locked_until > NOW()`
+
+	_, err = tx.ExecContext(ctx, query, adminID, userID)
+	if err != nil {
+		return err
+	}
+
+	err = insertAuditEntry(ctx, tx, audit)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func (lm LockoutModel) GetAll(userID int64, activeOnly bool, filters Filters) ([]*LockoutEvent, Metadata, error) {
+	query := `SELECT count(*) OVER(), e.id, e.user_id, u.email, e.created_at, e.ip, e.failed_attempts,
+	e.locked_until, e.unlocked_at, e.unlocked_by
+	FROM lockout_events e JOIN users u ON e.user_id = u.id
+	WHERE (e.user_id = $1 OR $1 = 0)
+	AND (NOT $2 OR (e.unlocked_at IS NULL AND e.locked_until > NOW()))
+	ORDER BY e.created_at DESC, e.id DESC
+	LIMIT $3 OFFSET $4`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	args := []any{userID, activeOnly, filters.limit(), filters.offset()}
+
+	rows, err := lm.DB.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+
+	defer rows.Close()
+
+	totalRecords := 0
+	events := []*LockoutEvent{}
+
+	for rows.Next() {
+		var event LockoutEvent
+
+		err := rows.Scan(&totalRecords, &event.ID, &event.UserID, &event.Email, &event.CreatedAt, &event.IP,
+			&event.FailedAttempts, &event.LockedUntil, &event.UnlockedAt, &event.UnlockedBy)
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+
+		events = append(events, &event)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, Metadata{}, err
+	}
+
+	metadata := calculateMetadata(totalRecords, filters.Page, filters.PageSize)
+
+	return events, metadata, nil
+}
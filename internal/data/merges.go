@@ -0,0 +1,144 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// EmailCollision is a group of accounts whose emails only differ in case.
+type EmailCollision struct {
+	Email   string  `json:"email"`
+	UserIDs []int64 `json:"user_ids"`
+}
+
+func (um UserModel) GetEmailCollisions() ([]*EmailCollision, error) {
+	query := `SELECT lower(trim(email)), array_agg(id ORDER BY id)
+	FROM users
+	GROUP BY lower(trim(email))
+	HAVING count(*) > 1
+	ORDER BY 1`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := um.DB.QueryContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	collisions := []*EmailCollision{}
+
+	for rows.Next() {
+		var collision EmailCollision
+
+		err := rows.Scan(&collision.Email, pq.Array(&collision.UserIDs))
+		if err != nil {
+			return nil, err
+		}
+
+		collisions = append(collisions, &collision)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return collisions, nil
+}
+
+// Merge moves the subscriptions, group memberships, attendance, trainer record,
+// linked identities and created API keys of duplicate over to user and deletes
+// duplicate. Cases that need a human decision, such as both accounts holding
+// the same subscription or both being trainers, are refused with
+// ErrMergeConflict before anything is changed.
+func (um UserModel) Merge(user, duplicate *User, audit *AuditEntry) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	tx, err := um.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	// the rows of both accounts are locked so nothing is added to them while
+	// they are being merged
+	_, err = tx.ExecContext(ctx, `SELECT id FROM users WHERE id IN ($1, $2) FOR UPDATE`, user.ID, duplicate.ID)
+	if err != nil {
+		return err
+	}
+
+	var subscriptionID int64
+
+	query := `SELECT d.subscription_id FROM user_subscriptions d JOIN user_subscriptions u
+	ON u.subscription_id = d.subscription_id AND u.user_id = $1
+	WHERE d.user_id = $2 LIMIT 1`
+
+	err = tx.QueryRowContext(ctx, query, user.ID, duplicate.ID).Scan(&subscriptionID)
+	if err == nil {
+		return fmt.Errorf("%w: both accounts hold subscription %d", ErrMergeConflict, subscriptionID)
+	}
+	if !errors.Is(err, sql.ErrNoRows) {
+		return err
+	}
+
+	var trainers int
+
+	err = tx.QueryRowContext(ctx, `SELECT count(*) FROM trainers WHERE user_id IN ($1, $2)`, user.ID, duplicate.ID).Scan(&trainers)
+	if err != nil {
+		return err
+	}
+
+	if trainers > 1 {
+		return fmt.Errorf("%w: both accounts are attached as trainers", ErrMergeConflict)
+	}
+
+	statements := []string{
+		`UPDATE user_subscriptions SET user_id = $1 WHERE user_id = $2`,
+		`INSERT INTO user_groups (user_id, group_id) SELECT $1, group_id FROM user_groups WHERE user_id = $2
+		ON CONFLICT DO NOTHING`,
+		`DELETE FROM user_groups WHERE user_id = $2`,
+		`INSERT INTO attendance (session_id, user_id, present, marked_by, marked_at)
+		SELECT session_id, $1, present, marked_by, marked_at FROM attendance WHERE user_id = $2
+		ON CONFLICT (session_id, user_id) DO NOTHING`,
+		`DELETE FROM attendance WHERE user_id = $2`,
+		`UPDATE attendance SET marked_by = $1 WHERE marked_by = $2`,
+		`UPDATE trainer_time_off SET decided_by = $1 WHERE decided_by = $2`,
+		`UPDATE trainers SET user_id = $1 WHERE user_id = $2`,
+		`UPDATE user_identities SET user_id = $1 WHERE user_id = $2`,
+		`UPDATE api_keys SET created_by = $1 WHERE created_by = $2`,
+		`UPDATE lockout_events SET unlocked_by = $1 WHERE unlocked_by = $2`,
+		`DELETE FROM users WHERE id = $2`,
+	}
+
+	for _, statement := range statements {
+		_, err = tx.ExecContext(ctx, statement, user.ID, duplicate.ID)
+		if err != nil {
+			return err
+		}
+	}
+
+	if audit != nil {
+		audit.TargetID = strconv.FormatInt(user.ID, 10)
+
+		err = audit.SetChanges(map[string]any{"merged_user_id": duplicate.ID, "merged_email": duplicate.Email}, nil)
+		if err != nil {
+			return err
+		}
+
+		err = insertAuditEntry(ctx, tx, audit)
+		if err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
@@ -10,15 +10,53 @@ var (
 )
 
 type Models struct {
-	Pools         PoolModel
-	Users         UserModel
-	Groups        GroupModel
-	Subscriptions SubscriptionModel
+	Pools            PoolModel
+	Users            UserModel
+	Groups           GroupModel
+	Subscriptions    SubscriptionModel
+	Permissions      PermissionModel
+	Schedules        ScheduleModel
+	Tokens           TokenModel
+	Lockouts         LockoutModel
+	Roles            RoleModel
+	PoolTypes        ReferenceModel
+	Categories       ReferenceModel
+	TwoFactor        TwoFactorModel
+	Identities       IdentityModel
+	APIKeys          APIKeyModel
+	Sessions         SessionModel
+	Audit            AuditModel
+	TrainingSessions TrainingSessionModel
+	Eligibility      EligibilityModel
+	Assignments      TrainerAssignmentModel
+	TimeOff          TimeOffModel
+	Availability     AvailabilityModel
+	Qualifications   QualificationModel
+	Calendar         CalendarModel
 }
 
 func NewModels(db *sql.DB) Models {
 	return Models{Pools: PoolModel{DB: db},
-		Users:         UserModel{DB: db},
-		Groups:        GroupModel{DB: db},
-		Subscriptions: SubscriptionModel{DB: db}}
+		Users:            UserModel{DB: db},
+		Groups:           GroupModel{DB: db},
+		Subscriptions:    SubscriptionModel{DB: db},
+		Permissions:      PermissionModel{DB: db},
+		Schedules:        ScheduleModel{DB: db},
+		Tokens:           TokenModel{DB: db},
+		Lockouts:         LockoutModel{DB: db},
+		Roles:            RoleModel{ReferenceModel{DB: db, table: "roles", protected: []string{RoleTrainer, RoleClient, RoleAdmin}}},
+		PoolTypes:        ReferenceModel{DB: db, table: "pool_types"},
+		Categories:       ReferenceModel{DB: db, table: "group_category"},
+		TwoFactor:        TwoFactorModel{DB: db},
+		Identities:       IdentityModel{DB: db},
+		APIKeys:          APIKeyModel{DB: db},
+		Sessions:         SessionModel{DB: db},
+		Audit:            AuditModel{DB: db},
+		TrainingSessions: TrainingSessionModel{DB: db},
+		Eligibility:      EligibilityModel{DB: db},
+		Assignments:      TrainerAssignmentModel{DB: db},
+		TimeOff:          TimeOffModel{DB: db},
+		Availability:     AvailabilityModel{DB: db},
+		Qualifications:   QualificationModel{DB: db},
+		Calendar:         CalendarModel{DB: db}}
 }
@@ -0,0 +1,236 @@
+package data
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"math"
+	"strings"
+	"unicode"
+
+	"github.com/obrikash/swimming_pool/internal/breached"
+	"github.com/obrikash/swimming_pool/internal/validator"
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+)
+
+var ErrInvalidPasswordHash = errors.New("invalid password hash")
+
+// Argon2Params are the cost parameters new password hashes are created
+// with. Memory is in KiB.
+type Argon2Params struct {
+	Memory      uint32
+	Iterations  uint32
+	Parallelism uint8
+	SaltLength  uint32
+	KeyLength   uint32
+}
+
+// PasswordHashing holds the parameters used by password.Set. It is set once
+// at startup from the command line flags. The defaults follow the OWASP
+// recommendation for Argon2id and are cheap enough for small VMs.
+var PasswordHashing = Argon2Params{
+	Memory:      19 * 1024,
+	Iterations:  2,
+	Parallelism: 1,
+	SaltLength:  16,
+	KeyLength:   32,
+}
+
+func (p *password) Set(plaintextPassword string) error {
+	params := PasswordHashing
+
+	salt := make([]byte, params.SaltLength)
+	_, err := rand.Read(salt)
+	if err != nil {
+		return err
+	}
+
+	key := argon2.IDKey([]byte(plaintextPassword), salt, params.Iterations, params.Memory, params.Parallelism, params.KeyLength)
+
+	// the hash is kept in the PHC string format, so the parameters can be
+	// changed later without breaking existing hashes
+	hash := fmt.Sprintf("$argon2id$v=%d$m=%d,t=%d,p=%d$%s$%s", argon2.Version, params.Memory, params.Iterations,
+		params.Parallelism, base64.RawStdEncoding.EncodeToString(salt), base64.RawStdEncoding.EncodeToString(key))
+
+	p.plaintext = &plaintextPassword
+	p.hash = []byte(hash)
+
+	return nil
+}
+
+// Matches checks the plaintext against the hash. Hashes created with bcrypt
+// before the switch to Argon2id are still accepted.
+func (p *password) Matches(plaintextPassword string) (bool, error) {
+	if isBcryptHash(p.hash) {
+		err := bcrypt.CompareHashAndPassword(p.hash, []byte(plaintextPassword))
+		if err != nil {
+			switch {
+			case errors.Is(err, bcrypt.ErrMismatchedHashAndPassword):
+				return false, nil
+			default:
+				return false, err
+			}
+		}
+		return true, nil
+	}
+
+	params, salt, key, err := decodeArgon2Hash(p.hash)
+	if err != nil {
+		return false, err
+	}
+
+	otherKey := argon2.IDKey([]byte(plaintextPassword), salt, params.Iterations, params.Memory, params.Parallelism, params.KeyLength)
+
+	return subtle.ConstantTimeCompare(key, otherKey) == 1, nil
+}
+
+// NeedsRehash reports whether the hash was made with bcrypt or with other
+// parameters than the current ones. Such hashes are replaced on the next
+// successful login, when the plaintext is at hand.
+func (p *password) NeedsRehash() bool {
+	if isBcryptHash(p.hash) {
+		return true
+	}
+
+	params, _, _, err := decodeArgon2Hash(p.hash)
+	if err != nil {
+		return true
+	}
+
+	return params != PasswordHashing
+}
+
+func isBcryptHash(hash []byte) bool {
+	return bytes.HasPrefix(hash, []byte("$2a$")) || bytes.HasPrefix(hash, []byte("$2b$")) || bytes.HasPrefix(hash, []byte("$2y$"))
+}
+
+func decodeArgon2Hash(hash []byte) (Argon2Params, []byte, []byte, error) {
+	var params Argon2Params
+
+	parts := strings.Split(string(hash), "$")
+	if len(parts) != 6 || parts[1] != "argon2id" {
+		return params, nil, nil, ErrInvalidPasswordHash
+	}
+
+	var version int
+	_, err := fmt.Sscanf(parts[2], "v=%d", &version)
+	if err != nil || version != argon2.Version {
+		return params, nil, nil, ErrInvalidPasswordHash
+	}
+
+	_, err = fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &params.Memory, &params.Iterations, &params.Parallelism)
+	if err != nil {
+		return params, nil, nil, ErrInvalidPasswordHash
+	}
+
+	salt, err := base64.RawStdEncoding.DecodeString(parts[4])
+	if err != nil {
+		return params, nil, nil, ErrInvalidPasswordHash
+	}
+
+	key, err := base64.RawStdEncoding.DecodeString(parts[5])
+	if err != nil {
+		return params, nil, nil, ErrInvalidPasswordHash
+	}
+
+	params.SaltLength = uint32(len(salt))
+	params.KeyLength = uint32(len(key))
+
+	return params, salt, key, nil
+}
+
+// PasswordPolicy describes what new passwords must satisfy on top of the
+// length limits.
+type PasswordPolicy struct {
+	// MinEntropy is the estimated strength in bits a password needs.
+	MinEntropy float64
+	// Breached is the list of leaked passwords that are refused. A nil
+	// list disables the check.
+	Breached *breached.List
+}
+
+// PasswordRules is the policy applied whenever a password is set. Like
+// PasswordHashing it is configured once at startup.
+var PasswordRules = PasswordPolicy{MinEntropy: 40}
+
+// ValidatePasswordPolicy checks a new password against PasswordRules. The
+// email and name of the user are refused as part of their password, since
+// they are the first things an attacker tries.
+func ValidatePasswordPolicy(v *validator.Validator, password, email, fullName string) {
+	lower := strings.ToLower(password)
+
+	var personal []string
+
+	if local, _, ok := strings.Cut(strings.ToLower(email), "@"); ok {
+		personal = append(personal, local)
+	}
+
+	personal = append(personal, strings.Fields(strings.ToLower(fullName))...)
+
+	for _, word := range personal {
+		if len([]rune(word)) >= 3 && strings.Contains(lower, word) {
+			v.AddError("password", "must not contain your email address or name")
+			break
+		}
+	}
+
+	v.Check(PasswordEntropy(password) >= PasswordRules.MinEntropy, "password",
+		"is too weak, use a longer password with a mix of letters, digits and symbols")
+
+	v.Check(!PasswordRules.Breached.Contains(password), "password",
+		"has appeared in a data breach and must not be used")
+}
+
+// PasswordEntropy estimates the strength of a password in bits from the
+// character classes it uses. Repeated characters and runs like "abc" or
+// "123" add only half as much as other characters.
+func PasswordEntropy(password string) float64 {
+	var lower, upper, digit, symbol, other bool
+
+	for _, r := range password {
+		switch {
+		case r >= 'a' && r <= 'z':
+			lower = true
+		case r >= 'A' && r <= 'Z':
+			upper = true
+		case r >= '0' && r <= '9':
+			digit = true
+		case r < unicode.MaxASCII && unicode.IsPrint(r):
+			symbol = true
+		default:
+			other = true
+		}
+	}
+
+	pool := 0
+	for _, class := range []struct {
+		used bool
+		size int
+	}{{lower, 26}, {upper, 26}, {digit, 10}, {symbol, 33}, {other, 66}} {
+		if class.used {
+			pool += class.size
+		}
+	}
+
+	if pool == 0 {
+		return 0
+	}
+
+	length := 0.0
+	var prev rune
+
+	for i, r := range []rune(password) {
+		if i > 0 && (r == prev || r == prev+1 || r == prev-1) {
+			length += 0.5
+		} else {
+			length++
+		}
+		prev = r
+	}
+
+	return length * math.Log2(float64(pool))
+}
@@ -0,0 +1,54 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"slices"
+	"time"
+)
+
+type Permissions []string
+
+func (p Permissions) Include(code string) bool {
+	return slices.Contains(p, code)
+}
+
+type PermissionModel struct {
+	DB *sql.DB
+}
+
+func (pm PermissionModel) GetAllForUser(userID int64) (Permissions, error) {
+	query := `SELECT p.code FROM permissions p
+	JOIN roles_permissions rp ON rp.permission_id = p.id
+	JOIN users u ON u.role_id = rp.role_id
+	WHERE u.id = $1`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := pm.DB.QueryContext(ctx, query, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	var permissions Permissions
+
+	for rows.Next() {
+		var permission string
+
+		err := rows.Scan(&permission)
+		if err != nil {
+			return nil, err
+		}
+
+		permissions = append(permissions, permission)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return permissions, nil
+}
@@ -4,14 +4,32 @@ import (
 	"context"
 	"database/sql"
 	"errors"
+	"strconv"
 	"time"
 )
 
 type Pool struct {
-	ID       int64  `json:"id"`
-	Name     string `json:"name"`
-	Address  string `json:"address"`
-	PoolType string `json:"type"`
+	ID           int64  `json:"id"`
+	Name         string `json:"name"`
+	Address      string `json:"address"`
+	PoolTypeCode string `json:"type_code"`
+	PoolType     string `json:"type"`
+	Lanes        int    `json:"lanes"`
+	TimeZone     string `json:"time_zone"`
+
+	Image     string `json:"image_url"`
+	Thumbnail string `json:"thumbnail_url"`
+}
+
+// Location returns the time zone of the pool. Schedules are in the local
+// time of the pool.
+func (p *Pool) Location() *time.Location {
+	loc, err := time.LoadLocation(p.TimeZone)
+	if err != nil {
+		return DefaultLocation
+	}
+
+	return loc
 }
 
 type PoolModel struct {
@@ -23,14 +41,15 @@ func (pm PoolModel) Get(id int64) (*Pool, error) {
 		return nil, ErrRecordNotFound
 	}
 
-	query := `SELECT p.id, p.name, p.address, p.type FROM pools p WHERE p.id = $1`
+	query := `SELECT p.id, p.name, p.address, pt.code, pt.name, p.lanes, p.time_zone, p.image, p.thumbnail
+	FROM pools p JOIN pool_types pt ON p.type_id = pt.id WHERE p.id = $1`
 
 	pool := &Pool{}
 
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
 
-	err := pm.DB.QueryRowContext(ctx, query, id).Scan(&pool.ID, &pool.Name, &pool.Address, &pool.PoolType)
+	err := pm.DB.QueryRowContext(ctx, query, id).Scan(&pool.ID, &pool.Name, &pool.Address, &pool.PoolTypeCode, &pool.PoolType, &pool.Lanes, &pool.TimeZone, &pool.Image, &pool.Thumbnail)
 	if err != nil {
 		switch {
 		case errors.Is(err, sql.ErrNoRows):
@@ -43,7 +62,8 @@ func (pm PoolModel) Get(id int64) (*Pool, error) {
 }
 
 func (pm PoolModel) GetAll() ([]*Pool, error) {
-	query := "SELECT p.id, p.name, p.address, p.type FROM pools p ORDER BY name ASC"
+	query := `SELECT p.id, p.name, p.address, pt.code, pt.name, p.lanes, p.time_zone, p.image, p.thumbnail
+	FROM pools p JOIN pool_types pt ON p.type_id = pt.id ORDER BY p.name ASC`
 
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
@@ -60,7 +80,7 @@ func (pm PoolModel) GetAll() ([]*Pool, error) {
 	for rows.Next() {
 		var pool Pool
 
-		err := rows.Scan(&pool.ID, &pool.Name, &pool.Address, &pool.PoolType)
+		err := rows.Scan(&pool.ID, &pool.Name, &pool.Address, &pool.PoolTypeCode, &pool.PoolType, &pool.Lanes, &pool.TimeZone, &pool.Image, &pool.Thumbnail)
 		if err != nil {
 			return nil, err
 		}
@@ -74,17 +94,17 @@ func (pm PoolModel) GetAll() ([]*Pool, error) {
 }
 
 func (pm PoolModel) MaxProfit() (*Pool, float64, error) {
-	query := `SELECT p.id AS pool_id, p.name AS pool_name, p.address, p.type, SUM(sub.price) AS total_revenue 
+	query := `SELECT p.id AS pool_id, p.name AS pool_name, p.address, pt.code, pt.name, p.lanes, p.time_zone, p.image, p.thumbnail, SUM(sub.price) AS total_revenue 
 	FROM user_subscriptions us JOIN user_groups ug ON us.user_id = ug.user_id JOIN training_groups tg ON ug.group_id = tg.id 
-	JOIN trainers tr ON tg.trainer_id = tr.id JOIN pools p ON tr.pool_id = p.id 
-	JOIN subscriptions sub ON us.subscription_id = sub.id GROUP BY p.id, p.name, p.address, p.type ORDER BY total_revenue DESC LIMIT 1;`
+	JOIN pools p ON tg.pool_id = p.id JOIN pool_types pt ON p.type_id = pt.id 
+	JOIN subscriptions sub ON us.subscription_id = sub.id GROUP BY p.id, p.name, p.address, pt.code, pt.name, p.lanes, p.time_zone, p.image, p.thumbnail ORDER BY total_revenue DESC LIMIT 1;`
 
 	pool := &Pool{}
 	var profit float64
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
 
-	err := pm.DB.QueryRowContext(ctx, query).Scan(&pool.ID, &pool.Name, &pool.Address, &pool.PoolType, &profit)
+	err := pm.DB.QueryRowContext(ctx, query).Scan(&pool.ID, &pool.Name, &pool.Address, &pool.PoolTypeCode, &pool.PoolType, &pool.Lanes, &pool.TimeZone, &pool.Image, &pool.Thumbnail, &profit)
 	if err != nil {
 		switch {
 		case errors.Is(err, sql.ErrNoRows):
@@ -95,3 +115,97 @@ func (pm PoolModel) MaxProfit() (*Pool, float64, error) {
 	}
 	return pool, profit, nil
 }
+
+func (pm PoolModel) UpdateImage(pool *Pool) error {
+	query := `UPDATE pools SET image = $1, thumbnail = $2 WHERE id = $3`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	result, err := pm.DB.ExecContext(ctx, query, pool.Image, pool.Thumbnail, pool.ID)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+
+	return nil
+}
+
+// Update sets the number of lanes and the time zone of the pool. Fewer lanes
+// than the schedules of the pool take at once are refused. When the zone
+// changes, upcoming sessions keep their local time of day.
+func (pm PoolModel) Update(pool *Pool, audit *AuditEntry) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := pm.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var lanes int
+	var timeZone string
+
+	err = tx.QueryRowContext(ctx, `SELECT lanes, time_zone FROM pools WHERE id = $1 FOR UPDATE`, pool.ID).Scan(&lanes, &timeZone)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrRecordNotFound
+		default:
+			return err
+		}
+	}
+
+	for day := 1; day <= 7; day++ {
+		uses, err := poolLaneUses(ctx, tx, pool.ID, day)
+		if err != nil {
+			return err
+		}
+
+		if PeakLanes(uses, 0, MinutesPerDay) > pool.Lanes {
+			return ErrLanesInUse
+		}
+	}
+
+	_, err = tx.ExecContext(ctx, `UPDATE pools SET lanes = $1, time_zone = $2 WHERE id = $3`, pool.Lanes, pool.TimeZone, pool.ID)
+	if err != nil {
+		return err
+	}
+
+	if timeZone != pool.TimeZone {
+		query := `UPDATE training_sessions s SET starts_at = (s.starts_at AT TIME ZONE $1) AT TIME ZONE $2
+		FROM training_groups g
+		WHERE g.id = s.group_id AND g.pool_id = $3 AND s.starts_at > NOW()`
+
+		_, err = tx.ExecContext(ctx, query, timeZone, pool.TimeZone, pool.ID)
+		if err != nil {
+			return err
+		}
+	}
+
+	if audit != nil {
+		audit.TargetID = strconv.FormatInt(pool.ID, 10)
+
+		err = audit.SetChanges(map[string]any{"lanes": lanes, "time_zone": timeZone},
+			map[string]any{"lanes": pool.Lanes, "time_zone": pool.TimeZone})
+		if err != nil {
+			return err
+		}
+
+		err = insertAuditEntry(ctx, tx, audit)
+		if err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
@@ -0,0 +1,278 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"regexp"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/obrikash/swimming_pool/internal/validator"
+)
+
+var (
+	ErrDuplicateCode      = errors.New("duplicate code")
+	ErrDuplicateName      = errors.New("duplicate name")
+	ErrReferenceInUse     = errors.New("the record is still in use")
+	ErrReferenceProtected = errors.New("the record is used by the application and can't be deleted")
+)
+
+var (
+	CodeRX     = regexp.MustCompile(`^[a-z][a-z0-9_]*$`)
+	LanguageRX = regexp.MustCompile(`^[a-z]{2}$`)
+)
+
+// Reference is an entry of one of the lookup tables admins manage: pool
+// types, group categories and roles. The code never changes once created
+// and is what the application refers to, the name and its translations are
+// only for display.
+type Reference struct {
+	ID           int64             `json:"id"`
+	Code         string            `json:"code"`
+	Name         string            `json:"name"`
+	Translations map[string]string `json:"translations"`
+}
+
+func ValidateReference(v *validator.Validator, ref *Reference) {
+	v.Check(ref.Code != "", "code", "must be provided")
+	v.Check(len(ref.Code) <= 32, "code", "must not be more than 32 bytes long")
+	v.Check(validator.Matches(ref.Code, CodeRX), "code", "must contain only lowercase latin letters, digits and underscores and start with a letter")
+
+	v.Check(strings.TrimSpace(ref.Name) != "", "name", "must be provided")
+	v.Check(len(ref.Name) <= 100, "name", "must not be more than 100 bytes long")
+
+	for lang, name := range ref.Translations {
+		v.Check(validator.Matches(lang, LanguageRX), "translations", "must be keyed by two-letter language codes")
+		v.Check(strings.TrimSpace(name) != "", "translations", "must not contain empty names")
+		v.Check(len(name) <= 100, "translations", "must not contain names more than 100 bytes long")
+	}
+}
+
+// ReferenceModel works on any of the lookup tables, they share the same
+// layout. Codes listed as protected are referenced from the code and can't
+// be deleted.
+type ReferenceModel struct {
+	DB        *sql.DB
+	table     string
+	protected []string
+}
+
+func (rm ReferenceModel) Get(id int64) (*Reference, error) {
+	if id < 1 {
+		return nil, ErrRecordNotFound
+	}
+
+	query := fmt.Sprintf(`SELECT id, code, name, translations FROM %s WHERE id = $1`, rm.table)
+
+	var ref Reference
+	var translations []byte
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := rm.DB.QueryRowContext(ctx, query, id).Scan(&ref.ID, &ref.Code, &ref.Name, &translations)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	err = json.Unmarshal(translations, &ref.Translations)
+	if err != nil {
+		return nil, err
+	}
+
+	return &ref, nil
+}
+
+func (rm ReferenceModel) GetAll() ([]*Reference, error) {
+	query := fmt.Sprintf(`SELECT id, code, name, translations FROM %s ORDER BY id`, rm.table)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := rm.DB.QueryContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	refs := []*Reference{}
+
+	for rows.Next() {
+		var ref Reference
+		var translations []byte
+
+		err := rows.Scan(&ref.ID, &ref.Code, &ref.Name, &translations)
+		if err != nil {
+			return nil, err
+		}
+
+		err = json.Unmarshal(translations, &ref.Translations)
+		if err != nil {
+			return nil, err
+		}
+
+		refs = append(refs, &ref)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return refs, nil
+}
+
+func (rm ReferenceModel) Insert(ref *Reference, audit *AuditEntry) error {
+	query := fmt.Sprintf(`INSERT INTO %s (code, name, translations) VALUES ($1, $2, $3) RETURNING id`, rm.table)
+
+	translations, err := marshalTranslations(ref.Translations)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := rm.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	err = tx.QueryRowContext(ctx, query, ref.Code, ref.Name, translations).Scan(&ref.ID)
+	if err != nil {
+		return rm.constraintError(err)
+	}
+
+	if audit != nil {
+		audit.TargetID = ref.Code
+
+		err = insertAuditEntry(ctx, tx, audit)
+		if err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// Update changes the name and the translations, the code stays as it is.
+func (rm ReferenceModel) Update(ref *Reference, audit *AuditEntry) error {
+	query := fmt.Sprintf(`UPDATE %s SET name = $1, translations = $2 WHERE id = $3`, rm.table)
+
+	translations, err := marshalTranslations(ref.Translations)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := rm.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	result, err := tx.ExecContext(ctx, query, ref.Name, translations, ref.ID)
+	if err != nil {
+		return rm.constraintError(err)
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+
+	if audit != nil {
+		audit.TargetID = ref.Code
+
+		err = insertAuditEntry(ctx, tx, audit)
+		if err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// Delete removes an entry nothing refers to any more. Entries still used by
+// pools, groups or users are refused with ErrReferenceInUse.
+func (rm ReferenceModel) Delete(ref *Reference, audit *AuditEntry) error {
+	if slices.Contains(rm.protected, ref.Code) {
+		return ErrReferenceProtected
+	}
+
+	query := fmt.Sprintf(`DELETE FROM %s WHERE id = $1`, rm.table)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := rm.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	result, err := tx.ExecContext(ctx, query, ref.ID)
+	if err != nil {
+		switch {
+		case strings.Contains(err.Error(), "violates foreign key constraint"):
+			return ErrReferenceInUse
+		default:
+			return err
+		}
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+
+	if audit != nil {
+		audit.TargetID = ref.Code
+
+		err = insertAuditEntry(ctx, tx, audit)
+		if err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+func (rm ReferenceModel) constraintError(err error) error {
+	switch {
+	case err.Error() == fmt.Sprintf(`pq: duplicate key value violates unique constraint "%s_code_key"`, rm.table):
+		return ErrDuplicateCode
+	case err.Error() == fmt.Sprintf(`pq: duplicate key value violates unique constraint "%s_name_key"`, rm.table):
+		return ErrDuplicateName
+	default:
+		return err
+	}
+}
+
+func marshalTranslations(translations map[string]string) ([]byte, error) {
+	if translations == nil {
+		translations = map[string]string{}
+	}
+
+	return json.Marshal(translations)
+}
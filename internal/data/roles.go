@@ -0,0 +1,31 @@
+package data
+
+// Codes of the roles the application relies on. Admins may add further roles
+// but can't delete these.
+const (
+	RoleTrainer = "trainer"
+	RoleClient  = "client"
+	RoleAdmin   = "admin"
+)
+
+type Role struct {
+	Reference
+}
+
+type RoleModel struct {
+	ReferenceModel
+}
+
+func (rm RoleModel) Get(id uint8) (*Role, error) {
+	ref, err := rm.ReferenceModel.Get(int64(id))
+	if err != nil {
+		return nil, err
+	}
+
+	return &Role{Reference: *ref}, nil
+}
+
+// IsStaff reports whether the role belongs to the pool staff rather than to clients.
+func (r *Role) IsStaff() bool {
+	return r.Code == RoleTrainer || r.Code == RoleAdmin
+}
@@ -0,0 +1,278 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"regexp"
+	"time"
+
+	"github.com/obrikash/swimming_pool/internal/validator"
+)
+
+var (
+	TimeOfDayRX = regexp.MustCompile(`^([01][0-9]|2[0-3]):[0-5][0-9]$`)
+)
+
+// DefaultSessionMinutes is the duration of schedules created without one.
+const DefaultSessionMinutes = 60
+
+type Schedule struct {
+	ID              int64  `json:"id"`
+	GroupID         int64  `json:"group_id"`
+	DayOfWeek       int    `json:"day_of_week"`
+	TimeOfDay       string `json:"time_of_day"`
+	DurationMinutes int    `json:"duration_minutes"`
+	Lanes           int    `json:"lanes"`
+}
+
+// LaneUse returns the span of the day the schedule takes its lanes for.
+func (s *Schedule) LaneUse() LaneUse {
+	start := minutesOfDay(s.TimeOfDay)
+	return LaneUse{Start: start, End: start + s.DurationMinutes, Lanes: s.Lanes}
+}
+
+// minutesOfDay converts a time in HH:MM format to minutes since midnight.
+func minutesOfDay(timeOfDay string) int {
+	t, err := time.Parse("15:04", timeOfDay)
+	if err != nil {
+		return 0
+	}
+
+	return t.Hour()*60 + t.Minute()
+}
+
+type ScheduleModel struct {
+	DB *sql.DB
+}
+
+func ValidateSchedule(v *validator.Validator, schedule *Schedule) {
+	v.Check(schedule.DayOfWeek >= 1 && schedule.DayOfWeek <= 7, "day_of_week", "must be between 1 and 7")
+	v.Check(schedule.TimeOfDay != "", "time_of_day", "must be provided")
+	v.Check(validator.Matches(schedule.TimeOfDay, TimeOfDayRX), "time_of_day", "must be in HH:MM format")
+	v.Check(schedule.DurationMinutes >= 15 && schedule.DurationMinutes <= 240, "duration_minutes", "must be between 15 and 240")
+	v.Check(schedule.DurationMinutes%5 == 0, "duration_minutes", "must be a multiple of 5")
+	v.Check(schedule.Lanes >= 1, "lanes", "must be at least 1")
+
+	if v.Valid() {
+		v.Check(schedule.LaneUse().End <= MinutesPerDay, "duration_minutes", "must end by midnight")
+	}
+}
+
+func (sm ScheduleModel) Get(id int64) (*Schedule, error) {
+	if id < 1 {
+		return nil, ErrRecordNotFound
+	}
+
+	query := `SELECT id, group_id, day_of_week, to_char(time_of_day, 'HH24:MI'), duration_minutes, lanes FROM schedules WHERE id = $1`
+
+	var schedule Schedule
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := sm.DB.QueryRowContext(ctx, query, id).Scan(&schedule.ID, &schedule.GroupID, &schedule.DayOfWeek, &schedule.TimeOfDay,
+		&schedule.DurationMinutes, &schedule.Lanes)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	return &schedule, nil
+}
+
+func (sm ScheduleModel) GetForGroup(groupID int64) ([]*Schedule, error) {
+	query := `SELECT id, group_id, day_of_week, to_char(time_of_day, 'HH24:MI'), duration_minutes, lanes FROM schedules
+	WHERE group_id = $1 ORDER BY day_of_week, time_of_day`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := sm.DB.QueryContext(ctx, query, groupID)
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	schedules := []*Schedule{}
+
+	for rows.Next() {
+		var schedule Schedule
+
+		err := rows.Scan(&schedule.ID, &schedule.GroupID, &schedule.DayOfWeek, &schedule.TimeOfDay,
+			&schedule.DurationMinutes, &schedule.Lanes)
+		if err != nil {
+			return nil, err
+		}
+
+		schedules = append(schedules, &schedule)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return schedules, nil
+}
+
+// GetForTrainer returns the schedules of the active groups run by the trainer.
+func (sm ScheduleModel) GetForTrainer(trainerID int64) ([]*Schedule, error) {
+	query := `SELECT s.id, s.group_id, s.day_of_week, to_char(s.time_of_day, 'HH24:MI'), s.duration_minutes, s.lanes FROM schedules s
+	JOIN training_groups g ON g.id = s.group_id
+	WHERE g.trainer_id = $1 AND g.archived_at IS NULL
+	ORDER BY s.day_of_week, s.time_of_day`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := sm.DB.QueryContext(ctx, query, trainerID)
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	schedules := []*Schedule{}
+
+	for rows.Next() {
+		var schedule Schedule
+
+		err := rows.Scan(&schedule.ID, &schedule.GroupID, &schedule.DayOfWeek, &schedule.TimeOfDay,
+			&schedule.DurationMinutes, &schedule.Lanes)
+		if err != nil {
+			return nil, err
+		}
+
+		schedules = append(schedules, &schedule)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return schedules, nil
+}
+
+// Insert adds the schedule unless the pool of the group would need more
+// lanes at once than it has. The pool is locked while checking, so two
+// schedules can't take the last free lane at the same time.
+func (sm ScheduleModel) Insert(schedule *Schedule) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := sm.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var poolID int64
+	var lanes int
+
+	query := `SELECT p.id, p.lanes FROM pools p JOIN training_groups g ON g.pool_id = p.id
+	WHERE g.id = $1 FOR UPDATE OF p`
+
+	err = tx.QueryRowContext(ctx, query, schedule.GroupID).Scan(&poolID, &lanes)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrRecordNotFound
+		default:
+			return err
+		}
+	}
+
+	uses, err := poolLaneUses(ctx, tx, poolID, schedule.DayOfWeek)
+	if err != nil {
+		return err
+	}
+
+	use := schedule.LaneUse()
+
+	if PeakLanes(uses, use.Start, use.End)+use.Lanes > lanes {
+		return ErrPoolFull
+	}
+
+	query = `INSERT INTO schedules (group_id, day_of_week, time_of_day, duration_minutes, lanes)
+	VALUES ($1, $2, $3, $4, $5) RETURNING id`
+
+	args := []any{schedule.GroupID, schedule.DayOfWeek, schedule.TimeOfDay, schedule.DurationMinutes, schedule.Lanes}
+
+	err = tx.QueryRowContext(ctx, query, args...).Scan(&schedule.ID)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// poolLaneUses returns the lanes taken by the schedules of the active groups
+// of the pool on the day of the week.
+func poolLaneUses(ctx context.Context, tx *sql.Tx, poolID int64, dayOfWeek int) ([]LaneUse, error) {
+	query := `SELECT to_char(s.time_of_day, 'HH24:MI'), s.duration_minutes, s.lanes FROM schedules s
+	JOIN training_groups g ON g.id = s.group_id
+	WHERE g.pool_id = $1 AND g.archived_at IS NULL AND s.day_of_week = $2`
+
+	rows, err := tx.QueryContext(ctx, query, poolID, dayOfWeek)
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	uses := []LaneUse{}
+
+	for rows.Next() {
+		var schedule Schedule
+
+		err := rows.Scan(&schedule.TimeOfDay, &schedule.DurationMinutes, &schedule.Lanes)
+		if err != nil {
+			return nil, err
+		}
+
+		uses = append(uses, schedule.LaneUse())
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return uses, nil
+}
+
+func (sm ScheduleModel) Delete(id int64) error {
+	if id < 1 {
+		return ErrRecordNotFound
+	}
+
+	// sessions already generated from the schedule are called off as well
+	query := `WITH cancelled AS (
+		UPDATE training_sessions SET cancelled_at = NOW()
+		WHERE schedule_id = $1 AND starts_at > NOW() AND cancelled_at IS NULL
+	)
+	DELETE FROM schedules WHERE id = $1`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	result, err := sm.DB.ExecContext(ctx, query, id)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+
+	return nil
+}
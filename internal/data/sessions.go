@@ -0,0 +1,181 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"strings"
+	"time"
+)
+
+type Session struct {
+	ID         int64     `json:"id"`
+	UserID     int64     `json:"user_id"`
+	CreatedAt  time.Time `json:"created_at"`
+	LastSeenAt time.Time `json:"last_seen_at"`
+	Expiry     time.Time `json:"expiry"`
+	IP         string    `json:"ip"`
+	UserAgent  string    `json:"user_agent"`
+	Device     string    `json:"device"`
+	Current    bool      `json:"current"`
+}
+
+// DescribeDevice turns a User-Agent header into a short human readable
+// description such as "Chrome on Android". It only knows the common browsers
+// and platforms, anything else is reported as unknown.
+func DescribeDevice(userAgent string) string {
+	ua := strings.ToLower(userAgent)
+
+	browser := "Unknown browser"
+	for _, b := range []struct{ token, name string }{
+		{"yabrowser", "Yandex Browser"},
+		{"edg/", "Edge"},
+		{"opr/", "Opera"},
+		{"firefox/", "Firefox"},
+		{"chrome/", "Chrome"},
+		{"safari/", "Safari"},
+		{"okhttp", "Android app"},
+		{"cfnetwork", "iOS app"},
+		{"curl/", "curl"},
+	} {
+		if strings.Contains(ua, b.token) {
+			browser = b.name
+			break
+		}
+	}
+
+	platform := "unknown device"
+	for _, p := range []struct{ token, name string }{
+		{"android", "Android"},
+		{"iphone", "iPhone"},
+		{"ipad", "iPad"},
+		{"windows", "Windows"},
+		{"mac os", "macOS"},
+		{"linux", "Linux"},
+	} {
+		if strings.Contains(ua, p.token) {
+			platform = p.name
+			break
+		}
+	}
+
+	return browser + " on " + platform
+}
+
+type SessionModel struct {
+	DB *sql.DB
+}
+
+func (sm SessionModel) Insert(session *Session) error {
+	query := `INSERT INTO sessions (user_id, expiry, ip, user_agent, device) VALUES ($1, $2, $3, $4, $5)
+	RETURNING id, created_at, last_seen_at`
+
+	args := []any{session.UserID, session.Expiry, session.IP, session.UserAgent, session.Device}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	return sm.DB.QueryRowContext(ctx, query, args...).Scan(&session.ID, &session.CreatedAt, &session.LastSeenAt)
+}
+
+// Touch checks that the session is still active and records that it was
+// seen from ip. The timestamp is only moved forward once a minute so that
+// not every request causes a write.
+func (sm SessionModel) Touch(id, userID int64, ip string) error {
+	query := `SELECT last_seen_at, ip FROM sessions
+	WHERE id = $1 AND user_id = $2 AND revoked_at IS NULL AND expiry > NOW()`
+
+	var lastSeenAt time.Time
+	var lastIP string
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := sm.DB.QueryRowContext(ctx, query, id, userID).Scan(&lastSeenAt, &lastIP)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrRecordNotFound
+		default:
+			return err
+		}
+	}
+
+	if time.Since(lastSeenAt) < time.Minute && lastIP == ip {
+		return nil
+	}
+
+	_, err = sm.DB.ExecContext(ctx, `UPDATE sessions SET last_seen_at = NOW(), ip = $1 WHERE id = $2`, ip, id)
+	return err
+}
+
+func (sm SessionModel) GetAllForUser(userID int64) ([]*Session, error) {
+	query := `SELECT id, user_id, created_at, last_seen_at, expiry, ip, user_agent, device FROM sessions
+	WHERE user_id = $1 AND revoked_at IS NULL AND expiry > NOW()
+	ORDER BY last_seen_at DESC`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := sm.DB.QueryContext(ctx, query, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	sessions := []*Session{}
+
+	for rows.Next() {
+		var session Session
+
+		err := rows.Scan(&session.ID, &session.UserID, &session.CreatedAt, &session.LastSeenAt, &session.Expiry,
+			&session.IP, &session.UserAgent, &session.Device)
+		if err != nil {
+			return nil, err
+		}
+
+		sessions = append(sessions, &session)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return sessions, nil
+}
+
+func (sm SessionModel) Revoke(id, userID int64) error {
+	query := `UPDATE sessions SET revoked_at = NOW() WHERE id = $1 AND user_id = $2 AND revoked_at IS NULL`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	result, err := sm.DB.ExecContext(ctx, query, id, userID)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+
+	return nil
+}
+
+// RevokeAllForUser revokes every session of the user except the one with
+// exceptID, which may be 0 to revoke them all.
+func (sm SessionModel) RevokeAllForUser(userID, exceptID int64) error {
+	query := `UPDATE sessions SET revoked_at = NOW() WHERE user_id = $1 AND id <> $2 AND revoked_at IS NULL`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	_, err := sm.DB.ExecContext(ctx, query, userID, exceptID)
+	return err
+}
@@ -13,10 +13,25 @@ type Subscription struct {
 	Price         float64 `json:"price"`
 }
 
+// activeSubscription is true when the user $1 holds a subscription at the
+// time $2.
+const activeSubscription = `EXISTS (SELECT 1 FROM user_subscriptions WHERE user_id = $1 AND date_start <= $2 AND date_end > $2)`
+
 type SubscriptionModel struct {
 	DB *sql.DB
 }
 
+// HasActive reports whether the user holds a subscription at the given time.
+func (sm SubscriptionModel) HasActive(userID int64, at time.Time) (bool, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var active bool
+
+	err := sm.DB.QueryRowContext(ctx, `SELECT `+activeSubscription, userID, at).Scan(&active)
+	return active, err
+}
+
 func (sm SubscriptionModel) GetAll() ([]*Subscription, error) {
 	query := `SELECT id, name, visits_per_week, price FROM subscriptions`
 
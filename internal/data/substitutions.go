@@ -0,0 +1,306 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"sort"
+	"strconv"
+	"time"
+)
+
+var (
+	ErrSessionStarted = errors.New("the session has already started")
+)
+
+// SubstituteCandidate is a trainer who could take over a session. Only
+// trainers assigned to the pool of the session on its day who are neither
+// off nor busy at the time are candidates; the flags say how well they fit.
+type SubstituteCandidate struct {
+	TrainerID int64  `json:"trainer_id"`
+	UserID    int64  `json:"user_id"`
+	FullName  string `json:"full_name"`
+	Qualified bool   `json:"qualified"`
+	Available bool   `json:"available"`
+	WeekLoad  int    `json:"week_load"`
+	Score     int    `json:"score"`
+}
+
+// rank weighs being qualified for the category over the declared weekly
+// hours.
+func (c *SubstituteCandidate) rank() {
+	c.Score = 0
+
+	if c.Qualified {
+		c.Score += 2
+	}
+
+	if c.Available {
+		c.Score++
+	}
+}
+
+// SuggestSubstitutes returns the trainers who could take over the session,
+// best first. Among equally suited trainers the ones with fewer sessions in
+// the week of the session come first.
+func (tm TrainingSessionModel) SuggestSubstitutes(sessionID int64) ([]*SubstituteCandidate, error) {
+	query := `WITH target AS (
+		SELECT s.id, s.starts_at, s.starts_at + s.duration_minutes * interval '1 minute' AS ends_at, s.duration_minutes,
+		l.local::date AS day, EXTRACT(ISODOW FROM l.local)::int AS dow,
+		l.local::time AS tod, date_trunc('week', l.local) AT TIME ZONE p.time_zone AS week,
+		g.pool_id, g.category_id, g.trainer_id
+		FROM training_sessions s JOIN training_groups g ON g.id = s.group_id
+		JOIN pools p ON p.id = g.pool_id
+		CROSS JOIN LATERAL (SELECT s.starts_at AT TIME ZONE p.time_zone AS local) l
+		WHERE s.id = $1
+	)
+	SELECT t.id, u.id, u.full_name,
+	EXISTS (SELECT 1 FROM trainer_qualifications q WHERE q.trainer_id = t.id AND q.category_id = x.category_id),
+	NOT EXISTS (SELECT 1 FROM trainer_availability w WHERE w.trainer_id = t.id)
+		OR EXISTS (SELECT 1 FROM trainer_availability w WHERE w.trainer_id = t.id AND w.day_of_week = x.dow
+		AND w.starts_at <= x.tod AND w.ends_at::interval >= x.tod::interval + x.duration_minutes * interval '1 minute'),
+	(SELECT count(*) FROM training_sessions s JOIN training_groups g ON g.id = s.group_id
+		WHERE s.cancelled_at IS NULL AND COALESCE(s.substitute_trainer_id, g.trainer_id) = t.id
+		AND s.starts_at >= x.week AND s.starts_at < x.week + interval '1 week')
+	FROM target x
+	CROSS JOIN trainers t
+	JOIN users u ON u.id = t.user_id
+	JOIN roles r ON r.id = u.role_id
+	WHERE t.id <> x.trainer_id AND u.active AND r.code = 'trainer'
+	AND EXISTS (SELECT 1 FROM trainer_assignments a WHERE a.trainer_id = t.id AND a.pool_id = x.pool_id
+		AND a.starts_on <= x.day AND (a.ends_on IS NULL OR a.ends_on >= x.day)
+		AND (a.days_of_week IS NULL OR x.dow = ANY(a.days_of_week)))
+	AND NOT EXISTS (SELECT 1 FROM trainer_time_off o WHERE o.trainer_id = t.id AND o.status = 'approved'
+		AND x.day BETWEEN o.starts_on AND o.ends_on)
+	AND NOT EXISTS (SELECT 1 FROM training_sessions s JOIN training_groups g ON g.id = s.group_id
+		WHERE s.id <> x.id AND s.cancelled_at IS NULL AND COALESCE(s.substitute_trainer_id, g.trainer_id) = t.id
+		AND s.starts_at < x.ends_at AND s.starts_at + s.duration_minutes * interval '1 minute' > x.starts_at)`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := tm.DB.QueryContext(ctx, query, sessionID)
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	candidates := []*SubstituteCandidate{}
+
+	for rows.Next() {
+		var c SubstituteCandidate
+
+		err := rows.Scan(&c.TrainerID, &c.UserID, &c.FullName, &c.Qualified, &c.Available, &c.WeekLoad)
+		if err != nil {
+			return nil, err
+		}
+
+		c.rank()
+		candidates = append(candidates, &c)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	sort.SliceStable(candidates, func(i, j int) bool {
+		a, b := candidates[i], candidates[j]
+
+		if a.Score != b.Score {
+			return a.Score > b.Score
+		}
+
+		if a.WeekLoad != b.WeekLoad {
+			return a.WeekLoad < b.WeekLoad
+		}
+
+		return a.FullName < b.FullName
+	})
+
+	return candidates, nil
+}
+
+// SetSubstitute assigns the trainer as the substitute for the session, or
+// removes the substitute when trainerID is nil.
+func (tm TrainingSessionModel) SetSubstitute(session *TrainingSession, trainerID *int64, audit *AuditEntry) error {
+	if session.CancelledAt != nil {
+		return ErrSessionCancelled
+	}
+
+	if !time.Now().Before(session.StartsAt) {
+		return ErrSessionStarted
+	}
+
+	query := `UPDATE training_sessions SET substitute_trainer_id = $1,
+	substituted_at = CASE WHEN $1::int IS NULL THEN NULL ELSE NOW() END
+	WHERE id = $2 AND cancelled_at IS NULL`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := tm.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	result, err := tx.ExecContext(ctx, query, trainerID, session.ID)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return ErrSessionCancelled
+	}
+
+	if audit != nil {
+		audit.TargetID = strconv.FormatInt(session.ID, 10)
+
+		err = audit.SetChanges(map[string]any{"substitute_trainer_id": session.SubstituteTrainerID},
+			map[string]any{"substitute_trainer_id": trainerID})
+		if err != nil {
+			return err
+		}
+
+		err = insertAuditEntry(ctx, tx, audit)
+		if err != nil {
+			return err
+		}
+	}
+
+	session.SubstituteTrainerID = trainerID
+
+	return tx.Commit()
+}
+
+// TrainerPayroll counts the sessions a trainer ran in a period. Sessions
+// handed over to a substitute are paid to the substitute.
+type TrainerPayroll struct {
+	TrainerID     int64  `json:"trainer_id"`
+	FullName      string `json:"full_name"`
+	OwnSessions   int    `json:"own_sessions"`
+	Substitutions int    `json:"substitutions"`
+	HandedOver    int    `json:"handed_over"`
+	Conducted     int    `json:"conducted"`
+}
+
+// Payroll returns the sessions run by each trainer between the from and to
+// dates, both inclusive, in the local time of their pools. Cancelled
+// sessions don't count.
+func (tm TrainingSessionModel) Payroll(from, to Date) ([]*TrainerPayroll, error) {
+	query := `SELECT t.id, u.full_name,
+	count(*) FILTER (WHERE g.trainer_id = t.id AND s.substitute_trainer_id IS NULL),
+	count(*) FILTER (WHERE s.substitute_trainer_id = t.id),
+	count(*) FILTER (WHERE g.trainer_id = t.id AND s.substitute_trainer_id IS NOT NULL)
+	FROM trainers t
+	JOIN users u ON u.id = t.user_id
+	JOIN training_sessions s ON s.cancelled_at IS NULL
+	JOIN training_groups g ON g.id = s.group_id AND (g.trainer_id = t.id OR s.substitute_trainer_id = t.id)
+	JOIN pools p ON p.id = g.pool_id AND (s.starts_at AT TIME ZONE p.time_zone)::date BETWEEN $1 AND $2
+	GROUP BY t.id, u.full_name
+	ORDER BY u.full_name`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := tm.DB.QueryContext(ctx, query, from, to)
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	payroll := []*TrainerPayroll{}
+
+	for rows.Next() {
+		var p TrainerPayroll
+
+		err := rows.Scan(&p.TrainerID, &p.FullName, &p.OwnSessions, &p.Substitutions, &p.HandedOver)
+		if err != nil {
+			return nil, err
+		}
+
+		p.Conducted = p.OwnSessions + p.Substitutions
+		payroll = append(payroll, &p)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return payroll, nil
+}
+
+type QualificationModel struct {
+	DB *sql.DB
+}
+
+// GetForTrainer returns the IDs of the categories the trainer may teach.
+func (qm QualificationModel) GetForTrainer(trainerID int64) ([]int64, error) {
+	query := `SELECT category_id FROM trainer_qualifications WHERE trainer_id = $1 ORDER BY category_id`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := qm.DB.QueryContext(ctx, query, trainerID)
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	categories := []int64{}
+
+	for rows.Next() {
+		var id int64
+
+		err := rows.Scan(&id)
+		if err != nil {
+			return nil, err
+		}
+
+		categories = append(categories, id)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return categories, nil
+}
+
+func (qm QualificationModel) Replace(trainerID int64, categoryIDs []int64) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := qm.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.ExecContext(ctx, `DELETE FROM trainer_qualifications WHERE trainer_id = $1`, trainerID)
+	if err != nil {
+		return err
+	}
+
+	for _, id := range categoryIDs {
+		_, err = tx.ExecContext(ctx, `INSERT INTO trainer_qualifications (trainer_id, category_id) VALUES ($1, $2)`, trainerID, id)
+		if err != nil {
+			switch {
+			case err.Error() == `pq: insert or update on table "trainer_qualifications" violates foreign key constraint "trainer_qualifications_category_id_fkey"`:
+				return ErrRecordNotFound
+			default:
+				return err
+			}
+		}
+	}
+
+	return tx.Commit()
+}
@@ -0,0 +1,309 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"strconv"
+	"time"
+
+	"github.com/obrikash/swimming_pool/internal/validator"
+)
+
+var (
+	ErrTimeOffDecided = errors.New("the time off has already been decided")
+)
+
+const (
+	TimeOffVacation  = "vacation"
+	TimeOffSickLeave = "sick_leave"
+	TimeOffOther     = "other"
+)
+
+const (
+	TimeOffPending  = "pending"
+	TimeOffApproved = "approved"
+	TimeOffRejected = "rejected"
+)
+
+// MaxTimeOffDays limits the length of a single time off request.
+const MaxTimeOffDays = 366
+
+// sessionInTimeOff is true for the session s of group g at pool p when the
+// trainer of the group has approved time off on the local day of the session.
+const sessionInTimeOff = `EXISTS (SELECT 1 FROM trainer_time_off o WHERE o.trainer_id = g.trainer_id
+	AND o.status = 'approved' AND (s.starts_at AT TIME ZONE p.time_zone)::date BETWEEN o.starts_on AND o.ends_on)`
+
+// TimeOff is a period a trainer can't work, such as a vacation or sick
+// leave. Trainers declare it and admins approve it; sessions falling into
+// approved time off need a substitute.
+type TimeOff struct {
+	ID               int64      `json:"id"`
+	TrainerID        int64      `json:"trainer_id"`
+	TrainerName      string     `json:"trainer_name"`
+	Kind             string     `json:"kind"`
+	StartsOn         Date       `json:"starts_on"`
+	EndsOn           Date       `json:"ends_on"`
+	Note             string     `json:"note"`
+	Status           string     `json:"status"`
+	CreatedAt        time.Time  `json:"created_at"`
+	DecidedBy        *int64     `json:"decided_by,omitempty"`
+	DecidedAt        *time.Time `json:"decided_at,omitempty"`
+	SessionsAffected int        `json:"sessions_affected"`
+}
+
+func ValidateTimeOff(v *validator.Validator, t *TimeOff) {
+	v.Check(validator.PermittedValue(t.Kind, TimeOffVacation, TimeOffSickLeave, TimeOffOther), "kind", "must be vacation, sick_leave or other")
+	v.Check(!t.StartsOn.IsZero(), "starts_on", "must be provided")
+	v.Check(!t.EndsOn.IsZero(), "ends_on", "must be provided")
+	v.Check(!t.EndsOn.Before(t.StartsOn.Time), "ends_on", "must not be before starts_on")
+	v.Check(t.EndsOn.Sub(t.StartsOn.Time) < MaxTimeOffDays*24*time.Hour, "ends_on", "must be less than a year after starts_on")
+	v.Check(len(t.Note) <= 500, "note", "must not be more than 500 bytes long")
+}
+
+// IncludesWeekday reports whether some day of the time off from the given
+// day on falls on the ISO day of the week.
+func (t *TimeOff) IncludesWeekday(dayOfWeek int, from Date) bool {
+	day := t.StartsOn.Time
+	if from.After(day) {
+		day = from.Time
+	}
+
+	for i := 0; i < 7 && !day.After(t.EndsOn.Time); i++ {
+		if isoWeekday(day) == dayOfWeek {
+			return true
+		}
+		day = day.AddDate(0, 0, 1)
+	}
+
+	return false
+}
+
+func isoWeekday(t time.Time) int {
+	if t.Weekday() == time.Sunday {
+		return 7
+	}
+
+	return int(t.Weekday())
+}
+
+type TimeOffModel struct {
+	DB *sql.DB
+}
+
+func (tm TimeOffModel) Insert(t *TimeOff) error {
+	query := `INSERT INTO trainer_time_off (trainer_id, kind, starts_on, ends_on, note)
+	VALUES ($1, $2, $3, $4, $5)
+	RETURNING id, status, created_at`
+
+	args := []any{t.TrainerID, t.Kind, t.StartsOn, t.EndsOn, t.Note}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	return tm.DB.QueryRowContext(ctx, query, args...).Scan(&t.ID, &t.Status, &t.CreatedAt)
+}
+
+const timeOffColumns = `o.id, o.trainer_id, u.full_name, o.kind, o.starts_on, o.ends_on, o.note, o.status,
+	o.created_at, o.decided_by, o.decided_at,
+	(SELECT count(*) FROM training_sessions s JOIN training_groups g ON g.id = s.group_id
+	JOIN pools p ON p.id = g.pool_id
+	WHERE g.trainer_id = o.trainer_id AND s.cancelled_at IS NULL
+	AND (s.starts_at AT TIME ZONE p.time_zone)::date BETWEEN o.starts_on AND o.ends_on)`
+
+func scanTimeOff(row interface{ Scan(...any) error }, t *TimeOff) error {
+	return row.Scan(&t.ID, &t.TrainerID, &t.TrainerName, &t.Kind, &t.StartsOn, &t.EndsOn, &t.Note, &t.Status,
+		&t.CreatedAt, &t.DecidedBy, &t.DecidedAt, &t.SessionsAffected)
+}
+
+func (tm TimeOffModel) Get(id int64) (*TimeOff, error) {
+	if id < 1 {
+		return nil, ErrRecordNotFound
+	}
+
+	query := `SELECT ` + timeOffColumns + `
+	FROM trainer_time_off o JOIN trainers t ON t.id = o.trainer_id JOIN users u ON u.id = t.user_id
+	WHERE o.id = $1`
+
+	var t TimeOff
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := scanTimeOff(tm.DB.QueryRowContext(ctx, query, id), &t)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	return &t, nil
+}
+
+// GetAllForTrainer returns the time off of the trainer that hasn't ended
+// before the given day, earliest first.
+func (tm TimeOffModel) GetAllForTrainer(trainerID int64, from Date) ([]*TimeOff, error) {
+	query := `SELECT ` + timeOffColumns + `
+	FROM trainer_time_off o JOIN trainers t ON t.id = o.trainer_id JOIN users u ON u.id = t.user_id
+	WHERE o.trainer_id = $1 AND o.ends_on >= $2
+	ORDER BY o.starts_on, o.id`
+
+	return tm.query(query, trainerID, from)
+}
+
+// GetAll returns the time off of all trainers overlapping the period from
+// and to, optionally only the time off with the given status.
+func (tm TimeOffModel) GetAll(from, to Date, status string) ([]*TimeOff, error) {
+	query := `SELECT ` + timeOffColumns + `
+	FROM trainer_time_off o JOIN trainers t ON t.id = o.trainer_id JOIN users u ON u.id = t.user_id
+	WHERE o.starts_on <= $2 AND o.ends_on >= $1 AND (o.status = $3 OR $3 = '')
+	ORDER BY o.starts_on, u.full_name, o.id`
+
+	return tm.query(query, from, to, status)
+}
+
+func (tm TimeOffModel) query(query string, args ...any) ([]*TimeOff, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := tm.DB.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	timeOff := []*TimeOff{}
+
+	for rows.Next() {
+		var t TimeOff
+
+		err := scanTimeOff(rows, &t)
+		if err != nil {
+			return nil, err
+		}
+
+		timeOff = append(timeOff, &t)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return timeOff, nil
+}
+
+// Decide approves or rejects pending time off.
+func (tm TimeOffModel) Decide(t *TimeOff, status string, decidedBy *int64, audit *AuditEntry) error {
+	query := `UPDATE trainer_time_off SET status = $1, decided_by = $2, decided_at = NOW()
+	WHERE id = $3 AND status = 'pending'
+	RETURNING decided_at`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := tm.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	err = tx.QueryRowContext(ctx, query, status, decidedBy, t.ID).Scan(&t.DecidedAt)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrTimeOffDecided
+		default:
+			return err
+		}
+	}
+
+	if audit != nil {
+		audit.TargetID = strconv.FormatInt(t.ID, 10)
+
+		err = audit.SetChanges(map[string]string{"status": t.Status}, map[string]string{"status": status})
+		if err != nil {
+			return err
+		}
+
+		err = insertAuditEntry(ctx, tx, audit)
+		if err != nil {
+			return err
+		}
+	}
+
+	t.Status = status
+	t.DecidedBy = decidedBy
+
+	return tx.Commit()
+}
+
+// Cancel removes time off of any status. Substitutes already assigned to
+// the sessions it covered are kept.
+func (tm TimeOffModel) Cancel(t *TimeOff, audit *AuditEntry) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := tm.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	result, err := tx.ExecContext(ctx, `DELETE FROM trainer_time_off WHERE id = $1`, t.ID)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+
+	if audit != nil {
+		audit.TargetID = strconv.FormatInt(t.ID, 10)
+
+		err = audit.SetChanges(t, nil)
+		if err != nil {
+			return err
+		}
+
+		err = insertAuditEntry(ctx, tx, audit)
+		if err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// Delete withdraws time off of the trainer that hasn't been approved yet.
+func (tm TimeOffModel) Delete(id, trainerID int64) error {
+	query := `DELETE FROM trainer_time_off WHERE id = $1 AND trainer_id = $2 AND status <> 'approved'`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	result, err := tm.DB.ExecContext(ctx, query, id, trainerID)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+
+	return nil
+}
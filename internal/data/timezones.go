@@ -0,0 +1,46 @@
+package data
+
+import (
+	"time"
+	// the zone database is embedded so pools can use any IANA zone even
+	// where the host has none installed
+	_ "time/tzdata"
+
+	"github.com/obrikash/swimming_pool/internal/validator"
+)
+
+// DefaultTimeZone is the zone of new pools.
+const DefaultTimeZone = "Europe/Moscow"
+
+// DefaultLocation is the zone of dates that don't belong to any pool, such
+// as the time off of a trainer or the period of a report.
+var DefaultLocation = mustLoadLocation(DefaultTimeZone)
+
+func mustLoadLocation(name string) *time.Location {
+	loc, err := time.LoadLocation(name)
+	if err != nil {
+		panic(err)
+	}
+
+	return loc
+}
+
+func ValidateTimeZone(v *validator.Validator, key, name string) {
+	v.Check(name != "", key, "must be provided")
+
+	// LoadLocation also accepts "Local" and the empty string, which mean the
+	// zone of the server
+	_, err := time.LoadLocation(name)
+	v.Check(err == nil && name != "Local", key, "must be an IANA time zone such as Europe/Moscow")
+}
+
+// Today returns the current date in the zone.
+func Today(loc *time.Location) Date {
+	now := time.Now().In(loc)
+	return NewDate(now.Year(), now.Month(), now.Day())
+}
+
+// In returns the start of the day in the zone.
+func (d Date) In(loc *time.Location) time.Time {
+	return time.Date(d.Year(), d.Month(), d.Day(), 0, 0, 0, 0, loc)
+}
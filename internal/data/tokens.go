@@ -1,12 +1,22 @@
 package data
 
 import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
 	"database/sql"
+	"encoding/base32"
 	"time"
+
+	"github.com/obrikash/swimming_pool/internal/validator"
 )
 
 const (
-	ScopeAuthentication = "authentication"
+	ScopeAuthentication  = "authentication"
+	ScopeEmailChange     = "email-change"
+	ScopeTwoFactor       = "two-factor"
+	ScopeAccountDeletion = "account-deletion"
+	ScopePasswordReset   = "password-reset"
 )
 
 type Token struct {
@@ -17,7 +27,7 @@ type Token struct {
 	Scope     string    `json:"-"`
 }
 
-/*func generateToken(userID int64, ttl time.Duration, scope string) (*Token, error) {
+func generateToken(userID int64, ttl time.Duration, scope string) (*Token, error) {
 	token := &Token{
 		UserID: userID,
 		Expiry: time.Now().Add(ttl),
@@ -36,8 +46,45 @@ type Token struct {
 	token.Hash = hash[:]
 
 	return token, nil
-}*/
+}
+
+func ValidateTokenPlaintext(v *validator.Validator, tokenPlaintext string) {
+	v.Check(tokenPlaintext != "", "token", "must be provided")
+	v.Check(len(tokenPlaintext) == 26, "token", "must be 26 bytes long")
+}
 
 type TokenModel struct {
 	DB *sql.DB
 }
+
+func (tm TokenModel) New(userID int64, ttl time.Duration, scope string) (*Token, error) {
+	token, err := generateToken(userID, ttl, scope)
+	if err != nil {
+		return nil, err
+	}
+
+	err = tm.Insert(token)
+	return token, err
+}
+
+func (tm TokenModel) Insert(token *Token) error {
+	query := `INSERT INTO tokens (hash, user_id, expiry, scope) VALUES ($1, $2, $3, $4)`
+
+	args := []any{token.Hash, token.UserID, token.Expiry, token.Scope}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	_, err := tm.DB.ExecContext(ctx, query, args...)
+	return err
+}
+
+func (tm TokenModel) DeleteAllForUser(scope string, userID int64) error {
+	query := `DELETE FROM tokens WHERE scope = $1 AND user_id = $2`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	_, err := tm.DB.ExecContext(ctx, query, scope, userID)
+	return err
+}
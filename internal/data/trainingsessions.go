@@ -0,0 +1,488 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/lib/pq"
+	"github.com/obrikash/swimming_pool/internal/validator"
+)
+
+var (
+	ErrSessionCancelled = errors.New("session is cancelled")
+	ErrSessionNotOpen   = errors.New("attendance can only be marked once the session is about to start")
+	ErrNotGroupMember   = errors.New("user is not a member of the group")
+)
+
+// MaxSessionRange limits how many days of sessions can be requested at once.
+const MaxSessionRange = 62
+
+// AttendanceOpensBefore is how long before the start of a session the trainer
+// may begin marking attendance.
+const AttendanceOpensBefore = 30 * time.Minute
+
+// TrainingSession is a dated occurrence of a group's schedule.
+type TrainingSession struct {
+	ID          int64      `json:"id"`
+	GroupID     int64      `json:"group_id"`
+	ScheduleID  *int64     `json:"schedule_id"`
+	Category    string     `json:"category"`
+	PoolName    string     `json:"pool_name"`
+	TimeZone    string     `json:"time_zone"`
+	StartsAt    time.Time  `json:"starts_at"`
+	CancelledAt *time.Time `json:"cancelled_at,omitempty"`
+	// DurationMinutes and Lanes are copied from the schedule when the
+	// session is generated.
+	DurationMinutes int `json:"duration_minutes"`
+	Lanes           int `json:"lanes"`
+	// NeedsSubstitute is set when the trainer has approved time off on
+	// the day of the session or is no longer assigned to the pool on it,
+	// and no substitute has been assigned yet.
+	NeedsSubstitute     bool   `json:"needs_substitute"`
+	SubstituteTrainerID *int64 `json:"substitute_trainer_id,omitempty"`
+	Members             int    `json:"members"`
+	Attended            int    `json:"attended"`
+}
+
+// TrainerGroup is a group as seen by the trainer running it.
+type TrainerGroup struct {
+	ID       int64  `json:"id"`
+	Category string `json:"category"`
+	PoolID   int64  `json:"pool_id"`
+	PoolName string `json:"pool_name"`
+	Members  int    `json:"members"`
+}
+
+// RosterEntry describes a member of a group for the trainer running it.
+type RosterEntry struct {
+	UserID             int64      `json:"user_id"`
+	FullName           string     `json:"full_name"`
+	SubscriptionStatus string     `json:"subscription_status"`
+	SubscriptionName   string     `json:"subscription_name,omitempty"`
+	SubscriptionEnd    *time.Time `json:"subscription_end,omitempty"`
+	Streak             int        `json:"attendance_streak"`
+	LastAttended       *time.Time `json:"last_attended,omitempty"`
+}
+
+const (
+	SubscriptionNone     = "none"
+	SubscriptionActive   = "active"
+	SubscriptionUpcoming = "upcoming"
+	SubscriptionExpired  = "expired"
+)
+
+// Attendance is the mark of one member for one session.
+type Attendance struct {
+	SessionID int64      `json:"session_id"`
+	UserID    int64      `json:"user_id"`
+	StartsAt  *time.Time `json:"starts_at,omitempty"`
+	TimeZone  string     `json:"time_zone,omitempty"`
+	Present   bool       `json:"present"`
+	MarkedAt  time.Time  `json:"marked_at"`
+}
+
+func ValidateSessionRange(v *validator.Validator, from, to Date) {
+	v.Check(!to.Before(from.Time), "to", "must not be before from")
+	v.Check(to.Sub(from.Time) <= MaxSessionRange*24*time.Hour, "to", "must be at most 62 days after from")
+}
+
+type TrainingSessionModel struct {
+	DB *sql.DB
+}
+
+// Generate creates the sessions of all active groups that fall between from
+// and to according to their schedules. Schedules are in the local time of
+// the pool, so the dates and times are taken in its zone. Days the pool is
+// closed at that time or the trainer isn't assigned to it are skipped.
+// Sessions are only generated from today on, so the past is never
+// rewritten, and existing ones are left alone.
+func (tm TrainingSessionModel) Generate(from, to Date) error {
+	query := `INSERT INTO training_sessions (group_id, schedule_id, starts_at, duration_minutes, lanes)
+	SELECT s.group_id, s.id, (d.day::date + s.time_of_day) AT TIME ZONE p.time_zone, s.duration_minutes, s.lanes
+	FROM schedules s
+	JOIN training_groups g ON g.id = s.group_id AND g.archived_at IS NULL
+	JOIN pools p ON p.id = g.pool_id
+	CROSS JOIN LATERAL generate_series(GREATEST($1::date, (NOW() AT TIME ZONE p.time_zone)::date), $2::date, interval '1 day') AS d(day)
+	WHERE s.day_of_week = EXTRACT(ISODOW FROM d.day)
+	AND EXISTS (SELECT 1 FROM pool_hours(p.id, d.day::date) h WHERE s.time_of_day >= h.opens_at
+		AND s.time_of_day::interval + s.duration_minutes * interval '1 minute' <= h.closes_at::interval)
+	AND EXISTS (SELECT 1 FROM trainer_assignments a WHERE a.trainer_id = g.trainer_id AND a.pool_id = p.id
+		AND d.day::date >= a.starts_on AND (a.ends_on IS NULL OR d.day::date <= a.ends_on)
+		AND (a.days_of_week IS NULL OR EXTRACT(ISODOW FROM d.day)::int = ANY(a.days_of_week)))
+	ON CONFLICT (group_id, starts_at) DO NOTHING`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	_, err := tm.DB.ExecContext(ctx, query, from, to)
+	return err
+}
+
+func (tm TrainingSessionModel) Get(id int64) (*TrainingSession, error) {
+	if id < 1 {
+		return nil, ErrRecordNotFound
+	}
+
+	query := `SELECT s.id, s.group_id, s.schedule_id, p.time_zone, s.starts_at, s.cancelled_at, s.duration_minutes, s.lanes,
+	s.substitute_trainer_id
+	FROM training_sessions s
+	JOIN training_groups g ON g.id = s.group_id
+	JOIN pools p ON p.id = g.pool_id
+	WHERE s.id = $1`
+
+	var session TrainingSession
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := tm.DB.QueryRowContext(ctx, query, id).Scan(&session.ID, &session.GroupID, &session.ScheduleID, &session.TimeZone,
+		&session.StartsAt, &session.CancelledAt, &session.DurationMinutes, &session.Lanes, &session.SubstituteTrainerID)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	session.localize()
+
+	return &session, nil
+}
+
+// localize shows the times of the session in the zone of its pool.
+func (s *TrainingSession) localize() {
+	loc, err := time.LoadLocation(s.TimeZone)
+	if err != nil {
+		return
+	}
+
+	s.StartsAt = s.StartsAt.In(loc)
+
+	if s.CancelledAt != nil {
+		cancelledAt := s.CancelledAt.In(loc)
+		s.CancelledAt = &cancelledAt
+	}
+}
+
+// GetAllForTrainer returns the sessions of the trainer's groups and the
+// sessions the trainer substitutes in that fall between the from and to
+// dates, both inclusive, in the local time of their pools. Cancelled ones
+// are included.
+func (tm TrainingSessionModel) GetAllForTrainer(trainerID int64, from, to Date) ([]*TrainingSession, error) {
+	query := `SELECT s.id, s.group_id, s.schedule_id, c.name, p.name, p.time_zone, s.starts_at, s.cancelled_at, s.duration_minutes, s.lanes,
+	s.cancelled_at IS NULL AND s.substitute_trainer_id IS NULL AND (` + sessionInTimeOff + ` OR ` + sessionOutsideAssignment + `),
+	s.substitute_trainer_id,
+	(SELECT count(*) FROM user_groups ug WHERE ug.group_id = g.id),
+	(SELECT count(*) FROM attendance a WHERE a.session_id = s.id AND a.present)
+	FROM training_sessions s
+	JOIN training_groups g ON g.id = s.group_id
+	JOIN group_category c ON c.id = g.category_id
+	JOIN pools p ON p.id = g.pool_id
+	WHERE (g.trainer_id = $1 OR s.substitute_trainer_id = $1)
+	AND (s.starts_at AT TIME ZONE p.time_zone)::date BETWEEN $2 AND $3
+	ORDER BY s.starts_at`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := tm.DB.QueryContext(ctx, query, trainerID, from, to)
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	sessions := []*TrainingSession{}
+
+	for rows.Next() {
+		var session TrainingSession
+
+		err := rows.Scan(&session.ID, &session.GroupID, &session.ScheduleID, &session.Category, &session.PoolName, &session.TimeZone,
+			&session.StartsAt, &session.CancelledAt, &session.DurationMinutes, &session.Lanes, &session.NeedsSubstitute, &session.SubstituteTrainerID, &session.Members, &session.Attended)
+		if err != nil {
+			return nil, err
+		}
+
+		session.localize()
+
+		sessions = append(sessions, &session)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return sessions, nil
+}
+
+// EndsAt returns the time the session is over.
+func (s *TrainingSession) EndsAt() time.Time {
+	return s.StartsAt.Add(time.Duration(s.DurationMinutes) * time.Minute)
+}
+
+// GetAllForPool returns the sessions of the groups of the pool that overlap
+// the span between from and to, leaving out cancelled ones.
+func (tm TrainingSessionModel) GetAllForPool(poolID int64, from, to time.Time) ([]*TrainingSession, error) {
+	query := `SELECT s.id, s.group_id, s.schedule_id, c.name, p.name, p.time_zone, s.starts_at, s.duration_minutes, s.lanes
+	FROM training_sessions s
+	JOIN training_groups g ON g.id = s.group_id
+	JOIN group_category c ON c.id = g.category_id
+	JOIN pools p ON p.id = g.pool_id
+	WHERE g.pool_id = $1 AND s.cancelled_at IS NULL
+	AND s.starts_at < $3 AND s.starts_at + s.duration_minutes * interval '1 minute' > $2
+	ORDER BY s.starts_at, s.id`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := tm.DB.QueryContext(ctx, query, poolID, from, to)
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	sessions := []*TrainingSession{}
+
+	for rows.Next() {
+		var session TrainingSession
+
+		err := rows.Scan(&session.ID, &session.GroupID, &session.ScheduleID, &session.Category, &session.PoolName, &session.TimeZone,
+			&session.StartsAt, &session.DurationMinutes, &session.Lanes)
+		if err != nil {
+			return nil, err
+		}
+
+		session.localize()
+
+		sessions = append(sessions, &session)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return sessions, nil
+}
+
+// GetGroupsForTrainer returns the active groups run by the trainer.
+func (tm TrainingSessionModel) GetGroupsForTrainer(trainerID int64) ([]*TrainerGroup, error) {
+	query := `SELECT g.id, c.name, p.id, p.name, (SELECT count(*) FROM user_groups ug WHERE ug.group_id = g.id)
+	FROM training_groups g
+	JOIN group_category c ON c.id = g.category_id
+	JOIN pools p ON p.id = g.pool_id
+	WHERE g.trainer_id = $1 AND g.archived_at IS NULL
+	ORDER BY g.id`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := tm.DB.QueryContext(ctx, query, trainerID)
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	groups := []*TrainerGroup{}
+
+	for rows.Next() {
+		var group TrainerGroup
+
+		err := rows.Scan(&group.ID, &group.Category, &group.PoolID, &group.PoolName, &group.Members)
+		if err != nil {
+			return nil, err
+		}
+
+		groups = append(groups, &group)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return groups, nil
+}
+
+// GetRoster returns the members of the group with their latest subscription
+// and attendance streak. The streak counts the most recent past sessions of
+// the group the member attended in a row; unmarked sessions break it.
+func (tm TrainingSessionModel) GetRoster(groupID int64) ([]*RosterEntry, error) {
+	query := `WITH past AS (
+		SELECT id, starts_at FROM training_sessions
+		WHERE group_id = $1 AND cancelled_at IS NULL AND starts_at <= NOW()
+	), marks AS (
+		SELECT ug.user_id, p.starts_at, COALESCE(a.present, false) AS present,
+		row_number() OVER (PARTITION BY ug.user_id ORDER BY p.starts_at DESC) AS rn
+		FROM user_groups ug CROSS JOIN past p
+		LEFT JOIN attendance a ON a.session_id = p.id AND a.user_id = ug.user_id
+		WHERE ug.group_id = $1
+	), streaks AS (
+		SELECT user_id, COALESCE(min(rn) FILTER (WHERE NOT present) - 1, count(*)) AS streak,
+		max(starts_at) FILTER (WHERE present) AS last_attended
+		FROM marks GROUP BY user_id
+	)
+	SELECT u.id, u.full_name, sub.name, sub.date_start, sub.date_end, COALESCE(st.streak, 0), st.last_attended
+	FROM user_groups ug
+	JOIN users u ON u.id = ug.user_id
+	LEFT JOIN LATERAL (
+		SELECT s.name, us.date_start, us.date_end FROM user_subscriptions us
+		JOIN subscriptions s ON s.id = us.subscription_id
+		WHERE us.user_id = u.id
+		ORDER BY us.date_end DESC LIMIT 1
+	) sub ON true
+	LEFT JOIN streaks st ON st.user_id = u.id
+	WHERE ug.group_id = $1
+	ORDER BY u.full_name`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := tm.DB.QueryContext(ctx, query, groupID)
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	roster := []*RosterEntry{}
+	now := time.Now()
+
+	for rows.Next() {
+		var entry RosterEntry
+		var name sql.NullString
+		var start *time.Time
+
+		err := rows.Scan(&entry.UserID, &entry.FullName, &name, &start, &entry.SubscriptionEnd, &entry.Streak, &entry.LastAttended)
+		if err != nil {
+			return nil, err
+		}
+
+		entry.SubscriptionName = name.String
+
+		switch {
+		case start == nil || entry.SubscriptionEnd == nil:
+			entry.SubscriptionStatus = SubscriptionNone
+		case now.Before(*start):
+			entry.SubscriptionStatus = SubscriptionUpcoming
+		case now.After(*entry.SubscriptionEnd):
+			entry.SubscriptionStatus = SubscriptionExpired
+		default:
+			entry.SubscriptionStatus = SubscriptionActive
+		}
+
+		roster = append(roster, &entry)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return roster, nil
+}
+
+// MarkAttendance records the given marks for the session in one
+// transaction. Every user has to be a member of the session's group.
+func (tm TrainingSessionModel) MarkAttendance(session *TrainingSession, marks []*Attendance, markedBy int64) error {
+	if session.CancelledAt != nil {
+		return ErrSessionCancelled
+	}
+
+	if time.Until(session.StartsAt) > AttendanceOpensBefore {
+		return ErrSessionNotOpen
+	}
+
+	userIDs := make([]int64, len(marks))
+	for i, mark := range marks {
+		userIDs[i] = mark.UserID
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := tm.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var members int
+
+	query := `SELECT count(*) FROM user_groups WHERE group_id = $1 AND user_id = ANY($2)`
+
+	err = tx.QueryRowContext(ctx, query, session.GroupID, pq.Array(userIDs)).Scan(&members)
+	if err != nil {
+		return err
+	}
+
+	if members != len(marks) {
+		return ErrNotGroupMember
+	}
+
+	query = `INSERT INTO attendance (session_id, user_id, present, marked_by)
+	VALUES ($1, $2, $3, NULLIF($4, 0))
+	ON CONFLICT (session_id, user_id) DO UPDATE SET present = EXCLUDED.present,
+	marked_by = EXCLUDED.marked_by, marked_at = NOW()
+	RETURNING marked_at`
+
+	for _, mark := range marks {
+		mark.SessionID = session.ID
+
+		err = tx.QueryRowContext(ctx, query, session.ID, mark.UserID, mark.Present, markedBy).Scan(&mark.MarkedAt)
+		if err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// GetAttendanceForUser returns every attendance mark of the user, newest
+// first.
+func (tm TrainingSessionModel) GetAttendanceForUser(userID int64) ([]*Attendance, error) {
+	query := `SELECT a.session_id, a.user_id, s.starts_at, p.time_zone, a.present, a.marked_at
+	FROM attendance a JOIN training_sessions s ON s.id = a.session_id
+	JOIN training_groups g ON g.id = s.group_id
+	JOIN pools p ON p.id = g.pool_id
+	WHERE a.user_id = $1
+	ORDER BY s.starts_at DESC`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := tm.DB.QueryContext(ctx, query, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	marks := []*Attendance{}
+
+	for rows.Next() {
+		var mark Attendance
+
+		err := rows.Scan(&mark.SessionID, &mark.UserID, &mark.StartsAt, &mark.TimeZone, &mark.Present, &mark.MarkedAt)
+		if err != nil {
+			return nil, err
+		}
+
+		if loc, err := time.LoadLocation(mark.TimeZone); err == nil {
+			startsAt := mark.StartsAt.In(loc)
+			mark.StartsAt = &startsAt
+			mark.MarkedAt = mark.MarkedAt.In(loc)
+		}
+
+		marks = append(marks, &mark)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return marks, nil
+}
@@ -0,0 +1,224 @@
+package data
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base32"
+	"errors"
+	"strings"
+	"time"
+)
+
+var (
+	ErrTwoFactorEnabled = errors.New("two-factor authentication is already enabled")
+)
+
+const recoveryCodesCount = 10
+
+type TwoFactor struct {
+	UserID       int64
+	Secret       string
+	CreatedAt    time.Time
+	ConfirmedAt  *time.Time
+	LastUsedStep int64
+}
+
+func (tf *TwoFactor) Enabled() bool {
+	return tf != nil && tf.ConfirmedAt != nil
+}
+
+// generateRecoveryCodes returns recovery codes in the xxxxx-xxxxx form along
+// with their hashes. Only the hashes are stored.
+func generateRecoveryCodes() ([]string, [][]byte, error) {
+	codes := make([]string, recoveryCodesCount)
+	hashes := make([][]byte, recoveryCodesCount)
+
+	for i := range codes {
+		randomBytes := make([]byte, 7)
+		_, err := rand.Read(randomBytes)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		code := strings.ToLower(base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString(randomBytes))[:10]
+		codes[i] = code[:5] + "-" + code[5:]
+		hashes[i] = hashRecoveryCode(codes[i])
+	}
+
+	return codes, hashes, nil
+}
+
+func hashRecoveryCode(code string) []byte {
+	code = strings.ToLower(strings.ReplaceAll(strings.TrimSpace(code), "-", ""))
+	hash := sha256.Sum256([]byte(code))
+	return hash[:]
+}
+
+type TwoFactorModel struct {
+	DB *sql.DB
+}
+
+func (tm TwoFactorModel) Get(userID int64) (*TwoFactor, error) {
+	query := `SELECT user_id, secret, created_at, confirmed_at, last_used_step FROM users_totp WHERE user_id = $1`
+
+	var tf TwoFactor
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := tm.DB.QueryRowContext(ctx, query, userID).Scan(&tf.UserID, &tf.Secret, &tf.CreatedAt, &tf.ConfirmedAt, &tf.LastUsedStep)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	return &tf, nil
+}
+
+// Begin stores a new, not yet confirmed secret for the user, replacing any
+// earlier unconfirmed one.
+func (tm TwoFactorModel) Begin(userID int64, secret string) error {
+	query := `INSERT INTO users_totp (user_id, secret) VALUES ($1, $2)
+	ON CONFLICT (user_id) DO UPDATE SET secret = EXCLUDED.secret, created_at = NOW(), last_used_step = 0
+	WHERE users_totp.confirmed_at IS NULL`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	result, err := tm.DB.ExecContext(ctx, query, userID, secret)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return ErrTwoFactorEnabled
+	}
+
+	return nil
+}
+
+// Confirm enables two-factor authentication after the first valid code and
+// returns a fresh set of recovery codes.
+func (tm TwoFactorModel) Confirm(userID int64, step int64) ([]string, error) {
+	codes, hashes, err := generateRecoveryCodes()
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := tm.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	query := `UPDATE users_totp SET confirmed_at = NOW(), last_used_step = $1
+	WHERE user_id = $2 AND confirmed_at IS NULL`
+
+	result, err := tx.ExecContext(ctx, query, step, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return nil, err
+	}
+
+	if rowsAffected == 0 {
+		return nil, ErrTwoFactorEnabled
+	}
+
+	_, err = tx.ExecContext(ctx, `DELETE FROM recovery_codes WHERE user_id = $1`, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, hash := range hashes {
+		_, err = tx.ExecContext(ctx, `INSERT INTO recovery_codes (user_id, hash) VALUES ($1, $2)`, userID, hash)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return codes, tx.Commit()
+}
+
+// UseStep records that the code of the given step was used. It returns false
+// if a code of this or a later step was already accepted, which means the
+// code is being replayed.
+func (tm TwoFactorModel) UseStep(userID int64, step int64) (bool, error) {
+	query := `UPDATE users_totp SET last_used_step = $1 WHERE user_id = $2 AND last_used_step < $1`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	result, err := tm.DB.ExecContext(ctx, query, step, userID)
+	if err != nil {
+		return false, err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+
+	return rowsAffected == 1, nil
+}
+
+// UseRecoveryCode marks the recovery code as used. It returns false if the
+// code doesn't exist or was used before.
+func (tm TwoFactorModel) UseRecoveryCode(userID int64, code string) (bool, error) {
+	query := `UPDATE recovery_codes SET used_at = NOW() WHERE user_id = $1 AND hash = $2 AND used_at IS NULL`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	result, err := tm.DB.ExecContext(ctx, query, userID, hashRecoveryCode(code))
+	if err != nil {
+		return false, err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+
+	return rowsAffected > 0, nil
+}
+
+func (tm TwoFactorModel) Delete(userID int64) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := tm.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.ExecContext(ctx, `DELETE FROM recovery_codes WHERE user_id = $1`, userID)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.ExecContext(ctx, `DELETE FROM users_totp WHERE user_id = $1`, userID)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
@@ -2,19 +2,29 @@ package data
 
 import (
 	"context"
+	"crypto/rand"
+	"crypto/sha256"
 	"database/sql"
+	"encoding/hex"
 	"errors"
+	"fmt"
+	"slices"
+	"strings"
 	"time"
 
+	"github.com/lib/pq"
 	"github.com/obrikash/swimming_pool/internal/validator"
-	"golang.org/x/crypto/bcrypt"
 )
 
 var (
-	ErrDuplicateEmail         = errors.New("duplicate email")
-	ErrTrainerAlreadyAttached = errors.New("Trainer is already attached to the pool.")
+	ErrDuplicateEmail = errors.New("duplicate email")
+	ErrInvalidRole    = errors.New("invalid role")
+	ErrMergeConflict  = errors.New("accounts can't be merged automatically")
 )
 
+// AnonymisedName replaces the name of users who had their account deleted.
+const AnonymisedName = "Удалённый пользователь"
+
 type password struct {
 	plaintext *string
 	hash      []byte
@@ -30,6 +40,24 @@ type User struct {
 	Password  password  `json:"-"`
 	RoleID    uint8     `json:"role_id"`
 	Image     string    `json:"image_url"`
+	Thumbnail string    `json:"thumbnail_url"`
+	Active    bool      `json:"active"`
+
+	PendingEmail string `json:"pending_email,omitempty"`
+	Birthdate    *Date  `json:"birthdate,omitempty"`
+	SkillLevel   int    `json:"skill_level"`
+}
+
+// Trainer is the trainer record of a user. PoolIDs are the pools the
+// trainer is assigned to today.
+type Trainer struct {
+	ID      int64   `json:"id"`
+	UserID  int64   `json:"user_id"`
+	PoolIDs []int64 `json:"pool_ids"`
+}
+
+func (t *Trainer) WorksAt(poolID int64) bool {
+	return slices.Contains(t.PoolIDs, poolID)
 }
 
 type PoolWithTrainers struct {
@@ -45,35 +73,23 @@ func (u *User) IsAnonymous() bool {
 	return u == AnonymousUser
 }
 
-func (p *password) Set(plaintextPassword string) error {
-	hash, err := bcrypt.GenerateFromPassword([]byte(plaintextPassword), 12)
-	if err != nil {
-		return err
-	}
-
-	p.plaintext = &plaintextPassword
-	p.hash = hash
-
-	return nil
+func ValidatePasswordPlaintext(v *validator.Validator, password string) {
+	v.Check(password != "", "password", "must be provided")
+	v.Check(len(password) >= 8, "password", "must be at 8 bytes long")
+	v.Check(len(password) <= 256, "password", "must not be more than 256 bytes long")
 }
 
-func (p *password) Matches(plaintextPassword string) (bool, error) {
-	err := bcrypt.CompareHashAndPassword(p.hash, []byte(plaintextPassword))
-	if err != nil {
-		switch {
-		case errors.Is(err, bcrypt.ErrMismatchedHashAndPassword):
-			return false, nil
-		default:
-			return false, err
-		}
+// NormalizeEmail trims the address and lowercases its domain. The local part
+// is kept as typed, lookups and the unique index ignore its case anyway.
+func NormalizeEmail(email string) string {
+	email = strings.TrimSpace(email)
+
+	at := strings.LastIndex(email, "@")
+	if at < 0 {
+		return email
 	}
-	return true, nil
-}
 
-func ValidatePasswordPlaintext(v *validator.Validator, password string) {
-	v.Check(password != "", "password", "must be provided")
-	v.Check(len(password) >= 8, "password", "must be at 8 bytes long")
-	v.Check(len(password) <= 72, "password", "must not be more than 72 bytes long")
+	return email[:at] + strings.ToLower(email[at:])
 }
 
 func ValidateEmail(v *validator.Validator, email string) {
@@ -88,6 +104,7 @@ func ValidateUser(v *validator.Validator, user *User) {
 	ValidateEmail(v, user.Email)
 	if user.Password.plaintext != nil {
 		ValidatePasswordPlaintext(v, *user.Password.plaintext)
+		ValidatePasswordPolicy(v, *user.Password.plaintext, user.Email, user.FullName)
 	}
 
 	if user.Password.hash == nil {
@@ -96,7 +113,7 @@ func ValidateUser(v *validator.Validator, user *User) {
 }
 
 func (um UserModel) GetTrainers() ([]*User, error) {
-	query := `SELECT t.user_id, full_name, u.image, u.email FROM users u JOIN trainers t ON t.user_id = u.id`
+	query := `SELECT t.user_id, full_name, u.image, u.thumbnail, u.email FROM users u JOIN trainers t ON t.user_id = u.id`
 
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
@@ -113,7 +130,7 @@ func (um UserModel) GetTrainers() ([]*User, error) {
 	for rows.Next() {
 		var trainer User
 
-		err := rows.Scan(&trainer.ID, &trainer.FullName, &trainer.Image, &trainer.Email)
+		err := rows.Scan(&trainer.ID, &trainer.FullName, &trainer.Image, &trainer.Thumbnail, &trainer.Email)
 		if err != nil {
 			return nil, err
 		}
@@ -128,20 +145,25 @@ func (um UserModel) GetTrainers() ([]*User, error) {
 	return trainers, nil
 }
 
-func (um UserModel) GetTrainersForPools() ([]PoolWithTrainers, error) {
+// GetTrainersForPools returns the trainers assigned to each pool on the
+// given day.
+func (um UserModel) GetTrainersForPools(day Date) ([]PoolWithTrainers, error) {
 	query := `
-        SELECT p.id, p.name, p.address, p.type,
-               u.id, u.full_name, u.email, u.image
-        FROM trainers t
-        JOIN pools p ON t.pool_id = p.id
+        SELECT DISTINCT p.id, p.name, p.address, pt.code, pt.name, p.lanes, p.time_zone, p.image, p.thumbnail,
+               u.id, u.full_name, u.email, u.image, u.thumbnail
+        FROM trainer_assignments a
+        JOIN trainers t ON a.trainer_id = t.id
+        JOIN pools p ON a.pool_id = p.id
+        JOIN pool_types pt ON p.type_id = pt.id
         JOIN users u ON t.user_id = u.id
-        ORDER BY p.name, u.id
+        WHERE a.starts_on <= $1 AND (a.ends_on IS NULL OR a.ends_on >= $1)
+        ORDER BY p.name, p.id, u.id
     `
 
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
 
-	rows, err := um.DB.QueryContext(ctx, query)
+	rows, err := um.DB.QueryContext(ctx, query, day)
 	if err != nil {
 		return nil, err
 	}
@@ -156,8 +178,8 @@ func (um UserModel) GetTrainersForPools() ([]PoolWithTrainers, error) {
 		var trainer User
 
 		err := rows.Scan(
-			&pool.ID, &pool.Name, &pool.Address, &pool.PoolType,
-			&trainer.ID, &trainer.FullName, &trainer.Email, &trainer.Image,
+			&pool.ID, &pool.Name, &pool.Address, &pool.PoolTypeCode, &pool.PoolType, &pool.Lanes, &pool.TimeZone, &pool.Image, &pool.Thumbnail,
+			&trainer.ID, &trainer.FullName, &trainer.Email, &trainer.Image, &trainer.Thumbnail,
 		)
 		if err != nil {
 			return nil, err
@@ -195,14 +217,17 @@ func (um UserModel) GetTrainersForPools() ([]PoolWithTrainers, error) {
 
 func (um UserModel) Insert(user *User) error {
 
-	query := "INSERT INTO users (full_name, email, hashed_password, role_id) VALUES ($1, $2, $3, $4) RETURNING id, created_at"
+	// users without an explicit role are registered as clients
+	query := `INSERT INTO users (full_name, email, hashed_password, role_id, image, thumbnail)
+	VALUES ($1, $2, $3, COALESCE(NULLIF($4, 0), (SELECT id FROM roles WHERE code = $7)), $5, $6)
+	RETURNING id, created_at, role_id, active`
 
-	args := []any{user.FullName, user.Email, user.Password.hash, user.RoleID}
+	args := []any{user.FullName, user.Email, user.Password.hash, int(user.RoleID), user.Image, user.Thumbnail, RoleClient}
 
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
 
-	err := um.DB.QueryRowContext(ctx, query, args...).Scan(&user.ID, &user.CreatedAt)
+	err := um.DB.QueryRowContext(ctx, query, args...).Scan(&user.ID, &user.CreatedAt, &user.RoleID, &user.Active)
 	if err != nil {
 		switch {
 		case err.Error() == `pq: duplicate key value violates unique constraint "users_email_key"`:
@@ -215,14 +240,59 @@ func (um UserModel) Insert(user *User) error {
 	return nil
 }
 
+func (um UserModel) GetAll(search string, roleID int, filters Filters) ([]*User, Metadata, error) {
+	query := fmt.Sprintf(`SELECT count(*) OVER(), id, created_at, full_name, email, role_id, image, thumbnail, active
+	FROM users
+	WHERE ($1 = '' OR full_name ILIKE '%%' || $1 || '%%' OR email ILIKE '%%' || $1 || '%%')
+	AND (role_id = $2 OR $2 = 0)
+	ORDER BY %s %s, id ASC
+	LIMIT $3 OFFSET $4`, filters.sortColumn(), filters.sortDirection())
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	args := []any{search, roleID, filters.limit(), filters.offset()}
+
+	rows, err := um.DB.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+
+	defer rows.Close()
+
+	totalRecords := 0
+	users := []*User{}
+
+	for rows.Next() {
+		var user User
+
+		err := rows.Scan(&totalRecords, &user.ID, &user.CreatedAt, &user.FullName, &user.Email, &user.RoleID,
+			&user.Image, &user.Thumbnail, &user.Active)
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+
+		users = append(users, &user)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, Metadata{}, err
+	}
+
+	metadata := calculateMetadata(totalRecords, filters.Page, filters.PageSize)
+
+	return users, metadata, nil
+}
+
 func (um UserModel) GetByEmail(email string) (*User, error) {
-	query := `SELECT id, created_at, full_name, email, hashed_password, role_id, image FROM users WHERE email = $1`
+	query := `SELECT id, created_at, full_name, email, hashed_password, role_id, image, thumbnail, active, COALESCE(pending_email, ''), birthdate, skill_level
+	FROM users WHERE lower(email) = lower($1)`
 	var user User
 
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
 
-	err := um.DB.QueryRowContext(ctx, query, email).Scan(&user.ID, &user.CreatedAt, &user.FullName, &user.Email, &user.Password.hash, &user.RoleID, &user.Image)
+	err := um.DB.QueryRowContext(ctx, query, email).Scan(&user.ID, &user.CreatedAt, &user.FullName, &user.Email, &user.Password.hash, &user.RoleID, &user.Image, &user.Thumbnail, &user.Active, &user.PendingEmail, &user.Birthdate, &user.SkillLevel)
 
 	if err != nil {
 		switch {
@@ -237,7 +307,8 @@ func (um UserModel) GetByEmail(email string) (*User, error) {
 }
 
 func (um UserModel) Get(id int64) (*User, error) {
-	query := `SELECT id, created_at, full_name, email, hashed_password, role_id, image FROM users WHERE id = $1`
+	query := `SELECT id, created_at, full_name, email, hashed_password, role_id, image, thumbnail, active, COALESCE(pending_email, ''), birthdate, skill_level
+	FROM users WHERE id = $1`
 
 	var user User
 
@@ -245,7 +316,7 @@ func (um UserModel) Get(id int64) (*User, error) {
 	defer cancel()
 
 	err := um.DB.QueryRowContext(ctx, query, id).Scan(
-		&user.ID, &user.CreatedAt, &user.FullName, &user.Email, &user.Password.hash, &user.RoleID, &user.Image,
+		&user.ID, &user.CreatedAt, &user.FullName, &user.Email, &user.Password.hash, &user.RoleID, &user.Image, &user.Thumbnail, &user.Active, &user.PendingEmail, &user.Birthdate, &user.SkillLevel,
 	)
 
 	if err != nil {
@@ -260,6 +331,192 @@ func (um UserModel) Get(id int64) (*User, error) {
 	return &user, nil
 }
 
+func (um UserModel) Update(user *User) error {
+	query := `UPDATE users SET full_name = $1, email = $2, hashed_password = $3, image = $4, thumbnail = $5,
+	pending_email = NULLIF($6, ''), role_id = $7, active = $8, birthdate = $9, skill_level = $10 WHERE id = $11`
+
+	args := []any{user.FullName, user.Email, user.Password.hash, user.Image, user.Thumbnail, user.PendingEmail,
+		user.RoleID, user.Active, user.Birthdate, user.SkillLevel, user.ID}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	result, err := um.DB.ExecContext(ctx, query, args...)
+	if err != nil {
+		switch {
+		case err.Error() == `pq: duplicate key value violates unique constraint "users_email_key"`:
+			return ErrDuplicateEmail
+		case err.Error() == `pq: insert or update on table "users" violates foreign key constraint "users_role_id_fkey"`:
+			return ErrInvalidRole
+		default:
+			return err
+		}
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+
+	return nil
+}
+
+// UpdatePassword stores a new hash of the user's password and leaves the
+// rest of the row alone.
+func (um UserModel) UpdatePassword(user *User) error {
+	query := `UPDATE users SET hashed_password = $1 WHERE id = $2`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	_, err := um.DB.ExecContext(ctx, query, user.Password.hash, user.ID)
+	return err
+}
+
+// UpdateByAdmin changes the fields only admins may change: the role, the
+// active flag, the birthdate and the assessed skill level. The change is
+// recorded in the audit log.
+func (um UserModel) UpdateByAdmin(user *User, audit *AuditEntry) error {
+	query := `UPDATE users SET role_id = $1, active = $2, birthdate = $3, skill_level = $4 WHERE id = $5`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := um.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	result, err := tx.ExecContext(ctx, query, user.RoleID, user.Active, user.Birthdate, user.SkillLevel, user.ID)
+	if err != nil {
+		switch {
+		case err.Error() == `pq: insert or update on table "users" violates foreign key constraint "users_role_id_fkey"`:
+			return ErrInvalidRole
+		default:
+			return err
+		}
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+
+	err = insertAuditEntry(ctx, tx, audit)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// Anonymise removes the personal data of the user while keeping the row, so
+// that subscriptions and other financial records stay attributable to an
+// account. Everything that only exists for the person, such as sessions,
+// tokens and group memberships, is deleted.
+func (um UserModel) Anonymise(user *User, audit *AuditEntry) error {
+	user.FullName = AnonymisedName
+	user.Email = fmt.Sprintf("deleted-%d@deleted.invalid", user.ID)
+	user.Image = ""
+	user.Thumbnail = ""
+	user.PendingEmail = ""
+	user.Active = false
+	user.Birthdate = nil
+	user.SkillLevel = 0
+
+	// nobody must be able to sign in to the account again
+	randomBytes := make([]byte, 32)
+	_, err := rand.Read(randomBytes)
+	if err != nil {
+		return err
+	}
+
+	err = user.Password.Set(hex.EncodeToString(randomBytes)[:64])
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	tx, err := um.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	query := `UPDATE users SET full_name = $1, email = $2, hashed_password = $3, image = '', thumbnail = '',
+	pending_email = NULL, birthdate = NULL, skill_level = 0, active = false, deleted_at = NOW()
+	WHERE id = $4 AND deleted_at IS NULL`
+
+	result, err := tx.ExecContext(ctx, query, user.FullName, user.Email, user.Password.hash, user.ID)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+
+	for _, table := range []string{"user_groups", "tokens", "sessions", "user_identities", "users_totp",
+		"recovery_codes", "login_failures", "lockout_events"} {
+		_, err = tx.ExecContext(ctx, "DELETE FROM "+table+" WHERE user_id = $1", user.ID)
+		if err != nil {
+			return err
+		}
+	}
+
+	err = insertAuditEntry(ctx, tx, audit)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func (um UserModel) GetForToken(tokenScope, tokenPlaintext string) (*User, error) {
+	tokenHash := sha256.Sum256([]byte(tokenPlaintext))
+
+	query := `SELECT u.id, u.created_at, u.full_name, u.email, u.hashed_password, u.role_id, u.image, u.thumbnail, u.active, COALESCE(u.pending_email, ''), u.birthdate, u.skill_level
+	FROM users u JOIN tokens t ON u.id = t.user_id
+	WHERE t.hash = $1 AND t.scope = $2 AND t.expiry > $3`
+
+	args := []any{tokenHash[:], tokenScope, time.Now()}
+
+	var user User
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := um.DB.QueryRowContext(ctx, query, args...).Scan(
+		&user.ID, &user.CreatedAt, &user.FullName, &user.Email, &user.Password.hash, &user.RoleID, &user.Image, &user.Thumbnail, &user.Active, &user.PendingEmail, &user.Birthdate, &user.SkillLevel,
+	)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	return &user, nil
+}
+
 type ProfitTrainersPools struct {
 	ID       int64   `json:"id"`
 	FullName string  `json:"full_name"`
@@ -269,10 +526,23 @@ type ProfitTrainersPools struct {
 }
 
 func (um UserModel) ProfitForEachTrainerInEachPool() ([]*ProfitTrainersPools, error) {
-	query := `SELECT tr.id AS trainer_id, u_trainer.full_name AS trainer_name, p.id AS pool_id, p.name AS pool_name,
-	SUM(sub.price) AS total_profit FROM user_subscriptions us JOIN user_groups ug ON us.user_id = ug.user_id 
-	JOIN training_groups tg ON ug.group_id = tg.id JOIN trainers tr ON tg.trainer_id = tr.id JOIN users u_trainer ON 
-	tr.user_id = u_trainer.id JOIN pools p ON tr.pool_id = p.id JOIN subscriptions sub ON us.subscription_id = sub.id 
+	// the price of a subscription is split between the sessions of the
+	// group during the subscription, and every share counts for the trainer
+	// who ran the session: the substitute if there was one, otherwise the
+	// trainer of the group. A subscription without sessions counts for the
+	// trainer of the group as a whole.
+	query := `WITH shares AS (
+		SELECT tg.pool_id, sub.price, COALESCE(s.substitute_trainer_id, tg.trainer_id) AS trainer_id,
+		count(s.id) OVER (PARTITION BY us.user_id, us.subscription_id, tg.id) AS sessions
+		FROM user_subscriptions us JOIN user_groups ug ON us.user_id = ug.user_id
+		JOIN training_groups tg ON ug.group_id = tg.id JOIN subscriptions sub ON us.subscription_id = sub.id
+		LEFT JOIN training_sessions s ON s.group_id = tg.id AND s.cancelled_at IS NULL
+		AND s.starts_at >= us.date_start AND s.starts_at < us.date_end
+	)
+	SELECT tr.id AS trainer_id, u_trainer.full_name AS trainer_name, p.id AS pool_id, p.name AS pool_name,
+	SUM(sh.price / GREATEST(sh.sessions, 1)) AS total_profit
+	FROM shares sh JOIN trainers tr ON sh.trainer_id = tr.id JOIN users u_trainer ON tr.user_id = u_trainer.id
+	JOIN pools p ON sh.pool_id = p.id
 	GROUP BY tr.id, u_trainer.full_name, p.id, p.name ORDER BY p.name, u_trainer.full_name;`
 
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
@@ -305,21 +575,28 @@ func (um UserModel) ProfitForEachTrainerInEachPool() ([]*ProfitTrainersPools, er
 	return profitsOfTrainers, nil
 }
 
-func (um UserModel) AttachTrainerToPool(userID int64, poolID int64) error {
-	query := "INSERT INTO trainers (user_id, pool_id) VALUES ($1, $2) RETURNING id"
+func (um UserModel) GetTrainer(userID int64) (*Trainer, error) {
+	query := `SELECT t.id, t.user_id, array_remove(array_agg(DISTINCT a.pool_id), NULL)
+	FROM trainers t LEFT JOIN (trainer_assignments a JOIN pools p ON p.id = a.pool_id) ON a.trainer_id = t.id
+	AND a.starts_on <= (NOW() AT TIME ZONE p.time_zone)::date
+	AND (a.ends_on IS NULL OR a.ends_on >= (NOW() AT TIME ZONE p.time_zone)::date)
+	WHERE t.user_id = $1
+	GROUP BY t.id, t.user_id`
+
+	var trainer Trainer
 
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
 
-	err := um.DB.QueryRowContext(ctx, query, userID, poolID).Scan(&userID)
+	err := um.DB.QueryRowContext(ctx, query, userID).Scan(&trainer.ID, &trainer.UserID, pq.Array(&trainer.PoolIDs))
 	if err != nil {
 		switch {
-		case err.Error() == `pq: duplicate key value violates unique constraint "trainers_user_id_key"`:
-			return ErrTrainerAlreadyAttached
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
 		default:
-			return err
+			return nil, err
 		}
 	}
 
-	return nil
+	return &trainer, nil
 }
@@ -0,0 +1,146 @@
+package images
+
+import (
+	"bytes"
+	"errors"
+	"image"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"net/http"
+
+	_ "image/gif"
+
+	"golang.org/x/image/draw"
+)
+
+const (
+	MaxDimension       = 1600
+	ThumbnailDimension = 256
+)
+
+var (
+	ErrUnsupportedType = errors.New("unsupported image type")
+	ErrInvalidImage    = errors.New("invalid image")
+)
+
+// MaxPixels guards against decompression bombs: small files that decode into huge bitmaps.
+const MaxPixels = 40_000_000
+
+var permittedTypes = map[string]bool{
+	"image/jpeg": true,
+	"image/png":  true,
+	"image/gif":  true,
+}
+
+type Variant struct {
+	Data        []byte
+	ContentType string
+	Extension   string
+}
+
+type Processed struct {
+	Original  Variant
+	Thumbnail Variant
+}
+
+// Process sniffs the content type of the uploaded image, rejects anything
+// that isn't a JPEG, PNG or GIF, and re-encodes it into a downscaled original
+// and a square thumbnail. Re-encoding also strips metadata such as EXIF.
+func Process(r io.Reader) (*Processed, error) {
+	raw, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+
+	contentType := http.DetectContentType(raw)
+	if !permittedTypes[contentType] {
+		return nil, ErrUnsupportedType
+	}
+
+	cfg, _, err := image.DecodeConfig(bytes.NewReader(raw))
+	if err != nil || cfg.Width*cfg.Height > MaxPixels {
+		return nil, ErrInvalidImage
+	}
+
+	src, _, err := image.Decode(bytes.NewReader(raw))
+	if err != nil {
+		return nil, ErrInvalidImage
+	}
+
+	original, err := encode(fit(src, MaxDimension), contentType)
+	if err != nil {
+		return nil, err
+	}
+
+	thumbnail, err := encode(cover(src, ThumbnailDimension), contentType)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Processed{Original: *original, Thumbnail: *thumbnail}, nil
+}
+
+// fit scales src down so that neither side is longer than max, keeping the aspect ratio.
+func fit(src image.Image, max int) image.Image {
+	b := src.Bounds()
+	w, h := b.Dx(), b.Dy()
+
+	if w <= max && h <= max {
+		return src
+	}
+
+	if w >= h {
+		h = h * max / w
+		w = max
+	} else {
+		w = w * max / h
+		h = max
+	}
+
+	dst := image.NewRGBA(image.Rect(0, 0, max1(w), max1(h)))
+	draw.CatmullRom.Scale(dst, dst.Bounds(), src, b, draw.Over, nil)
+
+	return dst
+}
+
+// cover crops the centre square of src and scales it to size x size.
+func cover(src image.Image, size int) image.Image {
+	b := src.Bounds()
+	side := min(b.Dx(), b.Dy())
+
+	x0 := b.Min.X + (b.Dx()-side)/2
+	y0 := b.Min.Y + (b.Dy()-side)/2
+	crop := image.Rect(x0, y0, x0+side, y0+side)
+
+	dst := image.NewRGBA(image.Rect(0, 0, size, size))
+	draw.CatmullRom.Scale(dst, dst.Bounds(), src, crop, draw.Over, nil)
+
+	return dst
+}
+
+func encode(img image.Image, contentType string) (*Variant, error) {
+	buf := new(bytes.Buffer)
+
+	switch contentType {
+	case "image/jpeg":
+		err := jpeg.Encode(buf, img, &jpeg.Options{Quality: 85})
+		if err != nil {
+			return nil, err
+		}
+		return &Variant{Data: buf.Bytes(), ContentType: "image/jpeg", Extension: ".jpg"}, nil
+	default:
+		err := png.Encode(buf, img)
+		if err != nil {
+			return nil, err
+		}
+		return &Variant{Data: buf.Bytes(), ContentType: "image/png", Extension: ".png"}, nil
+	}
+}
+
+func max1(n int) int {
+	if n < 1 {
+		return 1
+	}
+	return n
+}
@@ -0,0 +1,124 @@
+package mailer
+
+import (
+	"bytes"
+	"embed"
+	"fmt"
+	"html/template"
+	"mime"
+	"mime/multipart"
+	"net/smtp"
+	"net/textproto"
+	"strconv"
+	"time"
+
+	ttemplate "text/template"
+)
+
+//go:embed "templates"
+var templateFS embed.FS
+
+type Mailer struct {
+	addr   string
+	auth   smtp.Auth
+	sender string
+}
+
+func New(host string, port int, username, password, sender string) Mailer {
+	var auth smtp.Auth
+	if username != "" {
+		auth = smtp.PlainAuth("", username, password, host)
+	}
+
+	return Mailer{
+		addr:   host + ":" + strconv.Itoa(port),
+		auth:   auth,
+		sender: sender,
+	}
+}
+
+func (m Mailer) Send(recipient, templateFile string, data any) error {
+	textTmpl, err := ttemplate.New("email").ParseFS(templateFS, "templates/"+templateFile)
+	if err != nil {
+		return err
+	}
+
+	subject := new(bytes.Buffer)
+	err = textTmpl.ExecuteTemplate(subject, "subject", data)
+	if err != nil {
+		return err
+	}
+
+	plainBody := new(bytes.Buffer)
+	err = textTmpl.ExecuteTemplate(plainBody, "plainBody", data)
+	if err != nil {
+		return err
+	}
+
+	htmlTmpl, err := template.New("email").ParseFS(templateFS, "templates/"+templateFile)
+	if err != nil {
+		return err
+	}
+
+	htmlBody := new(bytes.Buffer)
+	err = htmlTmpl.ExecuteTemplate(htmlBody, "htmlBody", data)
+	if err != nil {
+		return err
+	}
+
+	msg, err := m.message(recipient, subject.String(), plainBody.Bytes(), htmlBody.Bytes())
+	if err != nil {
+		return err
+	}
+
+	for i := 1; i <= 3; i++ {
+		err = smtp.SendMail(m.addr, m.auth, m.sender, []string{recipient}, msg)
+		if err == nil {
+			return nil
+		}
+
+		time.Sleep(500 * time.Millisecond)
+	}
+
+	return err
+}
+
+func (m Mailer) message(recipient, subject string, plainBody, htmlBody []byte) ([]byte, error) {
+	body := new(bytes.Buffer)
+	writer := multipart.NewWriter(body)
+
+	parts := []struct {
+		contentType string
+		content     []byte
+	}{
+		{"text/plain; charset=UTF-8", plainBody},
+		{"text/html; charset=UTF-8", htmlBody},
+	}
+
+	for _, part := range parts {
+		w, err := writer.CreatePart(textproto.MIMEHeader{"Content-Type": {part.contentType}})
+		if err != nil {
+			return nil, err
+		}
+
+		_, err = w.Write(part.content)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	err := writer.Close()
+	if err != nil {
+		return nil, err
+	}
+
+	msg := new(bytes.Buffer)
+	fmt.Fprintf(msg, "From: %s\r\n", m.sender)
+	fmt.Fprintf(msg, "To: %s\r\n", recipient)
+	fmt.Fprintf(msg, "Subject: %s\r\n", mime.QEncoding.Encode("UTF-8", subject))
+	fmt.Fprintf(msg, "MIME-Version: 1.0\r\n")
+	fmt.Fprintf(msg, "Content-Type: multipart/alternative; boundary=%s\r\n\r\n", writer.Boundary())
+	msg.Write(body.Bytes())
+
+	return msg.Bytes(), nil
+}
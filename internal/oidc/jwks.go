@@ -0,0 +1,140 @@
+package oidc
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/base64"
+	"fmt"
+	"math/big"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+type jwk struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+type jwks struct {
+	Keys []jwk `json:"keys"`
+}
+
+// keySet holds the public keys of a provider. Keys without an ID are kept
+// apart, a token without a key ID is checked against each of them.
+type keySet struct {
+	byID      map[string]any
+	anonymous []any
+}
+
+// lookup returns the key with the given ID, or for an empty ID the keys
+// without one as a jwt.VerificationKeySet. A set with a single key doesn't
+// need key IDs at all. Nothing is found in a nil set.
+func (s *keySet) lookup(kid string) (any, bool) {
+	if s == nil {
+		return nil, false
+	}
+
+	if kid != "" {
+		key, ok := s.byID[kid]
+		return key, ok
+	}
+
+	switch {
+	case len(s.anonymous) == 1:
+		return s.anonymous[0], true
+	case len(s.anonymous) > 1:
+		set := jwt.VerificationKeySet{}
+		for _, key := range s.anonymous {
+			set.Keys = append(set.Keys, key)
+		}
+		return set, true
+	case len(s.byID) == 1:
+		for _, key := range s.byID {
+			return key, true
+		}
+	}
+
+	return nil, false
+}
+
+func (s *keySet) add(kid string, key any) {
+	if kid == "" {
+		s.anonymous = append(s.anonymous, key)
+		return
+	}
+
+	s.byID[kid] = key
+}
+
+// parse converts the RSA and EC signing keys of the set into public keys.
+// Keys of other types or meant for encryption are skipped.
+func (s jwks) parse() (*keySet, error) {
+	keys := &keySet{byID: make(map[string]any)}
+
+	for _, k := range s.Keys {
+		if k.Use != "" && k.Use != "sig" {
+			continue
+		}
+
+		switch k.Kty {
+		case "RSA":
+			n, err := decodeBigInt(k.N)
+			if err != nil {
+				return nil, err
+			}
+
+			e, err := decodeBigInt(k.E)
+			if err != nil {
+				return nil, err
+			}
+
+			keys.add(k.Kid, &rsa.PublicKey{N: n, E: int(e.Int64())})
+		case "EC":
+			var curve elliptic.Curve
+			switch k.Crv {
+			case "P-256":
+				curve = elliptic.P256()
+			case "P-384":
+				curve = elliptic.P384()
+			case "P-521":
+				curve = elliptic.P521()
+			default:
+				continue
+			}
+
+			x, err := decodeBigInt(k.X)
+			if err != nil {
+				return nil, err
+			}
+
+			y, err := decodeBigInt(k.Y)
+			if err != nil {
+				return nil, err
+			}
+
+			keys.add(k.Kid, &ecdsa.PublicKey{Curve: curve, X: x, Y: y})
+		}
+	}
+
+	if len(keys.byID) == 0 && len(keys.anonymous) == 0 {
+		return nil, fmt.Errorf("oidc: key set contains no usable signing keys")
+	}
+
+	return keys, nil
+}
+
+func decodeBigInt(s string) (*big.Int, error) {
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, fmt.Errorf("oidc: malformed key: %w", err)
+	}
+
+	return new(big.Int).SetBytes(b), nil
+}
@@ -0,0 +1,301 @@
+// Package oidc implements the parts of OpenID Connect the API needs to let
+// users sign in through an external identity provider: discovery, the
+// authorization code flow with PKCE and ID token verification.
+package oidc
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+var (
+	ErrInvalidIDToken = errors.New("invalid ID token")
+)
+
+type Config struct {
+	Name         string   `json:"name"`
+	Issuer       string   `json:"issuer"`
+	ClientID     string   `json:"client_id"`
+	ClientSecret string   `json:"client_secret"`
+	RedirectURL  string   `json:"redirect_url"`
+	Scopes       []string `json:"scopes"`
+}
+
+type discovery struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	JWKSURI               string `json:"jwks_uri"`
+}
+
+// Claims are the ID token claims used to find or create the local user.
+type Claims struct {
+	Subject       string `json:"sub"`
+	Email         string `json:"email"`
+	EmailVerified bool   `json:"email_verified"`
+	Name          string `json:"name"`
+	Nonce         string `json:"nonce"`
+	jwt.RegisteredClaims
+}
+
+type Provider struct {
+	config Config
+	client *http.Client
+
+	mu        sync.Mutex
+	discovery *discovery
+	keys      *keySet
+	keysAt    time.Time
+}
+
+func NewProvider(config Config) *Provider {
+	if len(config.Scopes) == 0 {
+		config.Scopes = []string{"openid", "email", "profile"}
+	}
+
+	return &Provider{
+		config: config,
+		client: &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+func (p *Provider) Name() string {
+	return p.config.Name
+}
+
+// GenerateVerifier returns a random PKCE code verifier.
+func GenerateVerifier() (string, error) {
+	return randomString(32)
+}
+
+// GenerateState returns a random value usable as the state or nonce parameter.
+func GenerateState() (string, error) {
+	return randomString(24)
+}
+
+func randomString(n int) (string, error) {
+	b := make([]byte, n)
+
+	_, err := rand.Read(b)
+	if err != nil {
+		return "", err
+	}
+
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+func challenge(verifier string) string {
+	sum := sha256.Sum256([]byte(verifier))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+// AuthCodeURL returns the URL the user agent is sent to in order to sign in at the provider.
+func (p *Provider) AuthCodeURL(ctx context.Context, state, nonce, verifier string) (string, error) {
+	d, err := p.discover(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	params := url.Values{}
+	params.Set("response_type", "code")
+	params.Set("client_id", p.config.ClientID)
+	params.Set("redirect_uri", p.config.RedirectURL)
+	params.Set("scope", strings.Join(p.config.Scopes, " "))
+	params.Set("state", state)
+	params.Set("nonce", nonce)
+	params.Set("code_challenge", challenge(verifier))
+	params.Set("code_challenge_method", "S256")
+
+	sep := "?"
+	if strings.Contains(d.AuthorizationEndpoint, "?") {
+		sep = "&"
+	}
+
+	return d.AuthorizationEndpoint + sep + params.Encode(), nil
+}
+
+// Exchange trades the authorization code for tokens and returns the verified
+// claims of the ID token.
+func (p *Provider) Exchange(ctx context.Context, code, verifier, nonce string) (*Claims, error) {
+	d, err := p.discover(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	form := url.Values{}
+	form.Set("grant_type", "authorization_code")
+	form.Set("code", code)
+	form.Set("redirect_uri", p.config.RedirectURL)
+	form.Set("code_verifier", verifier)
+	form.Set("client_id", p.config.ClientID)
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, d.TokenEndpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, err
+	}
+
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	if p.config.ClientSecret != "" {
+		req.SetBasicAuth(url.QueryEscape(p.config.ClientID), url.QueryEscape(p.config.ClientSecret))
+	}
+
+	var tokens struct {
+		IDToken string `json:"id_token"`
+	}
+
+	err = p.do(req, &tokens)
+	if err != nil {
+		return nil, err
+	}
+
+	if tokens.IDToken == "" {
+		return nil, ErrInvalidIDToken
+	}
+
+	return p.verify(ctx, tokens.IDToken, nonce)
+}
+
+func (p *Provider) verify(ctx context.Context, rawIDToken, nonce string) (*Claims, error) {
+	d, err := p.discover(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	claims := &Claims{}
+
+	_, err = jwt.ParseWithClaims(rawIDToken, claims, func(token *jwt.Token) (any, error) {
+		kid, _ := token.Header["kid"].(string)
+		return p.key(ctx, kid)
+	},
+		jwt.WithValidMethods([]string{"RS256", "RS384", "RS512", "ES256", "ES384", "ES512"}),
+		jwt.WithIssuer(d.Issuer),
+		jwt.WithAudience(p.config.ClientID),
+		jwt.WithExpirationRequired(),
+		jwt.WithLeeway(time.Minute),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidIDToken, err)
+	}
+
+	if claims.Subject == "" || claims.Nonce != nonce {
+		return nil, ErrInvalidIDToken
+	}
+
+	return claims, nil
+}
+
+func (p *Provider) discover(ctx context.Context) (*discovery, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.discovery != nil {
+		return p.discovery, nil
+	}
+
+	wellKnown := strings.TrimSuffix(p.config.Issuer, "/") + "/.well-known/openid-configuration"
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, wellKnown, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	var d discovery
+
+	err = p.do(req, &d)
+	if err != nil {
+		return nil, err
+	}
+
+	if strings.TrimSuffix(d.Issuer, "/") != strings.TrimSuffix(p.config.Issuer, "/") {
+		return nil, fmt.Errorf("oidc: issuer %q in discovery document does not match %q", d.Issuer, p.config.Issuer)
+	}
+
+	p.discovery = &d
+
+	return p.discovery, nil
+}
+
+// key returns the verification key with the given ID. The key set is
+// refetched when an unknown key ID shows up, since providers rotate keys,
+// but at most once a minute.
+func (p *Provider) key(ctx context.Context, kid string) (any, error) {
+	p.mu.Lock()
+	key, ok := p.keys.lookup(kid)
+	stale := time.Since(p.keysAt) > time.Minute
+	p.mu.Unlock()
+
+	if ok {
+		return key, nil
+	}
+
+	if !stale {
+		return nil, fmt.Errorf("oidc: unknown key %q", kid)
+	}
+
+	d, err := p.discover(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, d.JWKSURI, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	var set jwks
+
+	err = p.do(req, &set)
+	if err != nil {
+		return nil, err
+	}
+
+	keys, err := set.parse()
+	if err != nil {
+		return nil, err
+	}
+
+	p.mu.Lock()
+	p.keys = keys
+	p.keysAt = time.Now()
+	p.mu.Unlock()
+
+	key, ok = keys.lookup(kid)
+	if !ok {
+		return nil, fmt.Errorf("oidc: unknown key %q", kid)
+	}
+
+	return key, nil
+}
+
+func (p *Provider) do(req *http.Request, dst any) error {
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return err
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("oidc: %s %s: %s: %s", req.Method, req.URL, resp.Status, body)
+	}
+
+	return json.Unmarshal(body, dst)
+}
@@ -0,0 +1,267 @@
+package oidc
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// stubProvider is an identity provider serving discovery, a key set and a
+// token endpoint that hands out a fixed ID token.
+type stubProvider struct {
+	*httptest.Server
+	keys    []jwk
+	idToken string
+	form    url.Values
+}
+
+func newStubProvider(t *testing.T) *stubProvider {
+	t.Helper()
+
+	s := &stubProvider{}
+
+	mux := http.NewServeMux()
+
+	mux.HandleFunc("GET /.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(discovery{
+			Issuer:                s.URL,
+			AuthorizationEndpoint: s.URL + "/authorize",
+			TokenEndpoint:         s.URL + "/token",
+			JWKSURI:               s.URL + "/jwks",
+		})
+	})
+
+	mux.HandleFunc("GET /jwks", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(jwks{Keys: s.keys})
+	})
+
+	mux.HandleFunc("POST /token", func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		s.form = r.PostForm
+		json.NewEncoder(w).Encode(map[string]string{"id_token": s.idToken})
+	})
+
+	s.Server = httptest.NewServer(mux)
+	t.Cleanup(s.Close)
+
+	return s
+}
+
+func (s *stubProvider) provider() *Provider {
+	return NewProvider(Config{Name: "stub", Issuer: s.URL, ClientID: "client", RedirectURL: "https://app.example/callback"})
+}
+
+func newKey(t *testing.T) *rsa.PrivateKey {
+	t.Helper()
+
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return key
+}
+
+func publicJWK(key *rsa.PrivateKey, kid string) jwk {
+	return jwk{
+		Kty: "RSA",
+		Kid: kid,
+		Use: "sig",
+		N:   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+		E:   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+	}
+}
+
+func sign(t *testing.T, key *rsa.PrivateKey, kid string, claims *Claims) string {
+	t.Helper()
+
+	token := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
+	if kid != "" {
+		token.Header["kid"] = kid
+	}
+
+	signed, err := token.SignedString(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return signed
+}
+
+func TestExchange(t *testing.T) {
+	key1, key2, other := newKey(t), newKey(t), newKey(t)
+
+	claims := func(issuer string) *Claims {
+		return &Claims{
+			Subject:       "subject-1",
+			Email:         "user@example.com",
+			EmailVerified: true,
+			Nonce:         "nonce",
+			RegisteredClaims: jwt.RegisteredClaims{
+				Issuer:    issuer,
+				Audience:  jwt.ClaimStrings{"client"},
+				ExpiresAt: jwt.NewNumericDate(time.Now().Add(time.Hour)),
+			},
+		}
+	}
+
+	tests := []struct {
+		name    string
+		keys    []jwk
+		key     *rsa.PrivateKey
+		kid     string
+		modify  func(c *Claims)
+		nonce   string
+		wantErr bool
+	}{
+		{
+			name:  "key with ID",
+			keys:  []jwk{publicJWK(key1, "k1"), publicJWK(key2, "k2")},
+			key:   key2,
+			kid:   "k2",
+			nonce: "nonce",
+		},
+		{
+			name:  "single key without ID",
+			keys:  []jwk{publicJWK(key1, "")},
+			key:   key1,
+			nonce: "nonce",
+		},
+		{
+			name:  "several keys without ID",
+			keys:  []jwk{publicJWK(key1, ""), publicJWK(key2, "")},
+			key:   key2,
+			nonce: "nonce",
+		},
+		{
+			name:    "unknown key ID",
+			keys:    []jwk{publicJWK(key1, "k1")},
+			key:     key1,
+			kid:     "k2",
+			nonce:   "nonce",
+			wantErr: true,
+		},
+		{
+			name:    "key not in the set",
+			keys:    []jwk{publicJWK(key1, ""), publicJWK(key2, "")},
+			key:     other,
+			nonce:   "nonce",
+			wantErr: true,
+		},
+		{
+			name:    "wrong nonce",
+			keys:    []jwk{publicJWK(key1, "k1")},
+			key:     key1,
+			kid:     "k1",
+			nonce:   "another nonce",
+			wantErr: true,
+		},
+		{
+			name:    "wrong audience",
+			keys:    []jwk{publicJWK(key1, "k1")},
+			key:     key1,
+			kid:     "k1",
+			modify:  func(c *Claims) { c.Audience = jwt.ClaimStrings{"another client"} },
+			nonce:   "nonce",
+			wantErr: true,
+		},
+		{
+			name:    "wrong issuer",
+			keys:    []jwk{publicJWK(key1, "k1")},
+			key:     key1,
+			kid:     "k1",
+			modify:  func(c *Claims) { c.Issuer = "https://evil.example" },
+			nonce:   "nonce",
+			wantErr: true,
+		},
+		{
+			name:    "expired",
+			keys:    []jwk{publicJWK(key1, "k1")},
+			key:     key1,
+			kid:     "k1",
+			modify:  func(c *Claims) { c.ExpiresAt = jwt.NewNumericDate(time.Now().Add(-time.Hour)) },
+			nonce:   "nonce",
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			stub := newStubProvider(t)
+			stub.keys = tt.keys
+
+			c := claims(stub.URL)
+			if tt.modify != nil {
+				tt.modify(c)
+			}
+			stub.idToken = sign(t, tt.key, tt.kid, c)
+
+			got, err := stub.provider().Exchange(context.Background(), "code", "verifier", tt.nonce)
+
+			if tt.wantErr {
+				if !errors.Is(err, ErrInvalidIDToken) {
+					t.Fatalf("got error %v, want ErrInvalidIDToken", err)
+				}
+				return
+			}
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if got.Subject != "subject-1" || got.Email != "user@example.com" || !got.EmailVerified {
+				t.Errorf("got claims %+v", got)
+			}
+
+			if stub.form.Get("code") != "code" || stub.form.Get("code_verifier") != "verifier" {
+				t.Errorf("got token request %v", stub.form)
+			}
+		})
+	}
+}
+
+func TestAuthCodeURL(t *testing.T) {
+	stub := newStubProvider(t)
+
+	raw, err := stub.provider().AuthCodeURL(context.Background(), "state", "nonce", "verifier")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	u, err := url.Parse(raw)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if u.Path != "/authorize" {
+		t.Errorf("got path %q", u.Path)
+	}
+
+	q := u.Query()
+
+	want := map[string]string{
+		"response_type":         "code",
+		"client_id":             "client",
+		"state":                 "state",
+		"nonce":                 "nonce",
+		"code_challenge":        challenge("verifier"),
+		"code_challenge_method": "S256",
+	}
+
+	for k, v := range want {
+		if q.Get(k) != v {
+			t.Errorf("got %s %q, want %q", k, q.Get(k), v)
+		}
+	}
+}
@@ -0,0 +1,113 @@
+// Package prodcal reads the Russian production calendar in the XML format
+// published by xmlcalendar.ru, which lists the public holidays, the
+// shortened pre-holiday days and the weekends moved to working days of a
+// year:
+//
+//	<calendar year="2025">
+//		<holidays><holiday id="1" title="Новогодние каникулы"/></holidays>
+//		<days><day d="01.01" t="1" h="1"/><day d="11.01" t="3" f="01.04"/></days>
+//	</calendar>
+//
+// Regular weekends are not listed and are left to the weekly opening hours
+// of each pool.
+package prodcal
+
+import (
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/obrikash/swimming_pool/internal/data"
+)
+
+var ErrInvalidCalendar = errors.New("prodcal: invalid calendar file")
+
+type calendar struct {
+	Year     int `xml:"year,attr"`
+	Holidays []struct {
+		ID    string `xml:"id,attr"`
+		Title string `xml:"title,attr"`
+	} `xml:"holidays>holiday"`
+	Days []struct {
+		Date    string `xml:"d,attr"`
+		Type    int    `xml:"t,attr"`
+		Holiday string `xml:"h,attr"`
+		From    string `xml:"f,attr"`
+	} `xml:"days>day"`
+}
+
+// kinds maps the day types of the file to calendar day kinds.
+var kinds = map[int]string{
+	1: data.CalendarHoliday,
+	2: data.CalendarShortDay,
+	3: data.CalendarWorkingDay,
+}
+
+// Parse reads a calendar file and returns its year and public days.
+func Parse(r io.Reader) (int, []*data.CalendarDay, error) {
+	var c calendar
+
+	err := xml.NewDecoder(r).Decode(&c)
+	if err != nil {
+		return 0, nil, fmt.Errorf("%w: %v", ErrInvalidCalendar, err)
+	}
+
+	if c.Year < 2000 || c.Year > 2100 {
+		return 0, nil, fmt.Errorf("%w: year %d", ErrInvalidCalendar, c.Year)
+	}
+
+	titles := make(map[string]string, len(c.Holidays))
+	for _, h := range c.Holidays {
+		titles[h.ID] = h.Title
+	}
+
+	days := make([]*data.CalendarDay, 0, len(c.Days))
+	seen := make(map[data.Date]bool, len(c.Days))
+
+	for _, d := range c.Days {
+		kind, ok := kinds[d.Type]
+		if !ok {
+			return 0, nil, fmt.Errorf("%w: day %s has unknown type %d", ErrInvalidCalendar, d.Date, d.Type)
+		}
+
+		date, err := parseDay(c.Year, d.Date)
+		if err != nil {
+			return 0, nil, err
+		}
+
+		if seen[date] {
+			return 0, nil, fmt.Errorf("%w: day %s is listed twice", ErrInvalidCalendar, d.Date)
+		}
+		seen[date] = true
+
+		note := titles[d.Holiday]
+		if note == "" && d.From != "" {
+			from, err := parseDay(c.Year, d.From)
+			if err != nil {
+				return 0, nil, err
+			}
+			note = fmt.Sprintf("moved from %s", from)
+		}
+
+		days = append(days, &data.CalendarDay{Date: date, Kind: kind, Note: note})
+	}
+
+	return c.Year, days, nil
+}
+
+// parseDay reads a day of the year in MM.DD format.
+func parseDay(year int, s string) (data.Date, error) {
+	t, err := time.Parse("01.02", s)
+	if err != nil {
+		return data.Date{}, fmt.Errorf("%w: day %q", ErrInvalidCalendar, s)
+	}
+
+	date := data.NewDate(year, t.Month(), t.Day())
+	if date.Month() != t.Month() {
+		return data.Date{}, fmt.Errorf("%w: day %q is not in %d", ErrInvalidCalendar, s, year)
+	}
+
+	return date, nil
+}
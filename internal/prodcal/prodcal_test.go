@@ -0,0 +1,145 @@
+package prodcal
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/obrikash/swimming_pool/internal/data"
+)
+
+func TestParse(t *testing.T) {
+	input := `<?xml version="1.0" encoding="UTF-8"?>
+<calendar year="2025" lang="ru">
+	<holidays>
+		<holiday id="1" title="Новогодние каникулы"/>
+		<holiday id="3" title="День защитника Отечества"/>
+	</holidays>
+	<days>
+		<day d="01.01" t="1" h="1"/>
+		<day d="02.22" t="2"/>
+		<day d="02.23" t="1" h="3"/>
+		<day d="11.01" t="3" f="01.04"/>
+	</days>
+</calendar>`
+
+	year, days, err := Parse(strings.NewReader(input))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if year != 2025 {
+		t.Errorf("got year %d, want 2025", year)
+	}
+
+	want := []data.CalendarDay{
+		{Date: data.NewDate(2025, time.January, 1), Kind: data.CalendarHoliday, Note: "Новогодние каникулы"},
+		{Date: data.NewDate(2025, time.February, 22), Kind: data.CalendarShortDay},
+		{Date: data.NewDate(2025, time.February, 23), Kind: data.CalendarHoliday, Note: "День защитника Отечества"},
+		{Date: data.NewDate(2025, time.November, 1), Kind: data.CalendarWorkingDay, Note: "moved from 2025-01-04"},
+	}
+
+	if len(days) != len(want) {
+		t.Fatalf("got %d days, want %d", len(days), len(want))
+	}
+
+	for i, day := range days {
+		if day.Date != want[i].Date || day.Kind != want[i].Kind || day.Note != want[i].Note {
+			t.Errorf("got day %s %q %q, want %s %q %q", day.Date, day.Kind, day.Note, want[i].Date, want[i].Kind, want[i].Note)
+		}
+	}
+}
+
+func TestParseInvalid(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+	}{
+		{
+			name:  "not XML",
+			input: `calendar`,
+		},
+		{
+			name:  "missing year",
+			input: `<calendar><days><day d="01.01" t="1"/></days></calendar>`,
+		},
+		{
+			name:  "year out of range",
+			input: `<calendar year="1999"><days><day d="01.01" t="1"/></days></calendar>`,
+		},
+		{
+			name:  "unknown type",
+			input: `<calendar year="2025"><days><day d="01.01" t="4"/></days></calendar>`,
+		},
+		{
+			name:  "missing type",
+			input: `<calendar year="2025"><days><day d="01.01"/></days></calendar>`,
+		},
+		{
+			name:  "duplicate day",
+			input: `<calendar year="2025"><days><day d="01.01" t="1"/><day d="01.01" t="2"/></days></calendar>`,
+		},
+		{
+			name:  "day not in the year",
+			input: `<calendar year="2025"><days><day d="02.29" t="1"/></days></calendar>`,
+		},
+		{
+			name:  "malformed day",
+			input: `<calendar year="2025"><days><day d="2025-01-01" t="1"/></days></calendar>`,
+		},
+		{
+			name:  "moved from a day not in the year",
+			input: `<calendar year="2025"><days><day d="11.01" t="3" f="02.30"/></days></calendar>`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, _, err := Parse(strings.NewReader(tt.input))
+			if !errors.Is(err, ErrInvalidCalendar) {
+				t.Errorf("got error %v, want ErrInvalidCalendar", err)
+			}
+		})
+	}
+}
+
+func TestParseDay(t *testing.T) {
+	tests := []struct {
+		year    int
+		s       string
+		want    data.Date
+		wantErr bool
+	}{
+		{year: 2025, s: "01.01", want: data.NewDate(2025, time.January, 1)},
+		{year: 2025, s: "12.31", want: data.NewDate(2025, time.December, 31)},
+		{year: 2024, s: "02.29", want: data.NewDate(2024, time.February, 29)},
+		{year: 2025, s: "02.29", wantErr: true},
+		{year: 2025, s: "04.31", wantErr: true},
+		{year: 2025, s: "13.01", wantErr: true},
+		{year: 2025, s: "1.1", wantErr: true},
+		{year: 2025, s: "", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(fmt.Sprintf("%d %q", tt.year, tt.s), func(t *testing.T) {
+			got, err := parseDay(tt.year, tt.s)
+
+			if tt.wantErr {
+				if !errors.Is(err, ErrInvalidCalendar) {
+					t.Errorf("got %s, %v, want ErrInvalidCalendar", got, err)
+				}
+				return
+			}
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if got != tt.want {
+				t.Errorf("got %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
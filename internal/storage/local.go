@@ -0,0 +1,109 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"io"
+	"io/fs"
+	"mime"
+	"os"
+	"path/filepath"
+)
+
+type Local struct {
+	root string
+}
+
+func NewLocal(root string) (*Local, error) {
+	err := os.MkdirAll(root, 0o755)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Local{root: root}, nil
+}
+
+func (l *Local) path(key string) (string, error) {
+	clean, err := validKey(key)
+	if err != nil {
+		return "", err
+	}
+
+	return filepath.Join(l.root, filepath.FromSlash(clean)), nil
+}
+
+func (l *Local) Put(ctx context.Context, key string, body io.Reader, contentType string) error {
+	p, err := l.path(key)
+	if err != nil {
+		return err
+	}
+
+	err = os.MkdirAll(filepath.Dir(p), 0o755)
+	if err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(p), ".upload-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	_, err = io.Copy(tmp, body)
+	if err != nil {
+		tmp.Close()
+		return err
+	}
+
+	err = tmp.Close()
+	if err != nil {
+		return err
+	}
+
+	return os.Rename(tmp.Name(), p)
+}
+
+func (l *Local) Get(ctx context.Context, key string) (io.ReadCloser, *Object, error) {
+	p, err := l.path(key)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	f, err := os.Open(p)
+	if err != nil {
+		switch {
+		case errors.Is(err, fs.ErrNotExist):
+			return nil, nil, ErrNotFound
+		default:
+			return nil, nil, err
+		}
+	}
+
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, nil, err
+	}
+
+	object := &Object{
+		ContentType: mime.TypeByExtension(filepath.Ext(p)),
+		Size:        info.Size(),
+		ModTime:     info.ModTime(),
+	}
+
+	return f, object, nil
+}
+
+func (l *Local) Delete(ctx context.Context, key string) error {
+	p, err := l.path(key)
+	if err != nil {
+		return err
+	}
+
+	err = os.Remove(p)
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+
+	return nil
+}
@@ -0,0 +1,40 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"io"
+	"path"
+	"strings"
+	"time"
+)
+
+var (
+	ErrNotFound = errors.New("object not found")
+)
+
+type Object struct {
+	ContentType string
+	Size        int64
+	ModTime     time.Time
+}
+
+// Storage is implemented by every backend uploaded files can be kept in.
+// Keys are slash separated paths such as "avatars/12/3f9c.jpg".
+type Storage interface {
+	Put(ctx context.Context, key string, body io.Reader, contentType string) error
+	Get(ctx context.Context, key string) (io.ReadCloser, *Object, error)
+	Delete(ctx context.Context, key string) error
+}
+
+// validKey returns the key without a leading slash. Keys that are empty or
+// contain ".." are refused with ErrNotFound so that no backend can be made
+// to reach outside of its root.
+func validKey(key string) (string, error) {
+	clean := path.Clean("/" + key)
+	if clean == "/" || strings.Contains(key, "..") {
+		return "", ErrNotFound
+	}
+
+	return clean[1:], nil
+}
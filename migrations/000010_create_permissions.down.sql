@@ -0,0 +1,2 @@
+DROP TABLE IF EXISTS roles_permissions;
+DROP TABLE IF EXISTS permissions;
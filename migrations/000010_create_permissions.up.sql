@@ -0,0 +1,29 @@
+CREATE TABLE IF NOT EXISTS permissions (
+    id SERIAL PRIMARY KEY,
+    code TEXT NOT NULL UNIQUE
+);
+
+CREATE TABLE IF NOT EXISTS roles_permissions (
+    role_id INT NOT NULL REFERENCES roles(id) ON DELETE CASCADE,
+    permission_id INT NOT NULL REFERENCES permissions(id) ON DELETE CASCADE,
+    PRIMARY KEY (role_id, permission_id)
+);
+
+INSERT INTO permissions (code) VALUES
+('pools:read'), ('trainers:read'), ('trainers:write'), ('groups:read'), ('groups:write'),
+('subscriptions:read'), ('reports:read');
+
+-- тренер
+INSERT INTO roles_permissions (role_id, permission_id)
+SELECT r.id, p.id FROM roles r, permissions p
+WHERE r.name = 'тренер' AND p.code IN ('pools:read', 'trainers:read', 'groups:read', 'groups:write', 'subscriptions:read');
+
+-- клиент
+INSERT INTO roles_permissions (role_id, permission_id)
+SELECT r.id, p.id FROM roles r, permissions p
+WHERE r.name = 'клиент' AND p.code IN ('pools:read', 'trainers:read', 'groups:read', 'subscriptions:read');
+
+-- админ
+INSERT INTO roles_permissions (role_id, permission_id)
+SELECT r.id, p.id FROM roles r, permissions p
+WHERE r.name = 'админ';
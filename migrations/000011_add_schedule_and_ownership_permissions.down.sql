@@ -0,0 +1 @@
+DELETE FROM permissions WHERE code IN ('schedules:read', 'schedules:write', 'ownership:bypass');
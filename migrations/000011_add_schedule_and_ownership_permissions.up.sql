@@ -0,0 +1,14 @@
+INSERT INTO permissions (code) VALUES ('schedules:read'), ('schedules:write'), ('ownership:bypass');
+
+INSERT INTO roles_permissions (role_id, permission_id)
+SELECT r.id, p.id FROM roles r, permissions p
+WHERE r.name IN ('тренер', 'клиент', 'админ') AND p.code = 'schedules:read';
+
+INSERT INTO roles_permissions (role_id, permission_id)
+SELECT r.id, p.id FROM roles r, permissions p
+WHERE r.name IN ('тренер', 'админ') AND p.code = 'schedules:write';
+
+-- admins are not limited to the groups and pools they own
+INSERT INTO roles_permissions (role_id, permission_id)
+SELECT r.id, p.id FROM roles r, permissions p
+WHERE r.name = 'админ' AND p.code = 'ownership:bypass';
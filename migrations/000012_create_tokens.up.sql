@@ -0,0 +1,8 @@
+CREATE TABLE IF NOT EXISTS tokens (
+    hash BYTEA PRIMARY KEY,
+    user_id INT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    expiry TIMESTAMP(0) WITH TIME ZONE NOT NULL,
+    scope TEXT NOT NULL
+);
+
+ALTER TABLE users ADD COLUMN pending_email TEXT;
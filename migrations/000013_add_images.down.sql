@@ -0,0 +1,7 @@
+DELETE FROM permissions WHERE code = 'pools:write';
+
+ALTER TABLE pools DROP COLUMN IF EXISTS thumbnail;
+ALTER TABLE pools DROP COLUMN IF EXISTS image;
+
+ALTER TABLE users DROP COLUMN IF EXISTS thumbnail;
+ALTER TABLE users ALTER COLUMN image DROP DEFAULT;
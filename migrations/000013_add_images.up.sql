@@ -0,0 +1,11 @@
+ALTER TABLE users ALTER COLUMN image SET DEFAULT '';
+ALTER TABLE users ADD COLUMN thumbnail TEXT NOT NULL DEFAULT '';
+
+ALTER TABLE pools ADD COLUMN image TEXT NOT NULL DEFAULT '';
+ALTER TABLE pools ADD COLUMN thumbnail TEXT NOT NULL DEFAULT '';
+
+INSERT INTO permissions (code) VALUES ('pools:write');
+
+INSERT INTO roles_permissions (role_id, permission_id)
+SELECT r.id, p.id FROM roles r, permissions p
+WHERE r.name = 'админ' AND p.code = 'pools:write';
@@ -0,0 +1,7 @@
+ALTER TABLE users ADD COLUMN active BOOLEAN NOT NULL DEFAULT true;
+
+INSERT INTO permissions (code) VALUES ('users:read'), ('users:write');
+
+INSERT INTO roles_permissions (role_id, permission_id)
+SELECT r.id, p.id FROM roles r, permissions p
+WHERE r.name = 'админ' AND p.code IN ('users:read', 'users:write');
@@ -0,0 +1,19 @@
+CREATE TABLE IF NOT EXISTS login_failures (
+    user_id INT PRIMARY KEY REFERENCES users(id) ON DELETE CASCADE,
+    failed_attempts INT NOT NULL DEFAULT 0,
+    last_failed_at TIMESTAMP(0) WITH TIME ZONE NOT NULL DEFAULT NOW(),
+    locked_until TIMESTAMP(0) WITH TIME ZONE
+);
+
+CREATE TABLE IF NOT EXISTS lockout_events (
+    id BIGSERIAL PRIMARY KEY,
+    user_id INT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    created_at TIMESTAMP(0) WITH TIME ZONE NOT NULL DEFAULT NOW(),
+    ip TEXT NOT NULL,
+    failed_attempts INT NOT NULL,
+    locked_until TIMESTAMP(0) WITH TIME ZONE NOT NULL,
+    unlocked_at TIMESTAMP(0) WITH TIME ZONE,
+    unlocked_by INT REFERENCES users(id) ON DELETE SET NULL
+);
+
+CREATE INDEX IF NOT EXISTS lockout_events_user_id_idx ON lockout_events (user_id);
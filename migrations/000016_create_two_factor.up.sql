@@ -0,0 +1,16 @@
+CREATE TABLE IF NOT EXISTS users_totp (
+    user_id INT PRIMARY KEY REFERENCES users(id) ON DELETE CASCADE,
+    secret TEXT NOT NULL,
+    created_at TIMESTAMP(0) WITH TIME ZONE NOT NULL DEFAULT NOW(),
+    confirmed_at TIMESTAMP(0) WITH TIME ZONE,
+    last_used_step BIGINT NOT NULL DEFAULT 0
+);
+
+CREATE TABLE IF NOT EXISTS recovery_codes (
+    id BIGSERIAL PRIMARY KEY,
+    user_id INT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    hash BYTEA NOT NULL,
+    used_at TIMESTAMP(0) WITH TIME ZONE
+);
+
+CREATE INDEX IF NOT EXISTS recovery_codes_user_id_idx ON recovery_codes (user_id);
@@ -0,0 +1,20 @@
+CREATE TABLE IF NOT EXISTS api_keys (
+    id BIGSERIAL PRIMARY KEY,
+    name TEXT NOT NULL,
+    prefix TEXT NOT NULL,
+    hash BYTEA NOT NULL UNIQUE,
+    scopes TEXT[] NOT NULL,
+    allowed_ips TEXT[] NOT NULL DEFAULT '{}',
+    created_by INT REFERENCES users(id) ON DELETE SET NULL,
+    created_at TIMESTAMP(0) WITH TIME ZONE NOT NULL DEFAULT NOW(),
+    expires_at TIMESTAMP(0) WITH TIME ZONE,
+    last_used_at TIMESTAMP(0) WITH TIME ZONE,
+    last_used_ip TEXT,
+    revoked_at TIMESTAMP(0) WITH TIME ZONE
+);
+
+INSERT INTO permissions (code) VALUES ('api_keys:read'), ('api_keys:write'), ('checkin:write');
+
+INSERT INTO roles_permissions (role_id, permission_id)
+SELECT r.id, p.id FROM roles r, permissions p
+WHERE r.name = 'админ' AND p.code IN ('api_keys:read', 'api_keys:write', 'checkin:write');
@@ -0,0 +1,13 @@
+CREATE TABLE IF NOT EXISTS sessions (
+    id BIGSERIAL PRIMARY KEY,
+    user_id INT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    created_at TIMESTAMP(0) WITH TIME ZONE NOT NULL DEFAULT NOW(),
+    last_seen_at TIMESTAMP(0) WITH TIME ZONE NOT NULL DEFAULT NOW(),
+    expiry TIMESTAMP(0) WITH TIME ZONE NOT NULL,
+    ip TEXT NOT NULL,
+    user_agent TEXT NOT NULL,
+    device TEXT NOT NULL,
+    revoked_at TIMESTAMP(0) WITH TIME ZONE
+);
+
+CREATE INDEX IF NOT EXISTS sessions_user_id_idx ON sessions (user_id);
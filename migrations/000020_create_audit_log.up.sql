@@ -0,0 +1,33 @@
+CREATE TABLE IF NOT EXISTS audit_log (
+    id BIGSERIAL PRIMARY KEY,
+    created_at TIMESTAMP(0) WITH TIME ZONE NOT NULL DEFAULT NOW(),
+    actor_id INT,
+    api_key_id BIGINT,
+    action TEXT NOT NULL,
+    target_type TEXT NOT NULL,
+    target_id TEXT NOT NULL,
+    changes JSONB NOT NULL DEFAULT '{}',
+    ip TEXT NOT NULL,
+    request_id TEXT NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS audit_log_created_at_idx ON audit_log (created_at);
+CREATE INDEX IF NOT EXISTS audit_log_actor_id_idx ON audit_log (actor_id);
+CREATE INDEX IF NOT EXISTS audit_log_target_idx ON audit_log (target_type, target_id);
+
+-- the log is append-only, entries can't be changed or removed afterwards
+CREATE OR REPLACE FUNCTION audit_log_append_only() RETURNS trigger AS $$
+BEGIN
+    RAISE EXCEPTION 'audit_log is append-only';
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER audit_log_append_only
+BEFORE UPDATE OR DELETE OR TRUNCATE ON audit_log
+FOR EACH STATEMENT EXECUTE FUNCTION audit_log_append_only();
+
+INSERT INTO permissions (code) VALUES ('audit:read');
+
+INSERT INTO roles_permissions (role_id, permission_id)
+SELECT r.id, p.id FROM roles r, permissions p
+WHERE r.name = 'админ' AND p.code = 'audit:read';
@@ -0,0 +1,5 @@
+ALTER TABLE user_subscriptions DROP CONSTRAINT user_subscriptions_user_id_fkey;
+ALTER TABLE user_subscriptions ADD CONSTRAINT user_subscriptions_user_id_fkey
+    FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE;
+
+ALTER TABLE users DROP COLUMN IF EXISTS deleted_at;
@@ -0,0 +1,7 @@
+ALTER TABLE users ADD COLUMN deleted_at TIMESTAMP(0) WITH TIME ZONE;
+
+-- subscriptions are revenue history and have to outlive the account, users
+-- are anonymised instead of being deleted
+ALTER TABLE user_subscriptions DROP CONSTRAINT user_subscriptions_user_id_fkey;
+ALTER TABLE user_subscriptions ADD CONSTRAINT user_subscriptions_user_id_fkey
+    FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE RESTRICT;
@@ -0,0 +1,36 @@
+-- accounts whose emails only differ in case or surrounding spaces have to be
+-- merged before the emails can be made unique regardless of case, use
+-- GET /v1/admin/email-collisions and POST /v1/admin/users/:id/merge
+DO $$
+DECLARE
+    collision RECORD;
+    collisions INT := 0;
+BEGIN
+    FOR collision IN
+        SELECT lower(trim(email)) AS email, string_agg(id::text, ', ' ORDER BY id) AS ids
+        FROM users
+        GROUP BY lower(trim(email))
+        HAVING count(*) > 1
+    LOOP
+        RAISE WARNING 'email collision: % is used by users %', collision.email, collision.ids;
+        collisions := collisions + 1;
+    END LOOP;
+
+    IF collisions > 0 THEN
+        RAISE EXCEPTION '% email collision(s) found, merge the duplicate accounts first', collisions;
+    END IF;
+END
+$$;
+
+-- the local part is kept as typed, only the domain is case-insensitive by
+-- definition
+UPDATE users SET email = split_part(trim(email), '@', 1) || '@' || lower(split_part(trim(email), '@', 2))
+WHERE email LIKE '%@%';
+
+UPDATE users SET pending_email = split_part(trim(pending_email), '@', 1) || '@' || lower(split_part(trim(pending_email), '@', 2))
+WHERE pending_email LIKE '%@%';
+
+-- the index keeps the name of the old constraint, so duplicate errors look
+-- the same to the application
+ALTER TABLE users DROP CONSTRAINT users_email_key;
+CREATE UNIQUE INDEX users_email_key ON users (lower(email));
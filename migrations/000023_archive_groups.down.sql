@@ -0,0 +1,5 @@
+DROP INDEX unique_group_per_pool_category_trainer;
+CREATE UNIQUE INDEX unique_group_per_pool_category_trainer
+ON training_groups (pool_id, category_id, trainer_id);
+
+ALTER TABLE training_groups DROP COLUMN IF EXISTS archived_at;
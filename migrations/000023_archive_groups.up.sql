@@ -0,0 +1,7 @@
+ALTER TABLE training_groups ADD COLUMN archived_at TIMESTAMP(0) WITH TIME ZONE;
+
+-- an archived group must not keep a new group with the same pool, category
+-- and trainer from being created
+DROP INDEX unique_group_per_pool_category_trainer;
+CREATE UNIQUE INDEX unique_group_per_pool_category_trainer
+ON training_groups (pool_id, category_id, trainer_id) WHERE archived_at IS NULL;
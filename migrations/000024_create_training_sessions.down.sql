@@ -0,0 +1,4 @@
+DELETE FROM permissions WHERE code IN ('attendance:read', 'attendance:write');
+
+DROP TABLE IF EXISTS attendance;
+DROP TABLE IF EXISTS training_sessions;
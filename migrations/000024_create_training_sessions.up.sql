@@ -0,0 +1,27 @@
+-- dated occurrences of a group's schedules, generated ahead of time so that
+-- attendance can be recorded against them
+CREATE TABLE IF NOT EXISTS training_sessions (
+    id BIGSERIAL PRIMARY KEY,
+    group_id INT NOT NULL REFERENCES training_groups(id),
+    schedule_id INT REFERENCES schedules(id) ON DELETE SET NULL,
+    starts_at TIMESTAMP(0) WITH TIME ZONE NOT NULL,
+    cancelled_at TIMESTAMP(0) WITH TIME ZONE,
+    UNIQUE (group_id, starts_at)
+);
+
+CREATE INDEX IF NOT EXISTS training_sessions_starts_at_idx ON training_sessions (starts_at);
+
+CREATE TABLE IF NOT EXISTS attendance (
+    session_id BIGINT NOT NULL REFERENCES training_sessions(id) ON DELETE CASCADE,
+    user_id INT NOT NULL REFERENCES users(id),
+    present BOOLEAN NOT NULL,
+    marked_by INT REFERENCES users(id) ON DELETE SET NULL,
+    marked_at TIMESTAMP(0) WITH TIME ZONE NOT NULL DEFAULT NOW(),
+    PRIMARY KEY (session_id, user_id)
+);
+
+INSERT INTO permissions (code) VALUES ('attendance:read'), ('attendance:write');
+
+INSERT INTO roles_permissions (role_id, permission_id)
+SELECT r.id, p.id FROM roles r, permissions p
+WHERE r.name IN ('тренер', 'админ') AND p.code IN ('attendance:read', 'attendance:write');
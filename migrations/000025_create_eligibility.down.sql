@@ -0,0 +1,6 @@
+DELETE FROM permissions WHERE code = 'categories:write';
+
+DROP TABLE IF EXISTS category_eligibility;
+
+ALTER TABLE users DROP COLUMN IF EXISTS skill_level;
+ALTER TABLE users DROP COLUMN IF EXISTS birthdate;
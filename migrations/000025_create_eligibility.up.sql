@@ -0,0 +1,29 @@
+ALTER TABLE users ADD COLUMN birthdate DATE;
+ALTER TABLE users ADD COLUMN skill_level SMALLINT NOT NULL DEFAULT 0 CHECK (skill_level BETWEEN 0 AND 4);
+
+-- who may enroll in the groups of a category, NULL means no limit
+CREATE TABLE IF NOT EXISTS category_eligibility (
+    category_id INT PRIMARY KEY REFERENCES group_category(id) ON DELETE CASCADE,
+    min_age SMALLINT CHECK (min_age >= 0),
+    max_age SMALLINT CHECK (max_age >= 0),
+    min_skill_level SMALLINT NOT NULL DEFAULT 0 CHECK (min_skill_level BETWEEN 0 AND 4),
+    CHECK (min_age IS NULL OR max_age IS NULL OR min_age <= max_age)
+);
+
+INSERT INTO category_eligibility (category_id, min_age, max_age, min_skill_level)
+SELECT id, 6, NULL, 0 FROM group_category WHERE name = 'начинающие';
+
+INSERT INTO category_eligibility (category_id, min_age, max_age, min_skill_level)
+SELECT id, 12, 17, 1 FROM group_category WHERE name = 'подростки';
+
+INSERT INTO category_eligibility (category_id, min_age, max_age, min_skill_level)
+SELECT id, 18, NULL, 1 FROM group_category WHERE name = 'взрослые';
+
+INSERT INTO category_eligibility (category_id, min_age, max_age, min_skill_level)
+SELECT id, 10, NULL, 3 FROM group_category WHERE name = 'спортсмены';
+
+INSERT INTO permissions (code) VALUES ('categories:write');
+
+INSERT INTO roles_permissions (role_id, permission_id)
+SELECT r.id, p.id FROM roles r, permissions p
+WHERE r.name = 'админ' AND p.code = 'categories:write';
@@ -0,0 +1,19 @@
+ALTER TABLE pools ADD COLUMN type VARCHAR(20);
+UPDATE pools p SET type = pt.name FROM pool_types pt WHERE pt.id = p.type_id;
+ALTER TABLE pools ALTER COLUMN type SET NOT NULL;
+ALTER TABLE pools ADD CONSTRAINT pools_type_check CHECK (type IN ('Спортивный', 'Оздоровительный', 'комбинированный'));
+ALTER TABLE pools DROP COLUMN type_id;
+
+DROP TABLE IF EXISTS pool_types;
+
+ALTER TABLE group_category DROP CONSTRAINT group_category_name_key;
+ALTER TABLE group_category DROP CONSTRAINT group_category_code_key;
+ALTER TABLE group_category DROP COLUMN translations;
+ALTER TABLE group_category DROP COLUMN code;
+ALTER TABLE group_category ADD CONSTRAINT group_category_name_check CHECK (name IN ('начинающие', 'подростки', 'взрослые', 'спортсмены'));
+
+ALTER TABLE roles DROP CONSTRAINT roles_name_key;
+ALTER TABLE roles DROP CONSTRAINT roles_code_key;
+ALTER TABLE roles DROP COLUMN translations;
+ALTER TABLE roles DROP COLUMN code;
+ALTER TABLE roles ADD CONSTRAINT roles_name_check CHECK (name IN ('тренер', 'клиент', 'админ'));
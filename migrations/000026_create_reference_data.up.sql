@@ -0,0 +1,45 @@
+-- roles and group categories become editable lookup tables: the fixed lists
+-- of names go away, and a stable code is what the application refers to
+ALTER TABLE roles DROP CONSTRAINT roles_name_check;
+ALTER TABLE roles ADD COLUMN code TEXT;
+ALTER TABLE roles ADD COLUMN translations JSONB NOT NULL DEFAULT '{}';
+
+UPDATE roles SET code = 'trainer', translations = '{"en": "Trainer"}' WHERE name = 'тренер';
+UPDATE roles SET code = 'client', translations = '{"en": "Client"}' WHERE name = 'клиент';
+UPDATE roles SET code = 'admin', translations = '{"en": "Administrator"}' WHERE name = 'админ';
+
+ALTER TABLE roles ALTER COLUMN code SET NOT NULL;
+ALTER TABLE roles ADD CONSTRAINT roles_code_key UNIQUE (code);
+ALTER TABLE roles ADD CONSTRAINT roles_name_key UNIQUE (name);
+
+ALTER TABLE group_category DROP CONSTRAINT group_category_name_check;
+ALTER TABLE group_category ADD COLUMN code TEXT;
+ALTER TABLE group_category ADD COLUMN translations JSONB NOT NULL DEFAULT '{}';
+
+UPDATE group_category SET code = 'beginners', translations = '{"en": "Beginners"}' WHERE name = 'начинающие';
+UPDATE group_category SET code = 'teens', translations = '{"en": "Teenagers"}' WHERE name = 'подростки';
+UPDATE group_category SET code = 'adults', translations = '{"en": "Adults"}' WHERE name = 'взрослые';
+UPDATE group_category SET code = 'athletes', translations = '{"en": "Athletes"}' WHERE name = 'спортсмены';
+
+ALTER TABLE group_category ALTER COLUMN code SET NOT NULL;
+ALTER TABLE group_category ADD CONSTRAINT group_category_code_key UNIQUE (code);
+ALTER TABLE group_category ADD CONSTRAINT group_category_name_key UNIQUE (name);
+
+CREATE TABLE pool_types (
+    id SERIAL PRIMARY KEY,
+    code TEXT NOT NULL,
+    name TEXT NOT NULL,
+    translations JSONB NOT NULL DEFAULT '{}',
+    CONSTRAINT pool_types_code_key UNIQUE (code),
+    CONSTRAINT pool_types_name_key UNIQUE (name)
+);
+
+INSERT INTO pool_types (code, name, translations) VALUES
+('sport', 'Спортивный', '{"en": "Sports"}'),
+('health', 'Оздоровительный', '{"en": "Recreational"}'),
+('combined', 'комбинированный', '{"en": "Combined"}');
+
+ALTER TABLE pools ADD COLUMN type_id INT REFERENCES pool_types(id);
+UPDATE pools p SET type_id = pt.id FROM pool_types pt WHERE pt.name = p.type;
+ALTER TABLE pools ALTER COLUMN type_id SET NOT NULL;
+ALTER TABLE pools DROP COLUMN type;
@@ -0,0 +1,10 @@
+ALTER TABLE trainers ADD COLUMN pool_id INT REFERENCES pools(id);
+
+UPDATE trainers t SET pool_id = (
+    SELECT a.pool_id FROM trainer_assignments a WHERE a.trainer_id = t.id ORDER BY a.starts_on DESC, a.id DESC LIMIT 1
+);
+
+DELETE FROM trainers WHERE pool_id IS NULL;
+ALTER TABLE trainers ALTER COLUMN pool_id SET NOT NULL;
+
+DROP TABLE IF EXISTS trainer_assignments;
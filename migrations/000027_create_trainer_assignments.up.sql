@@ -0,0 +1,23 @@
+-- trainers keep one record per user, the pools they work at move to a
+-- history of assignments with effective dates
+CREATE TABLE trainer_assignments (
+    id BIGSERIAL PRIMARY KEY,
+    trainer_id INT NOT NULL REFERENCES trainers(id) ON DELETE CASCADE,
+    pool_id INT NOT NULL REFERENCES pools(id),
+    starts_on DATE NOT NULL,
+    ends_on DATE,
+    days_of_week INT[],
+    CONSTRAINT trainer_assignments_dates_check CHECK (ends_on IS NULL OR ends_on >= starts_on),
+    CONSTRAINT trainer_assignments_days_check CHECK (days_of_week IS NULL OR days_of_week <@ ARRAY[1, 2, 3, 4, 5, 6, 7])
+);
+
+CREATE INDEX trainer_assignments_trainer_id_idx ON trainer_assignments (trainer_id);
+CREATE INDEX trainer_assignments_pool_id_idx ON trainer_assignments (pool_id);
+
+-- existing attachments count from the earliest subscription on record, so
+-- past revenue stays attributed to the trainers who ran the groups
+INSERT INTO trainer_assignments (trainer_id, pool_id, starts_on)
+SELECT t.id, t.pool_id, LEAST(u.created_at::date, COALESCE((SELECT min(date_start)::date FROM user_subscriptions), CURRENT_DATE))
+FROM trainers t JOIN users u ON u.id = t.user_id;
+
+ALTER TABLE trainers DROP COLUMN pool_id;
@@ -0,0 +1,4 @@
+DELETE FROM permissions WHERE code IN ('availability:read', 'availability:write', 'time_off:read', 'time_off:write');
+
+DROP TABLE IF EXISTS trainer_availability;
+DROP TABLE IF EXISTS trainer_time_off;
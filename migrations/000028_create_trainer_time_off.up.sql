@@ -0,0 +1,38 @@
+CREATE TABLE trainer_time_off (
+    id BIGSERIAL PRIMARY KEY,
+    trainer_id INT NOT NULL REFERENCES trainers(id) ON DELETE CASCADE,
+    kind TEXT NOT NULL CHECK (kind IN ('vacation', 'sick_leave', 'other')),
+    starts_on DATE NOT NULL,
+    ends_on DATE NOT NULL,
+    note TEXT NOT NULL DEFAULT '',
+    status TEXT NOT NULL DEFAULT 'pending' CHECK (status IN ('pending', 'approved', 'rejected')),
+    created_at TIMESTAMP(0) WITH TIME ZONE NOT NULL DEFAULT NOW(),
+    decided_by INT REFERENCES users(id) ON DELETE SET NULL,
+    decided_at TIMESTAMP(0) WITH TIME ZONE,
+    CONSTRAINT trainer_time_off_dates_check CHECK (ends_on >= starts_on)
+);
+
+CREATE INDEX trainer_time_off_trainer_id_idx ON trainer_time_off (trainer_id, starts_on);
+
+-- the weekly hours a trainer can work; a trainer without any windows is
+-- available at all times
+CREATE TABLE trainer_availability (
+    id BIGSERIAL PRIMARY KEY,
+    trainer_id INT NOT NULL REFERENCES trainers(id) ON DELETE CASCADE,
+    day_of_week INT NOT NULL CHECK (day_of_week BETWEEN 1 AND 7),
+    starts_at TIME WITHOUT TIME ZONE NOT NULL,
+    ends_at TIME WITHOUT TIME ZONE NOT NULL,
+    CONSTRAINT trainer_availability_times_check CHECK (ends_at > starts_at)
+);
+
+CREATE INDEX trainer_availability_trainer_id_idx ON trainer_availability (trainer_id);
+
+INSERT INTO permissions (code) VALUES ('availability:read'), ('availability:write'), ('time_off:read'), ('time_off:write');
+
+INSERT INTO roles_permissions (role_id, permission_id)
+SELECT r.id, p.id FROM roles r, permissions p
+WHERE r.code IN ('trainer', 'admin') AND p.code IN ('availability:read', 'availability:write');
+
+INSERT INTO roles_permissions (role_id, permission_id)
+SELECT r.id, p.id FROM roles r, permissions p
+WHERE r.code = 'admin' AND p.code IN ('time_off:read', 'time_off:write');
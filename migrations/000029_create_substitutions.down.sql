@@ -0,0 +1,8 @@
+DELETE FROM permissions WHERE code IN ('substitutes:read', 'substitutes:write');
+
+DROP INDEX IF EXISTS training_sessions_substitute_trainer_id_idx;
+
+ALTER TABLE training_sessions DROP COLUMN substituted_at;
+ALTER TABLE training_sessions DROP COLUMN substitute_trainer_id;
+
+DROP TABLE IF EXISTS trainer_qualifications;
@@ -0,0 +1,22 @@
+-- the categories a trainer may teach; trainers are qualified for the
+-- categories of the groups they already run
+CREATE TABLE trainer_qualifications (
+    trainer_id INT NOT NULL REFERENCES trainers(id) ON DELETE CASCADE,
+    category_id INT NOT NULL REFERENCES group_category(id) ON DELETE CASCADE,
+    PRIMARY KEY (trainer_id, category_id)
+);
+
+INSERT INTO trainer_qualifications (trainer_id, category_id)
+SELECT DISTINCT trainer_id, category_id FROM training_groups;
+
+ALTER TABLE training_sessions ADD COLUMN substitute_trainer_id INT REFERENCES trainers(id);
+ALTER TABLE training_sessions ADD COLUMN substituted_at TIMESTAMP(0) WITH TIME ZONE;
+
+CREATE INDEX training_sessions_substitute_trainer_id_idx ON training_sessions (substitute_trainer_id)
+WHERE substitute_trainer_id IS NOT NULL;
+
+INSERT INTO permissions (code) VALUES ('substitutes:read'), ('substitutes:write');
+
+INSERT INTO roles_permissions (role_id, permission_id)
+SELECT r.id, p.id FROM roles r, permissions p
+WHERE r.code = 'admin' AND p.code IN ('substitutes:read', 'substitutes:write');
@@ -0,0 +1,5 @@
+ALTER TABLE training_sessions DROP COLUMN lanes;
+ALTER TABLE training_sessions DROP COLUMN duration_minutes;
+ALTER TABLE schedules DROP COLUMN lanes;
+ALTER TABLE schedules DROP COLUMN duration_minutes;
+ALTER TABLE pools DROP COLUMN lanes;
@@ -0,0 +1,25 @@
+ALTER TABLE pools ADD COLUMN lanes SMALLINT NOT NULL DEFAULT 4 CHECK (lanes BETWEEN 1 AND 50);
+
+ALTER TABLE schedules ADD COLUMN duration_minutes SMALLINT NOT NULL DEFAULT 60 CHECK (duration_minutes BETWEEN 15 AND 240);
+ALTER TABLE schedules ADD COLUMN lanes SMALLINT NOT NULL DEFAULT 1 CHECK (lanes >= 1);
+
+-- sessions keep the duration and lanes they were generated with, so later
+-- changes to the schedule don't rewrite the past
+ALTER TABLE training_sessions ADD COLUMN duration_minutes SMALLINT NOT NULL DEFAULT 60 CHECK (duration_minutes BETWEEN 15 AND 240);
+ALTER TABLE training_sessions ADD COLUMN lanes SMALLINT NOT NULL DEFAULT 1 CHECK (lanes >= 1);
+
+-- pools that already hold more groups at once than the default number of
+-- lanes get enough lanes for them, existing schedules are taken as an hour
+UPDATE pools p SET lanes = GREATEST(p.lanes, x.peak)
+FROM (
+    SELECT g.pool_id, max((
+        SELECT count(*) FROM schedules s2 JOIN training_groups g2 ON g2.id = s2.group_id
+        WHERE g2.pool_id = g.pool_id AND g2.archived_at IS NULL AND s2.day_of_week = s.day_of_week
+        AND EXTRACT(EPOCH FROM s2.time_of_day) / 60 <= EXTRACT(EPOCH FROM s.time_of_day) / 60
+        AND EXTRACT(EPOCH FROM s2.time_of_day) / 60 > EXTRACT(EPOCH FROM s.time_of_day) / 60 - 60
+    )) AS peak
+    FROM schedules s JOIN training_groups g ON g.id = s.group_id
+    WHERE g.archived_at IS NULL
+    GROUP BY g.pool_id
+) x
+WHERE x.pool_id = p.id;
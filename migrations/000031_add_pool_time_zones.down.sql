@@ -0,0 +1,9 @@
+UPDATE training_sessions
+SET starts_at = (starts_at AT TIME ZONE 'Europe/Moscow') AT TIME ZONE current_setting('TimeZone')
+WHERE starts_at > NOW();
+
+ALTER TABLE user_subscriptions
+    ALTER COLUMN date_start TYPE TIMESTAMP(0) USING date_start AT TIME ZONE 'Europe/Moscow',
+    ALTER COLUMN date_end TYPE TIMESTAMP(0) USING date_end AT TIME ZONE 'Europe/Moscow';
+
+ALTER TABLE pools DROP COLUMN time_zone;
@@ -0,0 +1,16 @@
+-- schedules are in the local time of the pool; the pools open so far are
+-- all in Moscow time
+ALTER TABLE pools ADD COLUMN time_zone TEXT NOT NULL DEFAULT 'Europe/Moscow';
+
+-- subscription dates were written in the time of the server, which was
+-- Moscow time as well
+ALTER TABLE user_subscriptions
+    ALTER COLUMN date_start TYPE TIMESTAMP(0) WITH TIME ZONE USING date_start AT TIME ZONE 'Europe/Moscow',
+    ALTER COLUMN date_end TYPE TIMESTAMP(0) WITH TIME ZONE USING date_end AT TIME ZONE 'Europe/Moscow';
+
+-- upcoming sessions were generated in the time zone of the database session,
+-- they are moved to Moscow time so generating them again in the time of the
+-- pool finds them instead of adding duplicates
+UPDATE training_sessions
+SET starts_at = (starts_at AT TIME ZONE current_setting('TimeZone')) AT TIME ZONE 'Europe/Moscow'
+WHERE starts_at > NOW();
@@ -0,0 +1,3 @@
+DROP FUNCTION IF EXISTS pool_hours(INT, DATE);
+DROP TABLE IF EXISTS calendar_days;
+DROP TABLE IF EXISTS pool_opening_hours;
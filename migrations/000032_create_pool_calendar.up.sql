@@ -0,0 +1,52 @@
+-- weekly opening hours; a pool without any is open around the clock, one
+-- with some is closed on the days of the week it has none for
+CREATE TABLE IF NOT EXISTS pool_opening_hours (
+    pool_id INT NOT NULL REFERENCES pools(id) ON DELETE CASCADE,
+    day_of_week SMALLINT NOT NULL CHECK (day_of_week BETWEEN 1 AND 7),
+    opens_at TIME NOT NULL,
+    closes_at TIME NOT NULL,
+    PRIMARY KEY (pool_id, day_of_week),
+    CHECK (closes_at > opens_at)
+);
+
+-- exceptions to the weekly hours. Days without a pool come from the public
+-- production calendar and apply to every pool; days of a pool win over them.
+CREATE TABLE IF NOT EXISTS calendar_days (
+    id BIGSERIAL PRIMARY KEY,
+    pool_id INT REFERENCES pools(id) ON DELETE CASCADE,
+    day DATE NOT NULL,
+    kind TEXT NOT NULL CHECK (kind IN ('holiday', 'short_day', 'working_day', 'closure', 'special_hours')),
+    opens_at TIME,
+    closes_at TIME,
+    note TEXT NOT NULL DEFAULT '',
+    created_at TIMESTAMP(0) WITH TIME ZONE NOT NULL DEFAULT NOW(),
+    CHECK ((kind = 'special_hours') = (opens_at IS NOT NULL AND closes_at IS NOT NULL)),
+    CHECK (closes_at > opens_at),
+    CHECK ((pool_id IS NULL) = (kind IN ('holiday', 'short_day', 'working_day')))
+);
+
+CREATE UNIQUE INDEX IF NOT EXISTS calendar_days_pool_day_idx ON calendar_days (pool_id, day) WHERE pool_id IS NOT NULL;
+CREATE UNIQUE INDEX IF NOT EXISTS calendar_days_public_day_idx ON calendar_days (day) WHERE pool_id IS NULL;
+
+-- pool_hours returns when the pool is open on the day, or no row when it is
+-- closed. Holidays and closures close the pool, pre-holiday days close it an
+-- hour early like any other workplace.
+CREATE OR REPLACE FUNCTION pool_hours(pool INT, on_day DATE) RETURNS TABLE (opens_at TIME, closes_at TIME) AS $$
+    WITH exception AS (
+        SELECT c.kind, c.opens_at, c.closes_at FROM calendar_days c
+        WHERE c.day = on_day AND (c.pool_id = pool OR c.pool_id IS NULL)
+        ORDER BY c.pool_id IS NULL
+        LIMIT 1
+    ), weekly AS (
+        SELECT h.opens_at, h.closes_at FROM pool_opening_hours h
+        WHERE h.pool_id = pool AND h.day_of_week = EXTRACT(ISODOW FROM on_day)
+        UNION ALL
+        SELECT '00:00'::time, '24:00'::time
+        WHERE NOT EXISTS (SELECT 1 FROM pool_opening_hours h WHERE h.pool_id = pool)
+    )
+    SELECT e.opens_at, e.closes_at FROM exception e WHERE e.kind = 'special_hours'
+    UNION ALL
+    SELECT w.opens_at, CASE WHEN e.kind = 'short_day' THEN w.closes_at - interval '1 hour' ELSE w.closes_at END
+    FROM weekly w LEFT JOIN exception e ON true
+    WHERE e.kind IS NULL OR e.kind IN ('short_day', 'working_day')
+$$ LANGUAGE sql STABLE;
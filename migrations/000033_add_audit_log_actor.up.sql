@@ -0,0 +1,3 @@
+-- changes made by commands outside the API have no user or API key, the
+-- actor names the command instead
+ALTER TABLE audit_log ADD COLUMN actor TEXT NOT NULL DEFAULT '';